@@ -0,0 +1,259 @@
+package redcon
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupIP resolves the addresses of a hostname. Replaced by tests.
+var lookupIP = net.LookupIP
+
+// AccessList is an allowlist and denylist of the clients that may connect
+// to a server. Entries are IP addresses, CIDR networks, or hostnames.
+// Hostnames are re-resolved on an interval, which makes it possible to allow
+// clients in dynamic environments, such as VPN gateways or NAT egress pools,
+// without redeploying.
+//
+//   acl := &redcon.AccessList{
+//       Entries: []string{"10.0.0.0/8", "192.168.1.20", "vpn.example.com"},
+//       Deny:    []string{"10.6.0.0/16"},
+//       Reject:  "ERR access denied",
+//   }
+//   if err := acl.Start(); err != nil {
+//       log.Fatal(err)
+//   }
+//   defer acl.Stop()
+//   s.SetAccessList(acl)
+type AccessList struct {
+	// Entries are the allowed clients. Each entry is an IP address, a CIDR
+	// network such as "10.0.0.0/8", or a hostname. When there are no
+	// entries, all clients that are not denied are allowed.
+	Entries []string
+	// Deny are the denied clients, in the same form as Entries. A client
+	// that is denied is not allowed, even when it's in Entries.
+	Deny []string
+	// Reject is the error that is sent to the clients that are not allowed
+	// before the connection is closed, such as "ERR access denied", or a
+	// SERVER_ERROR for memcached clients. When empty, the connection is
+	// closed without a reply.
+	Reject string
+	// RefreshInterval is the time between hostname resolutions. Defaults to
+	// one minute.
+	RefreshInterval time.Duration
+	// RefreshError is an optional function that is called when a hostname
+	// cannot be resolved. The previously resolved addresses of the hostname
+	// remain allowed.
+	RefreshError func(host string, err error)
+
+	mu      sync.RWMutex
+	started bool // the entries have been parsed
+	allow   accessEntries
+	deny    accessEntries
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// accessEntries are the parsed entries of an AccessList.
+type accessEntries struct {
+	nets  []*net.IPNet
+	hosts map[string][]net.IP
+}
+
+// parseAccessEntries parses the IP addresses and CIDR networks of the
+// entries. The addresses of the hostnames are resolved by Refresh.
+func parseAccessEntries(entries []string) (accessEntries, error) {
+	e := accessEntries{hosts: make(map[string][]net.IP)}
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			_, ipnet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return e, err
+			}
+			e.nets = append(e.nets, ipnet)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			mask := net.CIDRMask(bits, bits)
+			e.nets = append(e.nets, &net.IPNet{IP: ip, Mask: mask})
+		} else if entry != "" {
+			e.hosts[entry] = nil
+		}
+	}
+	return e, nil
+}
+
+// contains returns true when the IP address matches an entry.
+func (e *accessEntries) contains(ip net.IP) bool {
+	for _, ipnet := range e.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	for _, ips := range e.hosts {
+		for _, hip := range ips {
+			if hip.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Start parses the entries, resolves the hostnames, and begins refreshing
+// the hostnames in the background.
+func (a *AccessList) Start() error {
+	allow, err := parseAccessEntries(a.Entries)
+	if err != nil {
+		return err
+	}
+	deny, err := parseAccessEntries(a.Deny)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	if a.stop != nil {
+		a.mu.Unlock()
+		return errors.New("already started")
+	}
+	a.started = true
+	a.allow = allow
+	a.deny = deny
+	if len(allow.hosts) > 0 || len(deny.hosts) > 0 {
+		interval := a.RefreshInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		a.stop = make(chan struct{})
+		a.done = make(chan struct{})
+		go a.run(interval, a.stop, a.done)
+	}
+	a.mu.Unlock()
+	a.Refresh()
+	return nil
+}
+
+// Stop stops refreshing the hostnames. The list keeps allowing and denying
+// the clients that were last resolved.
+func (a *AccessList) Stop() {
+	a.mu.Lock()
+	stop, done := a.stop, a.done
+	a.stop, a.done = nil, nil
+	a.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (a *AccessList) run(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			a.Refresh()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Refresh resolves the hostnames immediately.
+func (a *AccessList) Refresh() {
+	a.mu.RLock()
+	var names []string
+	for _, e := range []*accessEntries{&a.allow, &a.deny} {
+		for host := range e.hosts {
+			names = append(names, host)
+		}
+	}
+	a.mu.RUnlock()
+	for _, host := range names {
+		ips, err := lookupIP(host)
+		if err != nil {
+			if a.RefreshError != nil {
+				a.RefreshError(host, err)
+			}
+			continue
+		}
+		a.mu.Lock()
+		for _, e := range []*accessEntries{&a.allow, &a.deny} {
+			if _, ok := e.hosts[host]; ok {
+				e.hosts[host] = ips
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// Allow returns true when the address is allowed by the list, which is when
+// it's not denied, and it's in the entries or there are no entries.
+// Addresses that are not IP addresses, such as those of unix sockets, are
+// always allowed. A list that has not been started allows no one, so a
+// list that was never loaded fails closed.
+func (a *AccessList) Allow(addr net.Addr) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !a.started {
+		return false
+	}
+	ip := addrIP(addr)
+	if ip == nil {
+		return true
+	}
+	if a.deny.contains(ip) {
+		return false
+	}
+	if len(a.allow.nets) == 0 && len(a.allow.hosts) == 0 {
+		return true
+	}
+	return a.allow.contains(ip)
+}
+
+// addrIP returns the IP address of a network address, or nil when there is
+// none.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// SetAccessList sets the list of clients that are allowed to connect to the
+// server. Connections from other clients are closed as soon as they're
+// accepted, before the accept function of the server is called, after
+// sending the Reject error of the list, if any. The list must be started,
+// because a list that has not been started refuses all clients. A nil list
+// allows all clients.
+func (s *Server) SetAccessList(a *AccessList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acl = a
+}
+
+// allowed returns true when the connection is allowed by the access list,
+// otherwise it returns the Reject error of the list.
+func (s *Server) allowed(conn net.Conn) (bool, string) {
+	s.mu.Lock()
+	acl := s.acl
+	s.mu.Unlock()
+	if acl == nil || acl.Allow(conn.RemoteAddr()) {
+		return true, ""
+	}
+	return false, acl.Reject
+}
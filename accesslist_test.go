@@ -0,0 +1,178 @@
+package redcon
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccessList(t *testing.T) {
+	var mu sync.Mutex
+	resolved := map[string]string{"gw.example.com": "203.0.113.7"}
+	lookupIP = func(host string) ([]net.IP, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ip, ok := resolved[host]; ok {
+			return []net.IP{net.ParseIP(ip)}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookupIP = net.LookupIP }()
+
+	acl := &AccessList{
+		Entries: []string{"10.0.0.0/8", "192.168.1.20", "gw.example.com",
+			"::1"},
+		RefreshInterval: time.Millisecond * 10,
+	}
+	if err := acl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer acl.Stop()
+	allow := func(ip string) bool {
+		return acl.Allow(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234})
+	}
+	for ip, exp := range map[string]bool{
+		"10.1.2.3":     true,
+		"11.1.2.3":     false,
+		"192.168.1.20": true,
+		"192.168.1.21": false,
+		"203.0.113.7":  true,
+		"203.0.113.8":  false,
+		"::1":          true,
+	} {
+		if allow(ip) != exp {
+			t.Fatalf("%s: expected %v, got %v", ip, exp, !exp)
+		}
+	}
+	if !acl.Allow(&net.UnixAddr{Name: "/tmp/redcon.sock", Net: "unix"}) {
+		t.Fatal("expected unix address to be allowed")
+	}
+
+	// the hostname moves to a new address
+	mu.Lock()
+	resolved["gw.example.com"] = "203.0.113.8"
+	mu.Unlock()
+	start := time.Now()
+	for !allow("203.0.113.8") {
+		if time.Since(start) > time.Second*5 {
+			t.Fatal("timeout waiting for refresh")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if allow("203.0.113.7") {
+		t.Fatal("expected old address to be removed")
+	}
+
+	// failed resolutions keep the previous addresses
+	mu.Lock()
+	delete(resolved, "gw.example.com")
+	mu.Unlock()
+	acl.Refresh()
+	if !allow("203.0.113.8") {
+		t.Fatal("expected previous address to be kept")
+	}
+
+	if err := (&AccessList{Entries: []string{"10.0.0.0/33"}}).Start(); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := (&AccessList{Deny: []string{"10.0.0.0/33"}}).Start(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAccessListDeny(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("203.0.113.7")}, nil
+	}
+	defer func() { lookupIP = net.LookupIP }()
+	for _, tt := range []struct {
+		acl *AccessList
+		exp map[string]bool
+	}{
+		{&AccessList{Deny: []string{"10.6.0.0/16", "bad.example.com"}},
+			map[string]bool{
+				"10.1.2.3":    true,
+				"10.6.2.3":    false,
+				"203.0.113.7": false,
+				"203.0.113.8": true,
+			}},
+		{&AccessList{Entries: []string{"10.0.0.0/8"},
+			Deny: []string{"10.6.0.0/16"}},
+			map[string]bool{
+				"10.1.2.3": true,
+				"10.6.2.3": false,
+				"11.1.2.3": false,
+			}},
+	} {
+		if err := tt.acl.Start(); err != nil {
+			t.Fatal(err)
+		}
+		for ip, exp := range tt.exp {
+			addr := &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}
+			if tt.acl.Allow(addr) != exp {
+				t.Fatalf("%s: expected %v, got %v", ip, exp, !exp)
+			}
+		}
+		tt.acl.Stop()
+	}
+}
+
+func TestServerAccessList(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	acl := &AccessList{Entries: []string{"10.0.0.0/8"}}
+	if err := acl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetAccessList(acl)
+	addr := testServe(t, s)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// the reject error is sent before closing
+	acl = &AccessList{Deny: []string{"127.0.0.0/8", "::1"},
+		Reject: "ERR access denied"}
+	if err := acl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetAccessList(acl)
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, ""); resp != "-ERR access denied\r\n" {
+		t.Fatalf("expected %q, got %q", "-ERR access denied\r\n", resp)
+	}
+
+	// a list that was never started refuses everyone
+	s.SetAccessList(&AccessList{Reject: "ERR access denied"})
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, ""); resp != "-ERR access denied\r\n" {
+		t.Fatalf("expected %q, got %q", "-ERR access denied\r\n", resp)
+	}
+
+	s.SetAccessList(nil)
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "ping\r\n"); resp != "+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "+OK\r\n", resp)
+	}
+}
@@ -0,0 +1,567 @@
+package redcon
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ACL is a registry of users and their permissions, like the access control
+// lists of Redis 6. Each user has passwords, the commands that it may run,
+// and the patterns of the keys that it may access. The "default" user, which
+// is used by connections that have not authenticated, has no password and
+// may run all commands on all keys, until it's changed using SetUser.
+//
+//   acl := redcon.NewACL()
+//   acl.SetUser("default", "resetpass", ">secret")
+//   acl.SetUser("app", "on", ">apppass", "~cache:*", "+@read", "+set")
+//   s := redcon.NewServerHandler(addr, acl.Handler(mux), nil, nil)
+//   s.SetAuth(acl.Auth)
+//
+// The permissions are enforced by the handler that Handler returns, which
+// also answers the ACL command. Channel permissions and selectors are not
+// supported.
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+}
+
+// aclUser is a user of an ACL. A user is replaced, and not changed, when its
+// rules are changed, so it can be read without holding the lock of the ACL.
+type aclUser struct {
+	name      string
+	on        bool
+	nopass    bool
+	passwords []string  // the hex encoded SHA-256 of the passwords
+	keys      []string  // the key patterns
+	cmds      []aclRule // applied in order, the last matching rule wins
+}
+
+// aclRule allows or denies a command, a category of commands, or all
+// commands, which is the "all" category.
+type aclRule struct {
+	allow    bool
+	category bool
+	name     string
+}
+
+// NewACL returns an ACL that has the "default" user, with no password and
+// permission to run all commands on all keys.
+func NewACL() *ACL {
+	a := &ACL{users: make(map[string]*aclUser)}
+	a.users["default"] = &aclUser{
+		name:   "default",
+		on:     true,
+		nopass: true,
+		keys:   []string{"*"},
+		cmds:   []aclRule{{allow: true, category: true, name: "all"}},
+	}
+	return a
+}
+
+// SetUser creates the user, or changes the existing user, by applying the
+// rules in order, like the ACL SETUSER command of Redis. A new user is off,
+// and has no passwords, keys, or commands. The rules are:
+//
+//   on, off           enables or disables the user
+//   >password         adds a password
+//   <password         removes a password
+//   #hash, !hash      adds or removes the hex encoded SHA-256 of a password
+//   nopass            removes the passwords, and allows any password
+//   resetpass         removes the passwords, and the nopass flag
+//   ~pattern          allows the keys that match the glob-style pattern
+//   allkeys           allows all keys, which is the same as ~*
+//   resetkeys         removes the key patterns
+//   +command          allows a command, or a subcommand, such as +config|get
+//   -command          denies a command, or a subcommand
+//   +@category        allows the commands of the category, see CommandInfo
+//   -@category        denies the commands of the category
+//   allcommands       allows all commands, which is the same as +@all
+//   nocommands        denies all commands, which is the same as -@all
+//   reset             resets the user to the state of a new user
+//
+// The user is not changed when a rule is invalid. The message of the
+// returned error is the reply that Redis sends to the client.
+func (a *ACL) SetUser(name string, rules ...string) error {
+	if strings.ContainsAny(name, " \x00") {
+		return errors.New("ERR Usernames can't contain spaces or null " +
+			"characters")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := &aclUser{name: name}
+	if old := a.users[name]; old != nil {
+		*u = *old
+		u.passwords = append([]string(nil), old.passwords...)
+		u.keys = append([]string(nil), old.keys...)
+		u.cmds = append([]aclRule(nil), old.cmds...)
+	}
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return errors.New("ERR Error in ACL SETUSER modifier '" + rule +
+				"': " + err.Error())
+		}
+	}
+	a.users[name] = u
+	return nil
+}
+
+// apply applies a rule of SetUser to the user.
+func (u *aclUser) apply(rule string) error {
+	if rule != "" {
+		switch arg := rule[1:]; rule[0] {
+		case '>':
+			u.addPassword(aclHash(arg))
+			return nil
+		case '<':
+			return u.removePassword(aclHash(arg))
+		case '#':
+			if !aclValidHash(arg) {
+				return errors.New("The password hash must be exactly 64 " +
+					"characters and contain only lowercase hexadecimal " +
+					"characters")
+			}
+			u.addPassword(arg)
+			return nil
+		case '!':
+			return u.removePassword(arg)
+		case '~':
+			u.keys = append(u.keys, arg)
+			return nil
+		case '+', '-':
+			r := aclRule{allow: rule[0] == '+', name: asciiLower([]byte(arg))}
+			if strings.HasPrefix(r.name, "@") {
+				r.category = true
+				r.name = r.name[1:]
+			}
+			if r.name == "" {
+				break
+			}
+			u.addRule(r)
+			return nil
+		}
+	}
+	switch asciiLower([]byte(rule)) {
+	case "on":
+		u.on = true
+	case "off":
+		u.on = false
+	case "nopass":
+		u.passwords = nil
+		u.nopass = true
+	case "resetpass":
+		u.passwords = nil
+		u.nopass = false
+	case "allkeys":
+		u.keys = append(u.keys, "*")
+	case "resetkeys":
+		u.keys = nil
+	case "allcommands":
+		u.addRule(aclRule{allow: true, category: true, name: "all"})
+	case "nocommands":
+		u.addRule(aclRule{allow: false, category: true, name: "all"})
+	case "reset":
+		*u = aclUser{name: u.name}
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+	u.nopass = false
+}
+
+func (u *aclUser) removePassword(hash string) error {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("The password you are trying to remove from the user " +
+		"does not exist")
+}
+
+// addRule adds a command rule. A rule for all commands overrides the rules
+// before it, so they're removed.
+func (u *aclUser) addRule(r aclRule) {
+	if r.category && r.name == "all" {
+		u.cmds = u.cmds[:0]
+	}
+	u.cmds = append(u.cmds, r)
+}
+
+// aclHash returns the hex encoded SHA-256 of a password.
+func aclHash(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// aclValidHash returns true when hash is a hex encoded SHA-256 in lowercase.
+func aclValidHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		c := hash[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// DelUser removes the user, and returns false when there is no such user.
+// The "default" user can't be removed. Connections that are authenticated
+// as the user are denied all commands.
+func (a *ACL) DelUser(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if name == "default" || a.users[name] == nil {
+		return false
+	}
+	delete(a.users, name)
+	return true
+}
+
+// Users returns the sorted names of the users.
+func (a *ACL) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// user returns the user with the name, or nil when there is none.
+func (a *ACL) user(name string) *aclUser {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[name]
+}
+
+// Auth checks the password of the user, which must be on, and authenticates
+// the connection as the user when it's valid. It can be passed to
+// Server.SetAuth, or to the Auth of a HelloConfig.
+func (a *ACL) Auth(conn Conn, user, pass string) bool {
+	u := a.user(user)
+	if u == nil || !u.on {
+		return false
+	}
+	ok := u.nopass
+	hash := aclHash(pass)
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			ok = true
+		}
+	}
+	if ok {
+		if c := baseConn(conn); c != nil {
+			c.setUser(user)
+		}
+	}
+	return ok
+}
+
+// ConnUser returns the name of the ACL user that the connection has
+// authenticated as, which is "default" when it has not authenticated.
+func ConnUser(conn Conn) string {
+	if c := baseConn(conn); c != nil {
+		c.vmu.Lock()
+		defer c.vmu.Unlock()
+		if c.user != "" {
+			return c.user
+		}
+	}
+	return "default"
+}
+
+// setUser sets the ACL user of the connection, where empty is the default
+// user.
+func (c *conn) setUser(user string) {
+	c.vmu.Lock()
+	c.user = user
+	c.vmu.Unlock()
+}
+
+// allowed returns true when the rules of the user allow the command, whose
+// key is the name of the command, or the command and subcommand that are
+// separated by a '|', such as "config|get".
+func (u *aclUser) allowed(key string, categories []string) bool {
+	var allow bool
+	for _, r := range u.cmds {
+		var match bool
+		switch {
+		case r.category:
+			match = r.name == "all"
+			for _, cat := range categories {
+				match = match || asciiEqualFold(cat, r.name)
+			}
+		case strings.Contains(r.name, "|"):
+			match = key == r.name
+		default:
+			match = key == r.name || strings.HasPrefix(key, r.name+"|")
+		}
+		if match {
+			allow = r.allow
+		}
+	}
+	return allow
+}
+
+// allowedKey returns true when the key matches a key pattern of the user.
+func (u *aclUser) allowedKey(key []byte) bool {
+	for _, pattern := range u.keys {
+		if globMatch(pattern, string(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the NOPERM error of the user for running the command with
+// the keys, or an empty string when the user has permission.
+func (a *ACL) check(user, key string, categories []string,
+	keys [][]byte,
+) string {
+	u := a.user(user)
+	if u == nil || !u.allowed(key, categories) {
+		return "NOPERM User " + user + " has no permissions to run the '" +
+			key + "' command"
+	}
+	for _, k := range keys {
+		if !u.allowedKey(k) {
+			return "NOPERM No permissions to access a key"
+		}
+	}
+	return ""
+}
+
+// Handler returns a Handler that enforces the permissions of the users
+// before passing the commands to the mux, and answers the ACL command:
+//
+//   ACL WHOAMI
+//   ACL USERS
+//   ACL LIST
+//   ACL GETUSER username
+//   ACL SETUSER username [rule ...]
+//   ACL DELUSER username [username ...]
+//
+// The categories and the keys of a command are those of the CommandInfo that
+// it was registered with, see ServeMux.HandleCommand. Commands that were
+// registered without one are only matched by name and by the "all"
+// category. The ACL subcommands are in the "admin", "slow", and "dangerous"
+// categories, except for WHOAMI, which is only "slow". Commands that are
+// denied are replied to with a NOPERM error.
+func (a *ACL) Handler(mux *ServeMux) Handler {
+	return HandlerFunc(func(conn Conn, cmd Command) {
+		var key string
+		var categories []string
+		var keys [][]byte
+		if cmd.Is("acl") {
+			key = "acl"
+			if len(cmd.Args) > 1 {
+				key += "|" + asciiLower(cmd.Args[1])
+			}
+			categories = []string{"admin", "slow", "dangerous"}
+			if key == "acl|whoami" {
+				categories = []string{"slow"}
+			}
+		} else {
+			key = mux.commandKey(cmd.Args)
+			if info := mux.lookupInfo(cmd.Args); info != nil {
+				categories = info.Categories
+				if info.validArity(len(cmd.Args)) {
+					keys = info.keys(cmd.Args)
+				}
+			}
+		}
+		if err := a.check(ConnUser(conn), key, categories, keys); err != "" {
+			conn.WriteError(err)
+			return
+		}
+		if cmd.Is("acl") {
+			a.serveACL(conn, cmd)
+			return
+		}
+		mux.ServeRESP(conn, cmd)
+	})
+}
+
+// commandKey returns the key of the command in the arguments, which includes
+// the subcommand when it's registered.
+func (m *ServeMux) commandKey(args [][]byte) string {
+	command := asciiLower(args[0])
+	if m.containers[command] && len(args) > 1 {
+		sub := command + "|" + asciiLower(args[1])
+		if _, ok := m.handlers[sub]; ok {
+			return sub
+		}
+	}
+	return command
+}
+
+// serveACL handles the ACL command.
+func (a *ACL) serveACL(conn Conn, cmd Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for 'acl' command")
+		return
+	}
+	sub := asciiLower(cmd.Args[1])
+	args := cmd.Args[2:]
+	var valid bool
+	switch sub {
+	case "whoami", "users", "list":
+		valid = len(args) == 0
+	case "getuser":
+		valid = len(args) == 1
+	case "setuser", "deluser":
+		valid = len(args) > 0
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) +
+			"'. Try ACL HELP.")
+		return
+	}
+	if !valid {
+		conn.WriteError("ERR wrong number of arguments for 'acl|" + sub +
+			"' command")
+		return
+	}
+	switch sub {
+	case "whoami":
+		conn.WriteBulkString(ConnUser(conn))
+	case "users":
+		users := a.Users()
+		conn.WriteArray(len(users))
+		for _, name := range users {
+			conn.WriteBulkString(name)
+		}
+	case "list":
+		var lines []string
+		for _, name := range a.Users() {
+			if u := a.user(name); u != nil {
+				lines = append(lines, "user "+name+" "+u.describe())
+			}
+		}
+		conn.WriteArray(len(lines))
+		for _, line := range lines {
+			conn.WriteBulkString(line)
+		}
+	case "getuser":
+		u := a.user(string(args[0]))
+		if u == nil {
+			conn.WriteNull()
+			return
+		}
+		flags := []string{"off"}
+		if u.on {
+			flags[0] = "on"
+		}
+		if u.nopass {
+			flags = append(flags, "nopass")
+		}
+		conn.WriteMap(4)
+		conn.WriteBulkString("flags")
+		conn.WriteArray(len(flags))
+		for _, flag := range flags {
+			conn.WriteBulkString(flag)
+		}
+		conn.WriteBulkString("passwords")
+		conn.WriteArray(len(u.passwords))
+		for _, p := range u.passwords {
+			conn.WriteBulkString(p)
+		}
+		conn.WriteBulkString("commands")
+		conn.WriteBulkString(u.describeCommands())
+		conn.WriteBulkString("keys")
+		conn.WriteBulkString(u.describeKeys())
+	case "setuser":
+		rules := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			rules[i] = string(arg)
+		}
+		if err := a.SetUser(string(args[0]), rules...); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+	case "deluser":
+		for _, name := range args {
+			if string(name) == "default" {
+				conn.WriteError("ERR The 'default' user cannot be removed")
+				return
+			}
+		}
+		var n int
+		for _, name := range args {
+			if a.DelUser(string(name)) {
+				n++
+			}
+		}
+		conn.WriteInt(n)
+	}
+}
+
+// describe returns the rules of the user, like ACL LIST.
+func (u *aclUser) describe() string {
+	parts := []string{"off"}
+	if u.on {
+		parts[0] = "on"
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if keys := u.describeKeys(); keys != "" {
+		parts = append(parts, keys)
+	} else {
+		parts = append(parts, "resetkeys")
+	}
+	parts = append(parts, u.describeCommands())
+	return strings.Join(parts, " ")
+}
+
+// describeKeys returns the key patterns of the user, such as "~a:* ~b:*".
+func (u *aclUser) describeKeys() string {
+	parts := make([]string, len(u.keys))
+	for i, pattern := range u.keys {
+		parts[i] = "~" + pattern
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeCommands returns the command rules of the user, such as
+// "-@all +@read +set".
+func (u *aclUser) describeCommands() string {
+	var parts []string
+	if len(u.cmds) == 0 || !u.cmds[0].category || u.cmds[0].name != "all" {
+		parts = append(parts, "-@all")
+	}
+	for _, r := range u.cmds {
+		part := "-"
+		if r.allow {
+			part = "+"
+		}
+		if r.category {
+			part += "@"
+		}
+		parts = append(parts, part+r.name)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,147 @@
+package redcon
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestACLSetUser(t *testing.T) {
+	a := NewACL()
+	for _, tt := range []struct {
+		rules []string
+		exp   string
+	}{
+		{nil, "off resetkeys -@all"},
+		{[]string{"on", ">pass", "~a:*", "+@read", "-@dangerous", "+set"},
+			"on #" + aclHash("pass") + " ~a:* -@all +@read -@dangerous +set"},
+		{[]string{"allkeys", "nocommands", "+config|get"},
+			"on #" + aclHash("pass") + " ~a:* ~* -@all +config|get"},
+		{[]string{"<pass", "resetkeys", "allcommands", "-flushall"},
+			"on resetkeys +@all -flushall"},
+		{[]string{"nopass", "OFF"}, "off nopass resetkeys +@all -flushall"},
+		{[]string{"reset"}, "off resetkeys -@all"},
+	} {
+		if err := a.SetUser("bob", tt.rules...); err != nil {
+			t.Fatal(err)
+		}
+		if desc := a.user("bob").describe(); desc != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.rules, tt.exp, desc)
+		}
+	}
+	for _, tt := range []struct {
+		rule string
+		exp  string
+	}{
+		{"foo", "Syntax error"},
+		{"+", "Syntax error"},
+		{"+@", "Syntax error"},
+		{"<nope", "The password you are trying to remove from the user does " +
+			"not exist"},
+		{"#abc", "The password hash must be exactly 64 characters and " +
+			"contain only lowercase hexadecimal characters"},
+	} {
+		err := a.SetUser("bob", "on", tt.rule)
+		exp := "ERR Error in ACL SETUSER modifier '" + tt.rule + "': " + tt.exp
+		if err == nil || err.Error() != exp {
+			t.Fatalf("%q: expected %q, got %v", tt.rule, exp, err)
+		}
+	}
+	if a.user("bob").on {
+		t.Fatal("expected an invalid rule to leave the user unchanged")
+	}
+	if err := a.SetUser("bo b"); err == nil {
+		t.Fatal("expected error")
+	}
+	if users := strings.Join(a.Users(), " "); users != "bob default" {
+		t.Fatalf("expected %q, got %q", "bob default", users)
+	}
+	if a.DelUser("default") || !a.DelUser("bob") || a.DelUser("bob") {
+		t.Fatal("unexpected DelUser result")
+	}
+}
+
+func TestACLHandler(t *testing.T) {
+	a := NewACL()
+	a.SetUser("default", "resetpass", ">secret")
+	a.SetUser("app", "on", ">apppass", "~cache:*", "+@read", "+config|get",
+		"+acl|whoami")
+	a.SetUser("off", "off", "nopass", "allcommands")
+	mux := NewServeMux()
+	ok := func(conn Conn, cmd Command) { conn.WriteString("OK") }
+	mux.HandleCommandFunc(CommandInfo{
+		Name: "get", Arity: 2, FirstKey: 1, Categories: []string{"read"},
+	}, ok)
+	mux.HandleCommandFunc(CommandInfo{
+		Name: "mget", Arity: -2, FirstKey: 1, LastKey: -1,
+		Categories: []string{"read"},
+	}, ok)
+	mux.HandleCommandFunc(CommandInfo{
+		Name: "set", Arity: 3, FirstKey: 1, Categories: []string{"write"},
+	}, ok)
+	mux.HandleFunc("config get", ok)
+	mux.HandleFunc("config set", ok)
+	s := NewServerHandler("", a.Handler(mux), nil, nil)
+	s.SetAuth(a.Auth)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	bulk := func(s string) string {
+		return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+	}
+	wrongpass := "-WRONGPASS invalid username-password pair or user is " +
+		"disabled.\r\n"
+	for _, tt := range []struct{ cmd, exp string }{
+		{"get a", "-NOAUTH Authentication required.\r\n"},
+		{"auth wrong", wrongpass},
+		{"auth off any", wrongpass},
+		{"auth nobody any", wrongpass},
+		{"auth secret", "+OK\r\n"},
+		{"acl whoami", "$7\r\ndefault\r\n"},
+		{"set a 1", "+OK\r\n"},
+		{"auth app apppass", "+OK\r\n"},
+		{"acl whoami", "$3\r\napp\r\n"},
+		{"get cache:a", "+OK\r\n"},
+		{"get a", "-NOPERM No permissions to access a key\r\n"},
+		{"mget cache:a cache:b", "+OK\r\n"},
+		{"mget cache:a b", "-NOPERM No permissions to access a key\r\n"},
+		{"set cache:a 1", "-NOPERM User app has no permissions to run the " +
+			"'set' command\r\n"},
+		{"config get x", "+OK\r\n"},
+		{"CONFIG SET x 1", "-NOPERM User app has no permissions to run the " +
+			"'config|set' command\r\n"},
+		{"acl users", "-NOPERM User app has no permissions to run the " +
+			"'acl|users' command\r\n"},
+		{"auth secret", "+OK\r\n"},
+		{"acl users", "*3\r\n$3\r\napp\r\n$7\r\ndefault\r\n$3\r\noff\r\n"},
+		{"acl setuser app +set", "+OK\r\n"},
+		{"acl setuser app foo", "-ERR Error in ACL SETUSER modifier 'foo': " +
+			"Syntax error\r\n"},
+		{"acl getuser nobody", "$-1\r\n"},
+		{"acl getuser off", "*8\r\n$5\r\nflags\r\n*2\r\n$3\r\noff\r\n" +
+			"$6\r\nnopass\r\n$9\r\npasswords\r\n*0\r\n$8\r\ncommands\r\n" +
+			"$5\r\n+@all\r\n$4\r\nkeys\r\n$0\r\n\r\n"},
+		{"acl list", "*3\r\n" +
+			bulk("user app on #"+aclHash("apppass")+" ~cache:* -@all "+
+				"+@read +config|get +acl|whoami +set") +
+			bulk("user default on #"+aclHash("secret")+" ~* +@all") +
+			bulk("user off off nopass resetkeys +@all")},
+		{"acl deluser default", "-ERR The 'default' user cannot be " +
+			"removed\r\n"},
+		{"acl deluser off nobody", ":1\r\n"},
+		{"acl foo", "-ERR unknown subcommand 'foo'. Try ACL HELP.\r\n"},
+		{"acl whoami x", "-ERR wrong number of arguments for 'acl|whoami' " +
+			"command\r\n"},
+	} {
+		resp := testDo(t, c, tt.cmd+"\r\n")
+		for len(resp) < len(tt.exp) {
+			resp += testDo(t, c, "")
+		}
+		if resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
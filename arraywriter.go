@@ -0,0 +1,204 @@
+package redcon
+
+import (
+	"math/big"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+// ArrayWriter writes an array whose number of elements is not known up
+// front. The elements are buffered, and End writes the array header followed
+// by the elements. Use BeginArray to create one.
+//
+//   arr := conn.BeginArray()
+//   for _, key := range keys {
+//       if matches(key) {
+//           arr.WriteBulkString(key)
+//       }
+//   }
+//   arr.End()
+//
+// Nothing is written to the client until End is called, unless the array is
+// streamed, see BeginStreamedArray. An ArrayWriter is not safe for
+// concurrent use.
+type ArrayWriter struct {
+	w      *Writer      // the writer that End writes to
+	parent *ArrayWriter // the enclosing array, for nested arrays
+	buf    Writer       // the buffered elements
+	count  int
+	ended  bool
+	isMap  bool // the elements are key/value pairs
+	stream bool // the elements are written to w as a RESP3 streamed type
+}
+
+// BeginArray starts an array whose number of elements is counted while the
+// elements are written. Call End on the returned ArrayWriter for writing
+// the array.
+func (w *Writer) BeginArray() *ArrayWriter {
+	arr := &ArrayWriter{w: w}
+	arr.buf.proto = w.Protocol()
+	return arr
+}
+
+// BeginStreamedArray starts an array that is streamed to RESP3 clients. The
+// elements are written directly to the writer, so they can be flushed to the
+// client while the array is being built, and End writes the terminator. This
+// allows for replies of unlimited size. For RESP2 clients the elements are
+// buffered, like BeginArray does.
+func (w *Writer) BeginStreamedArray() *ArrayWriter {
+	return w.beginStreamed(false)
+}
+
+// BeginStreamedMap is like BeginStreamedArray for a map. The keys and values
+// are written as elements, a key followed by its value. For RESP2 clients the
+// map is written as an array of the keys and values.
+func (w *Writer) BeginStreamedMap() *ArrayWriter {
+	return w.beginStreamed(true)
+}
+
+func (w *Writer) beginStreamed(isMap bool) *ArrayWriter {
+	arr := w.BeginArray()
+	arr.isMap = isMap
+	if arr.buf.proto >= resp.RESP3 {
+		arr.stream = true
+		if isMap {
+			w.WriteRaw([]byte("%?\r\n"))
+		} else {
+			w.WriteRaw([]byte("*?\r\n"))
+		}
+	}
+	return arr
+}
+
+// BeginArray starts a nested array. The nested array counts as a single
+// element, and it must be ended before the elements that follow it are
+// written.
+func (arr *ArrayWriter) BeginArray() *ArrayWriter {
+	child := &ArrayWriter{parent: arr}
+	child.buf.proto = arr.buf.proto
+	return child
+}
+
+// Len returns the number of elements that have been written.
+func (arr *ArrayWriter) Len() int {
+	return arr.count
+}
+
+// out returns the writer for the elements.
+func (arr *ArrayWriter) out() *Writer {
+	if arr.stream {
+		return arr.w
+	}
+	return &arr.buf
+}
+
+// End writes the array header and the elements. Calling End more than once
+// has no effect.
+func (arr *ArrayWriter) End() {
+	if arr.ended {
+		return
+	}
+	arr.ended = true
+	if arr.stream {
+		arr.w.WriteRaw([]byte(".\r\n"))
+		return
+	}
+	var b []byte
+	if arr.isMap {
+		b = resp.AppendMap(nil, arr.buf.proto, arr.count/2)
+	} else {
+		b = resp.AppendArray(nil, arr.count)
+	}
+	b = append(b, arr.buf.b...)
+	if arr.parent != nil {
+		arr.parent.WriteRaw(b)
+	} else {
+		arr.w.WriteRaw(b)
+	}
+	arr.buf.b = nil
+}
+
+// WriteRaw writes raw data as a single element. The data must be exactly
+// one complete reply.
+func (arr *ArrayWriter) WriteRaw(data []byte) {
+	arr.count++
+	arr.out().WriteRaw(data)
+}
+
+// WriteError writes an error element.
+func (arr *ArrayWriter) WriteError(msg string) {
+	arr.count++
+	arr.out().WriteError(msg)
+}
+
+// WriteString writes a string element.
+func (arr *ArrayWriter) WriteString(str string) {
+	arr.count++
+	arr.out().WriteString(str)
+}
+
+// WriteBulk writes a bulk bytes element.
+func (arr *ArrayWriter) WriteBulk(bulk []byte) {
+	arr.count++
+	arr.out().WriteBulk(bulk)
+}
+
+// WriteBulkString writes a bulk string element.
+func (arr *ArrayWriter) WriteBulkString(bulk string) {
+	arr.count++
+	arr.out().WriteBulkString(bulk)
+}
+
+// WriteInt writes an integer element.
+func (arr *ArrayWriter) WriteInt(num int) {
+	arr.count++
+	arr.out().WriteInt(num)
+}
+
+// WriteInt64 writes a 64-bit signed integer element.
+func (arr *ArrayWriter) WriteInt64(num int64) {
+	arr.count++
+	arr.out().WriteInt64(num)
+}
+
+// WriteUint64 writes a 64-bit unsigned integer element.
+func (arr *ArrayWriter) WriteUint64(num uint64) {
+	arr.count++
+	arr.out().WriteUint64(num)
+}
+
+// WriteFloat writes a floating point number element.
+func (arr *ArrayWriter) WriteFloat(f float64) {
+	arr.count++
+	arr.out().WriteFloat(f)
+}
+
+// WriteBool writes a boolean element.
+func (arr *ArrayWriter) WriteBool(v bool) {
+	arr.count++
+	arr.out().WriteBool(v)
+}
+
+// WriteBigNumber writes an integer element of arbitrary size.
+func (arr *ArrayWriter) WriteBigNumber(num *big.Int) {
+	arr.count++
+	arr.out().WriteBigNumber(num)
+}
+
+// WriteNull writes a null element.
+func (arr *ArrayWriter) WriteNull() {
+	arr.count++
+	arr.out().WriteNull()
+}
+
+// WriteNullArray writes a null array element.
+func (arr *ArrayWriter) WriteNullArray() {
+	arr.count++
+	arr.out().WriteNullArray()
+}
+
+// WriteAny writes any type as a single element, see Writer.WriteAny.
+func (arr *ArrayWriter) WriteAny(v interface{}) {
+	arr.count++
+	arr.out().WriteAny(v)
+}
@@ -0,0 +1,71 @@
+package redcon
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArrayWriter(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	arr := wr.BeginArray()
+	for i := 0; i < 5; i++ {
+		if i%2 == 0 {
+			arr.WriteInt(i)
+		}
+	}
+	nested := arr.BeginArray()
+	nested.WriteBulkString("a")
+	nested.WriteNull()
+	nested.End()
+	arr.WriteString("OK")
+	if arr.Len() != 5 {
+		t.Fatalf("expected 5, got %d", arr.Len())
+	}
+	if wr.buffered() != 0 {
+		t.Fatal("expected nothing to be written before End")
+	}
+	arr.End()
+	arr.End()
+	wr.BeginArray().End()
+	wr.Flush()
+	exp := "*5\r\n:0\r\n:2\r\n:4\r\n*2\r\n$1\r\na\r\n$-1\r\n+OK\r\n*0\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestStreamedArray(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	write := func() {
+		arr := wr.BeginStreamedArray()
+		arr.WriteInt(1)
+		nested := arr.BeginArray()
+		nested.WriteInt(2)
+		nested.End()
+		arr.End()
+		m := wr.BeginStreamedMap()
+		m.WriteBulkString("k")
+		m.WriteBulkString("v")
+		m.End()
+	}
+	write()
+	wr.SetProtocol(3)
+	size := wr.buffered()
+	arr := wr.BeginStreamedArray()
+	arr.WriteInt(0)
+	if wr.buffered()-size != 8 {
+		t.Fatalf("expected the element to be written, got %d bytes",
+			wr.buffered()-size)
+	}
+	arr.End()
+	write()
+	wr.Flush()
+	exp := "*2\r\n:1\r\n*1\r\n:2\r\n*2\r\n$1\r\nk\r\n$1\r\nv\r\n" +
+		"*?\r\n:0\r\n.\r\n" +
+		"*?\r\n:1\r\n*1\r\n:2\r\n.\r\n%?\r\n$1\r\nk\r\n$1\r\nv\r\n.\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
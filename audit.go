@@ -0,0 +1,86 @@
+package redcon
+
+import "time"
+
+// AuditRecord describes a command that was received by the server, see
+// Server.Audit.
+type AuditRecord struct {
+	// Time is when the command was processed.
+	Time time.Time
+	// ConnID, Addr, and User are the ID and the remote address of the
+	// connection, and the ACL user that it has authenticated as, which is
+	// "default" when it has not authenticated, see ConnUser.
+	ConnID uint64
+	Addr   string
+	User   string
+	// Name is the name of the command, in lowercase, and NArgs is the
+	// number of arguments, including the name.
+	Name  string
+	NArgs int
+	// Args are the arguments that follow the name, when SetAuditArgs is
+	// enabled, where the passwords are replaced with "(redacted)".
+	Args []string
+}
+
+// SetAuditArgs includes the arguments of the commands in the records that
+// are passed to the Audit function, which are omitted by default because
+// they may hold sensitive values. Passwords are always redacted, which are
+// the arguments of AUTH, the password of the AUTH option of HELLO, and the
+// password rules of ACL SETUSER. The setting applies to connections that are
+// accepted after the call.
+func (s *Server) SetAuditArgs(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditArgs = on
+}
+
+// audit passes the record of the command to the Audit function.
+func (c *conn) audit(s *Server, cmd Command) {
+	if len(cmd.Args) == 0 {
+		return
+	}
+	rec := AuditRecord{
+		Time:   time.Now(),
+		ConnID: c.id,
+		Addr:   c.addr,
+		User:   ConnUser(c),
+		Name:   asciiLower(cmd.Args[0]),
+		NArgs:  len(cmd.Args),
+	}
+	if c.auditArgs {
+		rec.Args = make([]string, len(cmd.Args)-1)
+		for i, arg := range cmd.Args[1:] {
+			rec.Args[i] = string(arg)
+		}
+		redactArgs(rec.Name, rec.Args)
+	}
+	s.Audit(rec)
+}
+
+// redactArgs replaces the passwords in the arguments of a command, which
+// follow its name, with "(redacted)".
+func redactArgs(name string, args []string) {
+	const redacted = "(redacted)"
+	switch name {
+	case "auth":
+		for i := range args {
+			args[i] = redacted
+		}
+	case "hello":
+		for i := 1; i < len(args); i++ {
+			if asciiEqualFold(args[i], "auth") && i+2 < len(args) {
+				args[i+2] = redacted
+				i += 2
+			}
+		}
+	case "acl":
+		if len(args) == 0 || !asciiEqualFold(args[0], "setuser") {
+			break
+		}
+		for i := 2; i < len(args); i++ {
+			if len(args[i]) > 0 && (args[i][0] == '>' || args[i][0] == '<') {
+				args[i] = args[i][:1] + redacted
+			}
+		}
+	}
+}
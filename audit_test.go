@@ -0,0 +1,63 @@
+package redcon
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	recs := make(chan AuditRecord, 16)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetRequirePass("secret")
+	s.Audit = func(rec AuditRecord) { recs <- rec }
+	addr := testServe(t, s)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, cmd := range []string{"GET a", "auth secret", "set a 1"} {
+		testDo(t, c, cmd+"\r\n")
+	}
+	for _, exp := range []string{"get 2 []", "auth 2 []", "set 3 []"} {
+		rec := <-recs
+		if rec.ConnID == 0 || rec.Addr == "" || rec.User != "default" ||
+			rec.Time.IsZero() {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		actual := rec.Name + " " + strconv.Itoa(rec.NArgs) + " [" +
+			strings.Join(rec.Args, " ") + "]"
+		if actual != exp {
+			t.Fatalf("expected %q, got %q", exp, actual)
+		}
+	}
+
+	// the arguments are included, without the passwords
+	s.SetAuditArgs(true)
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, cmd := range []string{"auth secret", "set a 1",
+		"hello 3 auth default secret setname app",
+		"acl setuser bob on >pass ~*"} {
+		testDo(t, c, cmd+"\r\n")
+	}
+	for _, exp := range []string{
+		"auth [(redacted)]",
+		"set [a 1]",
+		"hello [3 auth default (redacted) setname app]",
+		"acl [setuser bob on >(redacted) ~*]",
+	} {
+		rec := <-recs
+		actual := rec.Name + " [" + strings.Join(rec.Args, " ") + "]"
+		if actual != exp {
+			t.Fatalf("expected %q, got %q", exp, actual)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package redcon
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// SetAuthChallenge enables challenge-response authentication, for
+// environments where sending plaintext passwords over links without TLS is
+// unacceptable. The secret function returns the shared secret of a user, or
+// false when the user is unknown. A nil function disables authentication.
+//
+// Clients authenticate in two steps:
+//
+//   AUTH CHALLENGE                       -> bulk string nonce
+//   AUTH RESPONSE [username] signature   -> OK
+//
+// where signature is the hex encoded HMAC-SHA256 of the nonce, keyed by the
+// secret of the user. The username defaults to "default". A nonce can only be
+// used once, whether or not the response is valid, so a captured response
+// cannot be replayed. A failed attempt keeps the previous authentication of
+// the client, like Redis does.
+//
+// Challenge-response authentication takes precedence over password
+// authentication. While it's enabled, the function set by SetAuth or
+// SetRequirePass is not called, and plaintext AUTH commands are refused with
+// an error that asks the client to use AUTH CHALLENGE.
+//
+// Until a client has authenticated, every command other than AUTH and QUIT
+// is replied to with a NOAUTH error and is not passed to the handler. AUTH commands are
+// always handled by the server. The setting applies to connections that are
+// accepted after the call. It does not apply to memcached clients.
+func (s *Server) SetAuthChallenge(secret func(user string) ([]byte, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authSecret = secret
+}
+
+// SetAuth enables password authentication using the AUTH command:
+//
+//   AUTH [username] password
+//
+// The auth function checks the credentials of a client, where the username
+// defaults to "default", and returns true when they're valid. A failed
+// attempt keeps the previous authentication of the client, like Redis does.
+// A nil function disables authentication.
+//
+//   s.SetAuth(func(conn redcon.Conn, user, pass string) bool {
+//       return user == "default" && pass == password
+//   })
+//
+// Until a client has authenticated, every command other than AUTH, HELLO,
+// and QUIT is replied to with a NOAUTH error and is not passed to the
+// handler. AUTH commands are always handled by the server. HELLO is handled
+// by the server when it's enabled using SetHello, where the AUTH option is
+// checked using the auth function, unless the config has its own, and is
+// refused otherwise. The setting applies to connections that are accepted
+// after the call. It does not apply to memcached clients.
+//
+// The auth function is not called while challenge-response authentication
+// is enabled, because SetAuthChallenge takes precedence. Plaintext AUTH
+// commands are refused in that case, so enable only one of the two.
+func (s *Server) SetAuth(auth func(conn Conn, user, pass string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authPass = auth
+}
+
+// SetRequirePass enables password authentication with a single shared
+// password, like the requirepass setting of Redis. Clients authenticate with
+// "AUTH password", or with "AUTH default password", see SetAuth. The
+// password is compared in constant time. An empty password disables
+// authentication.
+func (s *Server) SetRequirePass(pass string) {
+	if pass == "" {
+		s.SetAuth(nil)
+		return
+	}
+	// compare the hashes, so the time doesn't depend on the lengths either
+	expected := sha256.Sum256([]byte(pass))
+	s.SetAuth(func(conn Conn, user, pass string) bool {
+		actual := sha256.Sum256([]byte(pass))
+		return subtle.ConstantTimeCompare(actual[:], expected[:]) == 1 &&
+			user == "default"
+	})
+}
+
+// authorizePass handles the AUTH command of password authentication and
+// rejects the commands of clients that have not authenticated, writing the
+// replies to w. Returns true when the command should be passed on.
+func (c *conn) authorizePass(w *Writer, cmd Command) bool {
+	if len(cmd.Args) == 0 || !asciiEqualFold(string(cmd.Args[0]), "auth") {
+		if c.authed || len(cmd.Args) == 0 {
+			return true
+		}
+		switch {
+		case asciiEqualFold(string(cmd.Args[0]), "quit"):
+			return true
+		case asciiEqualFold(string(cmd.Args[0]), "hello") &&
+			c.helloConfig != nil:
+			return true
+		}
+		w.WriteError("NOAUTH Authentication required.")
+		return false
+	}
+	user, pass := "default", ""
+	switch len(cmd.Args) {
+	case 2:
+		pass = string(cmd.Args[1])
+	case 3:
+		user, pass = string(cmd.Args[1]), string(cmd.Args[2])
+	case 1:
+		w.WriteError("ERR wrong number of arguments for 'auth' command")
+		return false
+	default:
+		w.WriteError("ERR syntax error")
+		return false
+	}
+	if !c.authPass(c, user, pass) {
+		// like Redis, a failed attempt keeps the previous authentication
+		w.WriteError("WRONGPASS invalid username-password pair " +
+			"or user is disabled.")
+		return false
+	}
+	c.authed = true
+	w.WriteString("OK")
+	return false
+}
+
+// authorize handles the AUTH command and rejects the commands of clients that
+// have not authenticated, writing the replies to w. Returns true when the
+// command should be passed to the handler.
+func (c *conn) authorize(w *Writer, cmd Command) bool {
+	if c.authSecret == nil {
+		return c.authorizePass(w, cmd)
+	}
+	if len(cmd.Args) == 0 || !asciiEqualFold(string(cmd.Args[0]), "auth") {
+		if c.authed || len(cmd.Args) == 0 ||
+			asciiEqualFold(string(cmd.Args[0]), "quit") {
+			return true
+		}
+		w.WriteError("NOAUTH Authentication required.")
+		return false
+	}
+	// take the nonce, which makes it single-use
+	nonce := c.nonce
+	c.nonce = nil
+	if len(cmd.Args) < 2 {
+		w.WriteError("ERR wrong number of arguments for 'auth' command")
+		return false
+	}
+	switch asciiLower(cmd.Args[1]) {
+	case "challenge":
+		if len(cmd.Args) != 2 {
+			w.WriteError("ERR syntax error")
+			return false
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			w.WriteError("ERR " + err.Error())
+			return false
+		}
+		c.nonce = []byte(hex.EncodeToString(b))
+		w.WriteBulk(c.nonce)
+	case "response":
+		user, sig := "default", cmd.Args[len(cmd.Args)-1]
+		switch len(cmd.Args) {
+		case 3:
+		case 4:
+			user = string(cmd.Args[2])
+		default:
+			w.WriteError("ERR syntax error")
+			return false
+		}
+		if nonce == nil {
+			w.WriteError("ERR no challenge, send AUTH CHALLENGE first")
+			return false
+		}
+		secret, ok := c.authSecret(user)
+		expected := hmac.New(sha256.New, secret)
+		expected.Write(nonce)
+		actual, err := hex.DecodeString(string(sig))
+		if !ok || err != nil || !hmac.Equal(actual, expected.Sum(nil)) {
+			w.WriteError("WRONGPASS invalid username-password pair " +
+				"or user is disabled.")
+			return false
+		}
+		c.authed = true
+		w.WriteString("OK")
+	default:
+		w.WriteError("ERR challenge-response authentication required, " +
+			"use AUTH CHALLENGE")
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package redcon
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthChallenge(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("PONG")
+	}, nil, nil)
+	s.SetAuthChallenge(func(user string) ([]byte, bool) {
+		switch user {
+		case "default":
+			return []byte("secret"), true
+		case "alice":
+			return []byte("wonderland"), true
+		}
+		return nil, false
+	})
+	// challenge-response takes precedence, so the password is refused
+	s.SetRequirePass("secret")
+	addr := testServe(t, s)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	rd := bufio.NewReader(c)
+	do := func(args ...string) RESP {
+		t.Helper()
+		var b []byte
+		b = AppendArray(b, len(args))
+		for _, arg := range args {
+			b = AppendBulkString(b, arg)
+		}
+		if _, err := c.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line[0] == '$' {
+			data := make([]byte, 34)
+			if _, err := io.ReadFull(rd, data); err != nil {
+				t.Fatal(err)
+			}
+			line += string(data)
+		}
+		_, resp := ReadNextRESP([]byte(line))
+		return resp
+	}
+	sign := func(secret string, nonce []byte) string {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(nonce)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	expect := func(resp RESP, exp string) {
+		t.Helper()
+		if string(resp.Raw) != exp {
+			t.Fatalf("expected %q, got %q", exp, resp.Raw)
+		}
+	}
+
+	expect(do("PING"), "-NOAUTH Authentication required.\r\n")
+	expect(do("AUTH", "secret"), "-ERR challenge-response authentication "+
+		"required, use AUTH CHALLENGE\r\n")
+	expect(do("AUTH", "RESPONSE", "00"),
+		"-ERR no challenge, send AUTH CHALLENGE first\r\n")
+
+	// wrong secret
+	nonce := do("AUTH", "CHALLENGE").Data
+	expect(do("AUTH", "RESPONSE", sign("wrong", nonce)),
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+
+	// the nonce is consumed by the failed attempt
+	expect(do("AUTH", "RESPONSE", sign("secret", nonce)),
+		"-ERR no challenge, send AUTH CHALLENGE first\r\n")
+
+	nonce = do("AUTH", "CHALLENGE").Data
+	expect(do("AUTH", "RESPONSE", sign("secret", nonce)), "+OK\r\n")
+	expect(do("PING"), "+PONG\r\n")
+
+	// replaying the response fails
+	expect(do("AUTH", "RESPONSE", sign("secret", nonce)),
+		"-ERR no challenge, send AUTH CHALLENGE first\r\n")
+
+	nonce = do("AUTH", "challenge").Data
+	expect(do("AUTH", "response", "alice", sign("wonderland", nonce)),
+		"+OK\r\n")
+	expect(do("PING"), "+PONG\r\n")
+
+	nonce = do("AUTH", "CHALLENGE").Data
+	expect(do("AUTH", "RESPONSE", "bob", sign("secret", nonce)),
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	// a failed attempt keeps the previous authentication
+	expect(do("PING"), "+PONG\r\n")
+
+	// QUIT is passed to the handler before authenticating
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "quit\r\n"); resp != "+PONG\r\n" {
+		t.Fatalf("expected %q, got %q", "+PONG\r\n", resp)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("PONG")
+	}, nil, nil)
+	s.SetAuth(func(conn Conn, user, pass string) bool {
+		return (user == "default" && pass == "secret") ||
+			(user == "alice" && pass == "wonderland")
+	})
+	addr := testServe(t, s)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"ping", "-NOAUTH Authentication required.\r\n"},
+		{"hello", "-NOAUTH Authentication required.\r\n"},
+		{"auth", "-ERR wrong number of arguments for 'auth' command\r\n"},
+		{"auth a b c", "-ERR syntax error\r\n"},
+		{"auth wrong", "-WRONGPASS invalid username-password pair or user " +
+			"is disabled.\r\n"},
+		{"ping", "-NOAUTH Authentication required.\r\n"},
+		{"AUTH secret", "+OK\r\n"},
+		{"ping", "+PONG\r\n"},
+		{"auth alice wrong", "-WRONGPASS invalid username-password pair " +
+			"or user is disabled.\r\n"},
+		{"ping", "+PONG\r\n"},
+		{"auth alice wonderland", "+OK\r\n"},
+		{"ping", "+PONG\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+
+	// QUIT is passed to the handler before authenticating
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "quit\r\n"); resp != "+PONG\r\n" {
+		t.Fatalf("expected %q, got %q", "+PONG\r\n", resp)
+	}
+
+	// the built-in HELLO authenticates using the AUTH option
+	s.SetHello(&HelloConfig{})
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	exp := "-NOAUTH HELLO must be called with the client already " +
+		"authenticated, otherwise the HELLO <proto> AUTH <user> <pass> " +
+		"option can be used to authenticate the client and select the RESP " +
+		"protocol version at the same time\r\n"
+	if resp := testDo(t, c, "hello 3\r\n"); resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+	resp := testDo(t, c, "hello 3 auth default secret\r\n")
+	if !strings.HasPrefix(resp, "%7\r\n") {
+		t.Fatalf("expected %q, got %q", "%7\r\n...", resp)
+	}
+	for !strings.HasSuffix(resp, "$7\r\nmodules\r\n*0\r\n") {
+		resp += testDo(t, c, "")
+	}
+	if resp := testDo(t, c, "ping\r\n"); resp != "+PONG\r\n" {
+		t.Fatalf("expected %q, got %q", "+PONG\r\n", resp)
+	}
+}
+
+func TestRequirePass(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("PONG")
+	}, nil, nil)
+	(&Config{RequirePass: "secret"}).Apply(s)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	wrongpass := "-WRONGPASS invalid username-password pair or user is " +
+		"disabled.\r\n"
+	for _, tt := range []struct{ cmd, exp string }{
+		{"ping", "-NOAUTH Authentication required.\r\n"},
+		{"auth secre", wrongpass},
+		{"auth secrets", wrongpass},
+		{"auth alice secret", wrongpass},
+		{"auth secret", "+OK\r\n"},
+		{"ping", "+PONG\r\n"},
+		{"auth default secret", "+OK\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+
+	// an empty password disables authentication
+	s.SetRequirePass("")
+	c, err = net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "ping\r\n"); resp != "+PONG\r\n" {
+		t.Fatalf("expected %q, got %q", "+PONG\r\n", resp)
+	}
+}
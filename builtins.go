@@ -0,0 +1,77 @@
+package redcon
+
+import (
+	"sync/atomic"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+// SetBuiltins enables the built-in handling of the commands that nearly
+// every server implements the same way:
+//
+//   PING [message]  replies with PONG, or with the message
+//   ECHO message    replies with the message
+//   QUIT            replies with OK, and closes the connection once the
+//                   reply has been sent
+//   RESET           resets the connection, and replies with RESET
+//
+// RESET switches the connection back to RESP2, clears its name and its
+// no-evict flag, and clears the authentication of the connection. Use the
+// Reset function of the server for resetting the state that is kept by the
+// application, such as subscriptions or transactions.
+//
+// These commands are not passed to the handler. The setting is disabled by
+// default, and applies to connections that are accepted after the call.
+func (s *Server) SetBuiltins(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builtins = on
+}
+
+// builtin handles the built-in commands, writing the replies to w. Returns
+// false when the command is not a built-in command.
+func (c *conn) builtin(s *Server, w *Writer, cmd Command) bool {
+	if len(cmd.Args) == 0 {
+		return false
+	}
+	name := string(cmd.Args[0])
+	switch {
+	case asciiEqualFold(name, "ping"):
+		switch len(cmd.Args) {
+		case 1:
+			w.WriteString("PONG")
+		case 2:
+			w.WriteBulk(cmd.Args[1])
+		default:
+			w.WriteError("ERR wrong number of arguments for 'ping' command")
+		}
+	case asciiEqualFold(name, "echo"):
+		if len(cmd.Args) != 2 {
+			w.WriteError("ERR wrong number of arguments for 'echo' command")
+		} else {
+			w.WriteBulk(cmd.Args[1])
+		}
+	case asciiEqualFold(name, "quit"):
+		w.WriteString("OK")
+		c.CloseAfterFlush()
+	case asciiEqualFold(name, "reset"):
+		if len(cmd.Args) != 1 {
+			w.WriteError("ERR wrong number of arguments for 'reset' command")
+			break
+		}
+		c.SetProtocol(2)
+		w.SetProtocol(resp.RESP2)
+		c.SetName("")
+		c.setUser("")
+		atomic.StoreInt32(&c.noEvict, 0)
+		c.authed = false
+		c.nonce = nil
+		if s.Reset != nil {
+			s.Reset(c)
+		}
+		w.WriteString("RESET")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package redcon
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBuiltins(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "hello3":
+			conn.SetProtocol(3)
+			conn.SetName("app")
+			conn.WriteNull()
+		case "name":
+			conn.WriteBulkString(conn.Name())
+		default:
+			conn.WriteString("HANDLER")
+		}
+	}, nil, nil)
+	s.SetBuiltins(true)
+	var resets int
+	s.Reset = func(conn Conn) { resets++ }
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"PING", "+PONG\r\n"},
+		{"ping hello", "$5\r\nhello\r\n"},
+		{"ping a b", "-ERR wrong number of arguments for 'ping' command\r\n"},
+		{"Echo hello", "$5\r\nhello\r\n"},
+		{"echo", "-ERR wrong number of arguments for 'echo' command\r\n"},
+		{"get", "+HANDLER\r\n"},
+		{"hello3", "_\r\n"},
+		{"reset", "+RESET\r\n"},
+		{"name", "$0\r\n\r\n"},
+		{"echo x", "$1\r\nx\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+	if resets != 1 {
+		t.Fatalf("expected 1 reset, got %d", resets)
+	}
+	// QUIT closes the connection after the reply, and the rest of the
+	// pipeline is discarded
+	if _, err := c.Write([]byte("quit\r\nping\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	resp, err := io.ReadAll(c)
+	if err != nil || string(resp) != "+OK\r\n" {
+		t.Fatalf("expected %q, got %q, %v", "+OK\r\n", resp, err)
+	}
+}
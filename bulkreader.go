@@ -0,0 +1,123 @@
+package redcon
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// BulkReader reads a large argument directly from the connection, without
+// buffering the entire argument in memory. It's the Stream of a command
+// whose last argument is larger than the threshold that is set using
+// SetLargeArgThreshold.
+//
+//   case "set":
+//       if cmd.Stream != nil {
+//           n, err := io.Copy(file, cmd.Stream)
+//           ...
+//       }
+//
+// The argument must be read before the handler returns, because the data is
+// read from the connection. The rest of the argument is skipped when the next
+// command is read.
+type BulkReader struct {
+	rd     *Reader
+	size   int
+	remain int
+	err    error
+}
+
+// SetLargeArgThreshold sets the size, in bytes, above which the last
+// argument of a command is streamed, instead of being buffered. The argument
+// is read using the Stream of the command. Only the last argument is
+// streamed, such as the value of SET, and larger arguments that come before
+// it are buffered. Use zero to disable this feature, which is the default.
+func (rd *Reader) SetLargeArgThreshold(size int) {
+	rd.largeArg = size
+}
+
+// SetLargeArgThreshold sets the size, in bytes, above which the last
+// argument of a command is passed to the handler as the Stream of the
+// command, instead of being buffered, which keeps the memory of connections
+// bounded when clients send very large values. Use zero to disable this
+// feature, which is the default.
+func (s *Server) SetLargeArgThreshold(size int) {
+	s.mu.Lock()
+	s.largeArg = size
+	s.mu.Unlock()
+}
+
+// streamCommand returns the command that starts with head, which is the
+// command up to and including the length of its last argument, where marks
+// are the positions of the other arguments.
+func (rd *Reader) streamCommand(head []byte, marks []int, count, size int,
+) Command {
+	cmd := Command{Raw: append([]byte(nil), head...)}
+	cmd.Args = make([][]byte, count)
+	for h := 0; h < len(marks); h += 2 {
+		cmd.Args[h/2] = cmd.Raw[marks[h]:marks[h+1]]
+	}
+	rd.stream = &BulkReader{rd: rd, size: size, remain: size}
+	cmd.Stream = rd.stream
+	return cmd
+}
+
+// Len returns the size of the argument, in bytes.
+func (br *BulkReader) Len() int {
+	return br.size
+}
+
+// Read reads the next bytes of the argument. Returns io.EOF at the end of
+// the argument.
+func (br *BulkReader) Read(p []byte) (int, error) {
+	if br.err != nil {
+		return 0, br.err
+	}
+	if br.remain == 0 {
+		// the argument must be followed by CRLF
+		var crlf [2]byte
+		if _, err := io.ReadFull(readerFunc(br.read), crlf[:]); err != nil {
+			br.err = err
+		} else if crlf[0] != '\r' || crlf[1] != '\n' {
+			br.err = errInvalidBulkLength
+		} else {
+			br.err = io.EOF
+		}
+		return 0, br.err
+	}
+	if len(p) > br.remain {
+		p = p[:br.remain]
+	}
+	n, err := br.read(p)
+	br.remain -= n
+	br.err = err
+	return n, err
+}
+
+// read reads the bytes that are buffered by the reader, and then from the
+// connection.
+func (br *BulkReader) read(p []byte) (int, error) {
+	rd := br.rd
+	if rd.start < rd.end {
+		n := copy(p, rd.buf[rd.start:rd.end])
+		rd.start += n
+		return n, nil
+	}
+	n, err := rd.rd.Read(p)
+	atomic.AddInt64(&rd.read, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// discard skips the rest of the argument.
+func (br *BulkReader) discard() error {
+	_, err := io.Copy(io.Discard, br)
+	return err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) {
+	return fn(p)
+}
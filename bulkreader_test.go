@@ -0,0 +1,93 @@
+package redcon
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBulkReader(t *testing.T) {
+	value := strings.Repeat("0123456789", 10)
+	rd := NewReader(io.MultiReader(
+		strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$100\r\n"+value[:30]),
+		strings.NewReader(value[30:]+"\r\n*1\r\n$4\r\nPING\r\n"),
+		strings.NewReader("*2\r\n$3\r\nSET\r\n$20\r\n"+value[:20]+"\r\n"),
+		strings.NewReader("*2\r\n$3\r\nSET\r\n$11\r\n"+value[:11]+"xx"),
+	))
+	rd.SetLargeArgThreshold(10)
+
+	// read the entire argument
+	cmd, err := rd.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd.Args) != 3 || string(cmd.Args[0]) != "SET" ||
+		string(cmd.Args[1]) != "key" || cmd.Args[2] != nil {
+		t.Fatalf("unexpected args %q", cmd.Args)
+	}
+	if string(cmd.Raw) != "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$100\r\n" {
+		t.Fatalf("unexpected raw %q", cmd.Raw)
+	}
+	if cmd.Stream == nil || cmd.Stream.Len() != 100 {
+		t.Fatalf("expected a stream of 100 bytes")
+	}
+	data, err := ioutil.ReadAll(cmd.Stream)
+	if err != nil || string(data) != value {
+		t.Fatalf("unexpected value %q, %v", data, err)
+	}
+	cmd, err = rd.ReadCommand()
+	if err != nil || string(cmd.Args[0]) != "PING" || cmd.Stream != nil {
+		t.Fatalf("unexpected command %q, %v", cmd.Args, err)
+	}
+
+	// the unread argument is skipped
+	cmd, err = rd.ReadCommand()
+	if err != nil || cmd.Stream == nil {
+		t.Fatalf("expected a stream, %v", err)
+	}
+	if _, err := cmd.Stream.Read(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a missing CRLF after the argument is a protocol error
+	cmd, err = rd.ReadCommand()
+	if err != nil || cmd.Stream == nil {
+		t.Fatalf("expected a stream, %v", err)
+	}
+	if _, err := ioutil.ReadAll(cmd.Stream); err != errInvalidBulkLength {
+		t.Fatalf("expected %v, got %v", errInvalidBulkLength, err)
+	}
+}
+
+func TestServerLargeArg(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		if cmd.Stream == nil {
+			conn.WriteBulk(cmd.Args[1])
+			return
+		}
+		data, err := ioutil.ReadAll(cmd.Stream)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteInt(len(data))
+	}, nil, nil)
+	s.SetLargeArgThreshold(1024)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	value := strings.Repeat("x", 1024*1024)
+	cmd := "*2\r\n$3\r\nSET\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value +
+		"\r\n"
+	if resp := testDo(t, c, cmd); resp != ":1048576\r\n" {
+		t.Fatalf("expected %q, got %q", ":1048576\r\n", resp)
+	}
+	if resp := testDo(t, c, "*2\r\n$3\r\nSET\r\n$1\r\nx\r\n"); resp != "$1\r\nx\r\n" {
+		t.Fatalf("expected %q, got %q", "$1\r\nx\r\n", resp)
+	}
+}
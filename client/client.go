@@ -0,0 +1,138 @@
+// Package client implements a minimal Redis client that is built on the resp
+// package. It's intended for tooling and tests, and for talking to Redcon
+// servers without pulling in a full featured client.
+//
+//   c, err := client.Dial("tcp", "localhost:6380")
+//   if err != nil {
+//       log.Fatal(err)
+//   }
+//   defer c.Close()
+//   reply, err := c.Do("SET", "key", "value")
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+var errInvalidReply = errors.New("invalid reply")
+
+// Client is a connection to a Redis server. A Client is not safe for
+// concurrent use.
+type Client struct {
+	conn net.Conn
+	wbuf []byte
+	rbuf []byte
+	rpos int
+}
+
+// Dial connects to the server at the address on the named network.
+func Dial(network, addr string) (*Client, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client that uses an existing connection.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// NetConn returns the underlying connection.
+func (c *Client) NetConn() net.Conn {
+	return c.conn
+}
+
+// Do sends a command and returns the reply. Error replies from the server are
+// returned as a reply with the resp.Error type, not as an error.
+//
+// Arguments are sent as bulk strings. Strings and byte slices are sent as-is,
+// integers and floats are formatted in decimal, and everything else is
+// formatted using fmt.Sprint.
+func (c *Client) Do(args ...interface{}) (resp.RESP, error) {
+	c.Send(args...)
+	if err := c.Flush(); err != nil {
+		return resp.RESP{}, err
+	}
+	return c.Receive()
+}
+
+// Send buffers a command without sending it. Use Flush to send the buffered
+// commands, and Receive to read each reply. This allows for pipelining.
+func (c *Client) Send(args ...interface{}) {
+	c.wbuf = resp.AppendArray(c.wbuf, len(args))
+	for _, arg := range args {
+		c.wbuf = appendArg(c.wbuf, arg)
+	}
+}
+
+// Flush sends the buffered commands.
+func (c *Client) Flush() error {
+	_, err := c.conn.Write(c.wbuf)
+	c.wbuf = c.wbuf[:0]
+	return err
+}
+
+// Receive reads the next reply. The reply remains valid after following
+// calls to Receive.
+func (c *Client) Receive() (resp.RESP, error) {
+	for {
+		data := c.rbuf[c.rpos:]
+		if len(data) > 0 {
+			switch data[0] {
+			case resp.Integer, resp.String, resp.Bulk, resp.Array, resp.Error:
+			default:
+				return resp.RESP{}, errInvalidReply
+			}
+			if n, _ := resp.ReadNextRESP(data); n > 0 {
+				c.rpos += n
+				// copy the reply, because the read buffer is reused.
+				_, r := resp.ReadNextRESP(append([]byte(nil), data[:n]...))
+				return r, nil
+			}
+		}
+		if c.rpos > 0 {
+			c.rbuf = c.rbuf[:copy(c.rbuf, c.rbuf[c.rpos:])]
+			c.rpos = 0
+		}
+		if len(c.rbuf) == cap(c.rbuf) {
+			nbuf := make([]byte, len(c.rbuf), cap(c.rbuf)*2+4096)
+			copy(nbuf, c.rbuf)
+			c.rbuf = nbuf
+		}
+		n, err := c.conn.Read(c.rbuf[len(c.rbuf):cap(c.rbuf)])
+		if err != nil {
+			return resp.RESP{}, err
+		}
+		c.rbuf = c.rbuf[:len(c.rbuf)+n]
+	}
+}
+
+// appendArg appends a command argument as a bulk string.
+func appendArg(b []byte, arg interface{}) []byte {
+	switch arg := arg.(type) {
+	case string:
+		return resp.AppendBulkString(b, arg)
+	case []byte:
+		return resp.AppendBulk(b, arg)
+	case int:
+		return resp.AppendBulkInt(b, int64(arg))
+	case int64:
+		return resp.AppendBulkInt(b, arg)
+	case uint64:
+		return resp.AppendBulkUint(b, arg)
+	case float64:
+		return resp.AppendBulkFloat(b, arg)
+	}
+	return resp.AppendBulkString(b, fmt.Sprint(arg))
+}
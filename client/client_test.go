@@ -0,0 +1,88 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/redcon/resp"
+)
+
+func testServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := redcon.NewServer("", func(conn redcon.Conn, cmd redcon.Command) {
+		switch strings.ToLower(string(cmd.Args[0])) {
+		case "echo":
+			conn.WriteBulk(cmd.Args[1])
+		case "big":
+			conn.WriteBulkString(strings.Repeat("x", 100000))
+		case "args":
+			conn.WriteArray(len(cmd.Args) - 1)
+			for _, arg := range cmd.Args[1:] {
+				conn.WriteBulk(arg)
+			}
+		default:
+			conn.WriteError("ERR unknown command")
+		}
+	}, nil, nil)
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	c, err := Dial("tcp", testServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r, err := c.Do("ECHO", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != resp.Bulk || string(r.Data) != "hello" {
+		t.Fatalf("unexpected reply %q", r.Raw)
+	}
+	r, err = c.Do("nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != resp.Error || string(r.Data) != "ERR unknown command" {
+		t.Fatalf("unexpected reply %q", r.Raw)
+	}
+	r, err = c.Do("args", "a", []byte("b"), 1, int64(-2), uint64(3), 1.5,
+		true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args []string
+	r.ForEach(func(r resp.RESP) bool {
+		args = append(args, string(r.Data))
+		return true
+	})
+	if strings.Join(args, " ") != "a b 1 -2 3 1.5 true" {
+		t.Fatalf("unexpected reply %q", r.Raw)
+	}
+
+	// pipelining with a reply that is larger than the read buffer
+	c.Send("big")
+	c.Send("echo", "world")
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	big, err := c.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = c.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big.Data) != 100000 || string(r.Data) != "world" {
+		t.Fatalf("unexpected replies")
+	}
+}
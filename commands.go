@@ -0,0 +1,360 @@
+package redcon
+
+import (
+	"sort"
+	"strings"
+)
+
+// CommandInfo describes a command that is registered using HandleCommand,
+// like the COMMAND INFO reply of Redis.
+type CommandInfo struct {
+	// Name is the name of the command, such as "get", or the command and
+	// subcommand that are separated by a space, such as "config get".
+	Name string
+	// Arity is the number of arguments of the command, including the name
+	// of the command and subcommand, like the arity of Redis. A negative
+	// arity is the minimum number of arguments, such as -2 for
+	// "DEL key [key ...]". Zero disables the check.
+	Arity int
+	// MaxArity is the maximum number of arguments of a command with a
+	// negative arity, including the name of the command and subcommand.
+	// Zero means no maximum.
+	MaxArity int
+	// Flags are the flags of the command, such as "write", "readonly", and
+	// "fast".
+	Flags []string
+	// Categories are the ACL categories of the command, without the '@',
+	// such as "read", "string", and "fast", see ACL.
+	Categories []string
+	// FirstKey, LastKey, and KeyStep are the positions of the keys in the
+	// arguments, like the first key, last key, and step of Redis, where the
+	// name of the command is at position zero. FirstKey is the position of
+	// the first key, or zero when the command has no keys. LastKey is the
+	// position of the last key, which is counted from the end of the
+	// arguments when it's negative, such as -1 for "DEL key [key ...]",
+	// and is FirstKey when zero. KeyStep is the distance between the keys,
+	// such as 2 for "MSET key value [key value ...]", and is 1 when zero.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// Summary, Since, Group, and Complexity are the documentation of the
+	// command, which is the COMMAND DOCS reply, such as "Returns the string
+	// value of a key.", "1.0.0", "string", and "O(1)".
+	Summary    string
+	Since      string
+	Group      string
+	Complexity string
+}
+
+// validArity returns true when n arguments match the arity of the command.
+func (info *CommandInfo) validArity(n int) bool {
+	switch {
+	case info.Arity > 0:
+		return n == info.Arity
+	case info.Arity < 0 && n < -info.Arity:
+		return false
+	}
+	return info.MaxArity <= 0 || n <= info.MaxArity
+}
+
+// keyRange returns the first key, last key, and step of the command, as they
+// are reported by COMMAND INFO.
+func (info *CommandInfo) keyRange() (first, last, step int) {
+	if info.FirstKey <= 0 {
+		return 0, 0, 0
+	}
+	first, last, step = info.FirstKey, info.LastKey, info.KeyStep
+	if last == 0 {
+		last = first
+	}
+	if step <= 0 {
+		step = 1
+	}
+	return first, last, step
+}
+
+// keys returns the keys in the arguments of the command.
+func (info *CommandInfo) keys(args [][]byte) [][]byte {
+	first, last, step := info.keyRange()
+	if first == 0 {
+		return nil
+	}
+	if last < 0 {
+		last += len(args)
+	}
+	var keys [][]byte
+	for i := first; i <= last && i < len(args); i += step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// HandleCommand registers the handler for the command that is described by
+// info, see Handle. Calls with the wrong number of arguments are rejected with
+// the error of Redis, such as "ERR wrong number of arguments for 'get'
+// command", before the handler runs.
+//
+//   mux.HandleCommandFunc(redcon.CommandInfo{
+//       Name:     "get",
+//       Arity:    2,
+//       Flags:    []string{"readonly", "fast"},
+//       FirstKey: 1,
+//   }, get)
+func (m *ServeMux) HandleCommand(info CommandInfo, handler Handler) {
+	key := m.handle(info.Name, handler)
+	m.infos[key] = &info
+}
+
+// HandleCommandFunc registers the handler function for the command that is
+// described by info, see HandleCommand.
+func (m *ServeMux) HandleCommandFunc(info CommandInfo,
+	handler func(conn Conn, cmd Command),
+) {
+	if handler == nil {
+		panic("redcon: nil handler")
+	}
+	m.HandleCommand(info, HandlerFunc(handler))
+}
+
+// Keys returns the keys in the arguments of the command, using the key
+// positions of the CommandInfo that it was registered with, see
+// HandleCommand. It returns false when the command was not registered using
+// HandleCommand, or when it has the wrong number of arguments. The keys are
+// the arguments of the command, which are not copied.
+//
+// Keys allows for building sharding proxies, key based access checks, and
+// cluster redirects on top of the mux.
+//
+//   keys, ok := mux.Keys(cmd)
+//   if ok && len(keys) > 0 && slotOf(keys[0]) != mySlot {
+//       conn.WriteError("MOVED ...")
+//       return
+//   }
+func (m *ServeMux) Keys(cmd Command) ([][]byte, bool) {
+	info := m.lookupInfo(cmd.Args)
+	if info == nil || !info.validArity(len(cmd.Args)) {
+		return nil, false
+	}
+	return info.keys(cmd.Args), true
+}
+
+// lookupInfo returns the info of the command or subcommand in the arguments,
+// or nil when it was not registered using HandleCommand. Like ServeRESP, it
+// does not allocate memory.
+func (m *ServeMux) lookupInfo(args [][]byte) *CommandInfo {
+	if len(args) == 0 {
+		return nil
+	}
+	var buf [64]byte
+	command := appendLower(buf[:0], args[0])
+	if m.containers[string(command)] && len(args) > 1 {
+		var subbuf [64]byte
+		sub := append(append(subbuf[:0], command...), '|')
+		sub = appendLower(sub, args[1])
+		if _, ok := m.handlers[string(sub)]; ok {
+			return m.infos[string(sub)]
+		}
+	}
+	return m.infos[string(command)]
+}
+
+// serve checks the number of arguments of the command, whose key is name,
+// and passes it to the handler.
+func (m *ServeMux) serve(conn Conn, cmd Command, name []byte,
+	handler Handler,
+) {
+	info := m.infos[string(name)]
+	if info != nil && !info.validArity(len(cmd.Args)) {
+		conn.WriteError("ERR wrong number of arguments for '" +
+			string(name) + "' command")
+		return
+	}
+	handler.ServeRESP(conn, cmd)
+}
+
+// serveCommand handles the COMMAND command using the registered commands:
+//
+//   COMMAND
+//   COMMAND COUNT
+//   COMMAND INFO [command-name ...]
+//   COMMAND DOCS [command-name ...]
+//   COMMAND GETKEYS command [arg ...]
+func (m *ServeMux) serveCommand(conn Conn, cmd Command) {
+	if len(cmd.Args) == 1 {
+		m.writeCommandInfos(conn, m.commandKeys())
+		return
+	}
+	var names []string
+	for _, arg := range cmd.Args[2:] {
+		names = append(names, asciiLower(arg))
+	}
+	switch sub := asciiLower(cmd.Args[1]); {
+	case sub == "count" && len(cmd.Args) == 2:
+		conn.WriteInt(len(m.commandKeys()))
+	case sub == "info":
+		if len(names) == 0 {
+			names = m.commandKeys()
+		}
+		m.writeCommandInfos(conn, names)
+	case sub == "docs":
+		if len(names) == 0 {
+			names = m.commandKeys()
+		}
+		var keys []string
+		for _, name := range names {
+			if m.commandInfo(name) != nil {
+				keys = append(keys, name)
+			}
+		}
+		conn.WriteMap(len(keys))
+		for _, key := range keys {
+			conn.WriteBulkString(key)
+			m.writeCommandDocs(conn, key)
+		}
+	case sub == "getkeys" && len(cmd.Args) > 2:
+		m.serveGetKeys(conn, cmd.Args[2:])
+	case sub == "count" || sub == "getkeys":
+		conn.WriteError("ERR wrong number of arguments for 'command|" + sub +
+			"' command")
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) +
+			"'. Try COMMAND HELP.")
+	}
+}
+
+// serveGetKeys writes the keys in the arguments of a command, which is the
+// COMMAND GETKEYS reply.
+func (m *ServeMux) serveGetKeys(conn Conn, args [][]byte) {
+	info := m.lookupInfo(args)
+	if info == nil {
+		conn.WriteError("ERR Invalid command specified")
+		return
+	}
+	if !info.validArity(len(args)) {
+		conn.WriteError("ERR Invalid number of arguments specified for " +
+			"command")
+		return
+	}
+	keys := info.keys(args)
+	if len(keys) == 0 {
+		conn.WriteError("ERR The command has no key arguments")
+		return
+	}
+	conn.WriteBulkArray(keys)
+}
+
+// commandKeys returns the sorted keys of the registered commands, without
+// the subcommands.
+func (m *ServeMux) commandKeys() []string {
+	var keys []string
+	for key := range m.handlers {
+		if !strings.Contains(key, "|") && !m.containers[key] {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.containers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// subcommandKeys returns the sorted keys of the subcommands of the command.
+func (m *ServeMux) subcommandKeys(key string) []string {
+	var keys []string
+	if m.containers[key] {
+		for sub := range m.handlers {
+			if strings.HasPrefix(sub, key+"|") {
+				keys = append(keys, sub)
+			}
+		}
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// commandInfo returns the info of the command with the key, or nil when no
+// such command is registered. Commands that were registered without info
+// have an arity of -1, and containers -2.
+func (m *ServeMux) commandInfo(key string) *CommandInfo {
+	if info := m.infos[key]; info != nil {
+		return info
+	}
+	if m.containers[key] {
+		return &CommandInfo{Name: key, Arity: -2}
+	}
+	if _, ok := m.handlers[key]; ok {
+		return &CommandInfo{Name: key, Arity: -1}
+	}
+	return nil
+}
+
+// writeCommandInfos writes the COMMAND INFO reply of the commands, where
+// unknown commands are nulls.
+func (m *ServeMux) writeCommandInfos(conn Conn, keys []string) {
+	conn.WriteArray(len(keys))
+	for _, key := range keys {
+		info := m.commandInfo(key)
+		if info == nil {
+			conn.WriteNullArray()
+			continue
+		}
+		arity := info.Arity
+		if arity == 0 {
+			arity = -1
+		}
+		conn.WriteArray(10)
+		conn.WriteBulkString(key)
+		conn.WriteInt(arity)
+		conn.WriteSet(len(info.Flags))
+		for _, flag := range info.Flags {
+			conn.WriteString(flag)
+		}
+		first, last, step := info.keyRange()
+		conn.WriteInt(first)
+		conn.WriteInt(last)
+		conn.WriteInt(step)
+		conn.WriteSet(len(info.Categories))
+		for _, cat := range info.Categories {
+			conn.WriteString("@" + cat)
+		}
+		conn.WriteArray(0) // tips
+		conn.WriteArray(0) // key specs
+		m.writeCommandInfos(conn, m.subcommandKeys(key))
+	}
+}
+
+// writeCommandDocs writes the COMMAND DOCS reply of a command, which is a
+// map of the documentation fields that are set.
+func (m *ServeMux) writeCommandDocs(conn Conn, key string) {
+	info := m.commandInfo(key)
+	var fields [][2]string
+	for _, f := range [][2]string{
+		{"summary", info.Summary},
+		{"since", info.Since},
+		{"group", info.Group},
+		{"complexity", info.Complexity},
+	} {
+		if f[1] != "" {
+			fields = append(fields, f)
+		}
+	}
+	subs := m.subcommandKeys(key)
+	n := len(fields)
+	if len(subs) > 0 {
+		n++
+	}
+	conn.WriteMap(n)
+	for _, f := range fields {
+		conn.WriteBulkString(f[0])
+		conn.WriteBulkString(f[1])
+	}
+	if len(subs) > 0 {
+		conn.WriteBulkString("subcommands")
+		conn.WriteMap(len(subs))
+		for _, sub := range subs {
+			conn.WriteBulkString(sub)
+			m.writeCommandDocs(conn, sub)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package redcon
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+func TestCommandArity(t *testing.T) {
+	mux := NewServeMux()
+	ok := func(conn Conn, cmd Command) { conn.WriteString("OK") }
+	mux.HandleCommandFunc(CommandInfo{Name: "GET", Arity: 2}, ok)
+	mux.HandleCommandFunc(CommandInfo{Name: "del", Arity: -2}, ok)
+	mux.HandleCommandFunc(CommandInfo{Name: "set", Arity: -3, MaxArity: 5}, ok)
+	mux.HandleCommandFunc(CommandInfo{Name: "config get", Arity: -3}, ok)
+	mux.HandleCommandFunc(CommandInfo{Name: "ping"}, ok)
+	s := NewServer("", mux.ServeRESP, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	wrong := func(name string) string {
+		return "-ERR wrong number of arguments for '" + name + "' command\r\n"
+	}
+	for _, tt := range []struct{ cmd, exp string }{
+		{"get a", "+OK\r\n"},
+		{"GET", wrong("get")},
+		{"get a b", wrong("get")},
+		{"del a", "+OK\r\n"},
+		{"del a b c", "+OK\r\n"},
+		{"del", wrong("del")},
+		{"set a b", "+OK\r\n"},
+		{"set a b ex 10", "+OK\r\n"},
+		{"set a", wrong("set")},
+		{"set a b ex 10 nx", wrong("set")},
+		{"config get port", "+OK\r\n"},
+		{"config get", wrong("config|get")},
+		{"ping", "+OK\r\n"},
+		{"ping a b c", "+OK\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
+
+func TestServeCommand(t *testing.T) {
+	mux := NewServeMux()
+	ok := func(conn Conn, cmd Command) { conn.WriteString("OK") }
+	mux.HandleCommandFunc(CommandInfo{
+		Name:       "get",
+		Arity:      2,
+		Flags:      []string{"readonly", "fast"},
+		Summary:    "Get",
+		Since:      "1.0.0",
+		FirstKey:   1,
+		Categories: []string{"read"},
+	}, ok)
+	mux.HandleCommandFunc(CommandInfo{Name: "config get", Arity: -3}, ok)
+	mux.HandleFunc("ping", ok)
+	s := NewServer("", mux.ServeRESP, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"command count", ":3\r\n"},
+		{"command info GET", "*1\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n" +
+			"+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n*1\r\n+@read\r\n" +
+			"*0\r\n*0\r\n*0\r\n"},
+		{"command info nope", "*1\r\n*-1\r\n"},
+		{"command docs get nope", "*2\r\n$3\r\nget\r\n*4\r\n" +
+			"$7\r\nsummary\r\n$3\r\nGet\r\n$5\r\nsince\r\n$5\r\n1.0.0\r\n"},
+		{"command docs config|get", "*2\r\n$10\r\nconfig|get\r\n*0\r\n"},
+		{"command foo", "-ERR unknown subcommand 'foo'. Try COMMAND HELP.\r\n"},
+		{"command getkeys get a", "*1\r\n$1\r\na\r\n"},
+		{"command getkeys get", "-ERR Invalid number of arguments specified " +
+			"for command\r\n"},
+		{"command getkeys ping", "-ERR Invalid command specified\r\n"},
+		{"command getkeys config get a", "-ERR The command has no key " +
+			"arguments\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+
+	// the containers include their subcommands
+	_, v, err := resp.ReadValue([]byte(testDo(t, c, "command\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range v.Elems {
+		names = append(names, info.Elems[0].String())
+		for _, sub := range info.Elems[9].Elems {
+			names = append(names, sub.Elems[0].String()+
+				"("+sub.Elems[1].String()+")")
+		}
+	}
+	if exp := "config config|get(-3) get ping"; strings.Join(names, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, strings.Join(names, " "))
+	}
+}
+
+func TestServeMuxKeys(t *testing.T) {
+	mux := NewServeMux()
+	ok := func(conn Conn, cmd Command) {}
+	mux.HandleCommandFunc(CommandInfo{Name: "get", Arity: 2, FirstKey: 1}, ok)
+	mux.HandleCommandFunc(CommandInfo{
+		Name: "del", Arity: -2, FirstKey: 1, LastKey: -1,
+	}, ok)
+	mux.HandleCommandFunc(CommandInfo{
+		Name: "mset", Arity: -3, FirstKey: 1, LastKey: -1, KeyStep: 2,
+	}, ok)
+	mux.HandleCommandFunc(CommandInfo{
+		Name: "object encoding", Arity: 3, FirstKey: 2,
+	}, ok)
+	mux.HandleCommandFunc(CommandInfo{Name: "ping", Arity: -1}, ok)
+	mux.HandleFunc("echo", ok)
+	for _, tt := range []struct {
+		args string
+		keys string
+		ok   bool
+	}{
+		{"GET a", "a", true},
+		{"get", "", false},
+		{"del a b c", "a b c", true},
+		{"mset a 1 b 2", "a b", true},
+		{"mset a 1 b", "a b", true},
+		{"object ENCODING a", "a", true},
+		{"object freq a", "", false},
+		{"ping", "", true},
+		{"echo a", "", false},
+		{"nope a", "", false},
+	} {
+		var args [][]byte
+		for _, arg := range strings.Fields(tt.args) {
+			args = append(args, []byte(arg))
+		}
+		keys, ok := mux.Keys(Command{Args: args})
+		var names []string
+		for _, key := range keys {
+			names = append(names, string(key))
+		}
+		if strings.Join(names, " ") != tt.keys || ok != tt.ok {
+			t.Fatalf("%q: expected %q %v, got %q %v", tt.args, tt.keys, tt.ok,
+				strings.Join(names, " "), ok)
+		}
+	}
+}
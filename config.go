@@ -0,0 +1,185 @@
+package redcon
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings that most Redcon based daemons share. Use
+// RegisterFlags for loading it from command line flags and environment
+// variables.
+//
+//   var cfg redcon.Config
+//   if err := cfg.RegisterFlags(flag.CommandLine, "MYAPP"); err != nil {
+//       log.Fatal(err)
+//   }
+//   flag.Parse()
+//   log.Fatal(cfg.ListenAndServe(handler, nil, nil))
+type Config struct {
+	// Addr is the address to listen on. Defaults to ":6380".
+	Addr string
+	// TLSCert and TLSKey are the certificate and key files. TLS is enabled
+	// when both are set.
+	TLSCert string
+	TLSKey  string
+	// IdleTimeout closes connections that are idle for the duration. Zero
+	// disables the timeout.
+	IdleTimeout time.Duration
+	// MaxClients limits the number of connected clients. Zero means no
+	// limit.
+	MaxClients int
+	// RequirePass is the password that clients must authenticate with,
+	// see Server.SetRequirePass. Empty disables authentication.
+	RequirePass string
+	// LogLevel is the verbosity of the daemon, one of "debug", "verbose",
+	// "notice", or "warning". Redcon does not log, it's provided for the
+	// daemon. Defaults to "notice".
+	LogLevel string
+}
+
+// RegisterFlags registers the settings as flags on fs:
+//
+//   -addr          PREFIX_ADDR
+//   -tls-cert      PREFIX_TLS_CERT
+//   -tls-key       PREFIX_TLS_KEY
+//   -idle-timeout  PREFIX_IDLE_TIMEOUT
+//   -maxclients    PREFIX_MAXCLIENTS
+//   -requirepass   PREFIX_REQUIREPASS
+//   -loglevel      PREFIX_LOGLEVEL
+//
+// The defaults of the flags are taken from the environment variables, when
+// set, otherwise from the current settings. Flags that are given on the
+// command line take precedence over the environment. An error is returned
+// when an environment variable has an invalid value.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet, envPrefix string) error {
+	if cfg.Addr == "" {
+		cfg.Addr = ":6380"
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "notice"
+	}
+	env := func(name string) (string, bool) {
+		if envPrefix != "" {
+			name = envPrefix + "_" + name
+		}
+		return os.LookupEnv(name)
+	}
+	if v, ok := env("ADDR"); ok {
+		cfg.Addr = v
+	}
+	if v, ok := env("TLS_CERT"); ok {
+		cfg.TLSCert = v
+	}
+	if v, ok := env("TLS_KEY"); ok {
+		cfg.TLSKey = v
+	}
+	if v, ok := env("IDLE_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid idle timeout: %w", err)
+		}
+		cfg.IdleTimeout = d
+	}
+	if v, ok := env("MAXCLIENTS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid maxclients: %w", err)
+		}
+		cfg.MaxClients = n
+	}
+	if v, ok := env("REQUIREPASS"); ok {
+		cfg.RequirePass = v
+	}
+	if v, ok := env("LOGLEVEL"); ok {
+		cfg.LogLevel = v
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert,
+		"TLS certificate file")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS key file")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout,
+		"close connections that are idle for this duration, 0 to disable")
+	fs.IntVar(&cfg.MaxClients, "maxclients", cfg.MaxClients,
+		"maximum number of connected clients, 0 for no limit")
+	fs.StringVar(&cfg.RequirePass, "requirepass", cfg.RequirePass,
+		"password that clients must authenticate with")
+	fs.StringVar(&cfg.LogLevel, "loglevel", cfg.LogLevel,
+		"log level: debug, verbose, notice, or warning")
+	return nil
+}
+
+// Validate returns an error when the settings are invalid.
+func (cfg *Config) Validate() error {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("both a TLS certificate and key are required")
+	}
+	if cfg.IdleTimeout < 0 {
+		return errors.New("invalid idle timeout")
+	}
+	if cfg.MaxClients < 0 {
+		return errors.New("invalid maxclients")
+	}
+	switch strings.ToLower(cfg.LogLevel) {
+	case "", "debug", "verbose", "notice", "warning":
+	default:
+		return fmt.Errorf("invalid log level '%s'", cfg.LogLevel)
+	}
+	return nil
+}
+
+// TLSConfig returns the TLS configuration, or nil when TLS is not enabled.
+func (cfg *Config) TLSConfig() (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Apply applies the connection settings to a server. Authentication is only
+// changed when RequirePass is set.
+func (cfg *Config) Apply(s *Server) {
+	s.SetIdleClose(cfg.IdleTimeout)
+	s.SetMaxClients(cfg.MaxClients)
+	if cfg.RequirePass != "" {
+		s.SetRequirePass(cfg.RequirePass)
+	}
+}
+
+// ListenAndServe creates a server using the settings, and serves incoming
+// connections. TLS is used when a certificate and key are configured.
+func (cfg *Config) ListenAndServe(handler func(conn Conn, cmd Command),
+	accept func(conn Conn) bool, closed func(conn Conn, err error),
+) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	addr := cfg.Addr
+	if addr == "" {
+		addr = ":6380"
+	}
+	config, err := cfg.TLSConfig()
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		s := NewServerTLS(addr, handler, accept, closed, config)
+		cfg.Apply(s.Server)
+		return s.ListenAndServe()
+	}
+	s := NewServer(addr, handler, accept, closed)
+	cfg.Apply(s)
+	return s.ListenAndServe()
+}
@@ -0,0 +1,82 @@
+package redcon
+
+import (
+	"bufio"
+	"flag"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConfigFlags(t *testing.T) {
+	t.Setenv("TEST_ADDR", ":7000")
+	t.Setenv("TEST_IDLE_TIMEOUT", "30s")
+	t.Setenv("TEST_MAXCLIENTS", "10")
+	t.Setenv("TEST_REQUIREPASS", "secret")
+	var cfg Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	if err := cfg.RegisterFlags(fs, "TEST"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"-maxclients", "20", "-loglevel",
+		"debug"}); err != nil {
+		t.Fatal(err)
+	}
+	exp := Config{Addr: ":7000", IdleTimeout: time.Second * 30,
+		MaxClients: 20, RequirePass: "secret", LogLevel: "debug"}
+	if cfg != exp {
+		t.Fatalf("expected %+v, got %+v", exp, cfg)
+	}
+
+	t.Setenv("TEST_MAXCLIENTS", "many")
+	if err := new(Config).RegisterFlags(
+		flag.NewFlagSet("test", flag.ContinueOnError), "TEST",
+	); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, cfg := range []Config{
+		{TLSCert: "cert.pem"},
+		{IdleTimeout: -1},
+		{MaxClients: -1},
+		{LogLevel: "loud"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+	if err := (&Config{LogLevel: "warning"}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	(&Config{MaxClients: 1}).Apply(s)
+	addr := testServe(t, s)
+	c1, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	testDo(t, c1, "ping\r\n")
+	c2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(time.Second * 5))
+	line, err := bufio.NewReader(c2).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "-ERR max number of clients reached\r\n" {
+		t.Fatalf("unexpected reply %q", line)
+	}
+}
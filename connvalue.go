@@ -0,0 +1,83 @@
+package redcon
+
+// ConnValue is a typed value that is stored with each connection. It's a
+// type-safe alternative to Context and SetContext, and multiple values may be
+// stored with the same connection. Values may be accessed from any goroutine.
+//
+//   var sessions = redcon.NewConnValue[*Session]()
+//
+//   func handler(conn redcon.Conn, cmd redcon.Command) {
+//       sess, ok := sessions.Get(conn)
+//       if !ok {
+//           sess = &Session{}
+//           sessions.Set(conn, sess)
+//       }
+//       ...
+//   }
+//
+// Values can only be stored with connections that were created by this
+// package, including detached connections.
+type ConnValue[T any] struct {
+	_ byte // non-zero size, so each ConnValue has a unique address
+}
+
+// NewConnValue returns a new value key.
+func NewConnValue[T any]() *ConnValue[T] {
+	return &ConnValue[T]{}
+}
+
+// Get returns the value stored with the connection, or false when there is
+// none.
+func (v *ConnValue[T]) Get(conn Conn) (T, bool) {
+	var zero T
+	c := baseConn(conn)
+	if c == nil {
+		return zero, false
+	}
+	c.vmu.Lock()
+	defer c.vmu.Unlock()
+	val, ok := c.vals[v]
+	if !ok {
+		return zero, false
+	}
+	return val.(T), true
+}
+
+// Set stores the value with the connection.
+func (v *ConnValue[T]) Set(conn Conn, val T) {
+	c := baseConn(conn)
+	if c == nil {
+		return
+	}
+	c.vmu.Lock()
+	defer c.vmu.Unlock()
+	if c.vals == nil {
+		c.vals = make(map[interface{}]interface{})
+	}
+	c.vals[v] = val
+}
+
+// Delete removes the value from the connection.
+func (v *ConnValue[T]) Delete(conn Conn) {
+	c := baseConn(conn)
+	if c == nil {
+		return
+	}
+	c.vmu.Lock()
+	defer c.vmu.Unlock()
+	delete(c.vals, v)
+}
+
+// baseConn returns the package connection of c, or nil when c was not
+// created by this package.
+func baseConn(c Conn) *conn {
+	switch c := c.(type) {
+	case *conn:
+		return c
+	case *detachedConn:
+		return c.conn
+	case *slotConn:
+		return c.conn
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package redcon
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestConnValue(t *testing.T) {
+	type session struct{ name string }
+	sessions := NewConnValue[*session]()
+	counts := NewConnValue[int]()
+	var mu sync.Mutex
+	var results []string
+	s := NewServer("", func(conn Conn, cmd Command) {
+		n, _ := counts.Get(conn)
+		counts.Set(conn, n+1)
+		switch string(cmd.Args[0]) {
+		case "login":
+			sessions.Set(conn, &session{name: string(cmd.Args[1])})
+		case "logout":
+			sessions.Delete(conn)
+		case "whoami":
+			sess, ok := sessions.Get(conn)
+			mu.Lock()
+			if ok {
+				results = append(results, sess.name)
+			} else {
+				results = append(results, "(none)")
+			}
+			mu.Unlock()
+		case "count":
+			conn.WriteInt(n + 1)
+			return
+		}
+		conn.WriteString("OK")
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, cmd := range []string{"whoami", "login alice", "whoami", "logout",
+		"whoami"} {
+		testDo(t, c, cmd+"\r\n")
+	}
+	if resp := testDo(t, c, "count\r\n"); resp != ":6\r\n" {
+		t.Fatalf("expected %q, got %q", ":6\r\n", resp)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	exp := []string{"(none)", "alice", "(none)"}
+	if len(results) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, results)
+	}
+	for i := range exp {
+		if results[i] != exp[i] {
+			t.Fatalf("expected %v, got %v", exp, results)
+		}
+	}
+
+	// connections that were not created by this package have no values
+	var other struct{ Conn }
+	counts.Set(other, 1)
+	if _, ok := counts.Get(other); ok {
+		t.Fatal("expected no value")
+	}
+}
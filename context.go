@@ -0,0 +1,109 @@
+package redcon
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+)
+
+// ConnContext returns a context that is canceled when the connection is
+// closed, which happens when the connection is closed by the handler, when
+// the client disconnects, and when the server is closed. Handlers can pass
+// it to long-running work, such as database queries, so the work is aborted
+// once nobody is waiting for the reply.
+//
+//   func handler(conn redcon.Conn, cmd redcon.Command) {
+//       ctx := redcon.ConnContext(conn)
+//       rows, err := db.QueryContext(ctx, query)
+//       ...
+//   }
+//
+// While a command is dispatched, the server watches the connection for the
+// client to disconnect, so the context is canceled even though the handler
+// is still running. This includes a client that only closes its writing
+// side. The connection is not watched while the handler reads a streamed
+// argument, see Command.Stream, in which case a disconnect is noticed when
+// the server next reads from the connection. Under a TimeoutHandler the
+// context is also canceled when the time limit of the command is exceeded.
+func ConnContext(cn Conn) context.Context {
+	switch c := cn.(type) {
+	case *slotConn:
+		if c.ctx != nil {
+			return c.ctx
+		}
+		c.conn.watchClient()
+	case *conn:
+		c.watchClient()
+	}
+	return connContext{cn.ClosedChan()}
+}
+
+// watchClient starts watching the connection for the client to disconnect,
+// unless it's already watched or no command is dispatched. The watcher reads
+// ahead into the buffer of the Reader, where the data is left for the server
+// loop, and marks the connection as disconnected when the read fails.
+func (c *conn) watchClient() {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	if !c.dispatching || c.watch != nil || c.gone || c.isDetached() ||
+		c.rd.stream != nil || c.rd.rd == nil {
+		return
+	}
+	watch := make(chan struct{})
+	c.watch = watch
+	go func() {
+		defer close(watch)
+		for {
+			_, err := c.rd.rd.Peek(c.rd.rd.Buffered() + 1)
+			if err == bufio.ErrBufferFull {
+				return
+			}
+			if err != nil {
+				if err, ok := err.(net.Error); !ok || !err.Timeout() {
+					c.disconnected()
+				}
+				return
+			}
+		}
+	}()
+}
+
+// stopWatch interrupts the watcher that was started by watchClient, and
+// waits for it to stop.
+func (c *conn) stopWatch(watch chan struct{}) {
+	c.conn.SetReadDeadline(time.Now())
+	<-watch
+	c.conn.SetReadDeadline(time.Time{})
+}
+
+// connContext is a context that is done when the connection is closed. It
+// carries no values and has no deadline.
+type connContext struct {
+	done <-chan struct{}
+}
+
+func (ctx connContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (ctx connContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx connContext) Err() error {
+	select {
+	case <-ctx.done:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+func (ctx connContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+func (ctx connContext) String() string {
+	return "redcon.ConnContext"
+}
@@ -0,0 +1,118 @@
+package redcon
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnContext(t *testing.T) {
+	errs := make(chan error, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		ctx := ConnContext(conn)
+		switch string(cmd.Args[0]) {
+		case "err":
+			conn.WriteAny(ctx.Err())
+		case "close":
+			conn.Close()
+			errs <- ctx.Err()
+		case "wait":
+			conn.WriteString("OK")
+			conn.Flush()
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			case <-time.After(time.Second * 5):
+				errs <- nil
+			}
+		}
+	}, nil, nil)
+	addr := testServe(t, s)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "err\r\n"); resp != "$-1\r\n" {
+		t.Fatalf("expected %q, got %q", "$-1\r\n", resp)
+	}
+	if _, err := c.Write([]byte("close\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// closing the server cancels the running handlers
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "wait\r\n"); resp != "+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "+OK\r\n", resp)
+	}
+	s.Close()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestConnContextDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	errs := make(chan error, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		ctx := ConnContext(conn)
+		close(started)
+		select {
+		case <-ctx.Done():
+			errs <- ctx.Err()
+		case <-time.After(time.Second * 5):
+			errs <- nil
+		}
+		conn.WriteString("OK")
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("wait\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// the client goes away while the handler is running
+	c.Close()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestConnContextPipeline(t *testing.T) {
+	// the watcher reads ahead, and the commands it reads are not lost
+	s := NewServer("", func(conn Conn, cmd Command) {
+		ctx := ConnContext(conn)
+		if string(cmd.Args[0]) == "sleep" {
+			time.Sleep(time.Millisecond * 50)
+		}
+		conn.WriteAny(ctx.Err())
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("sleep\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 10)
+	exp := "$-1\r\n$-1\r\n$-1\r\n"
+	resp := testDo(t, c, "a\r\nb\r\n")
+	for len(resp) < len(exp) {
+		resp += testDo(t, c, "")
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
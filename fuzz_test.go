@@ -0,0 +1,103 @@
+package redcon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func fuzzSeeds(f *testing.F) {
+	for _, seed := range []string{
+		"*1\r\n$4\r\nPING\r\n",
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n*0\r\n*-1\r\n",
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$20\r\n01234567890123456789\r\n",
+		"PING\r\n",
+		"set \"a b\" 'c\\'d' \"\\x41\\n\"\r\n",
+		"*1\r\n$-1\r\n",
+		"*9999999999999999999\r\n",
+		"*1\r\n$18446744073709551617\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := fuzzReadCommands(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzParseCommand(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := fuzzParse(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// fuzzReadCommands reads all of the commands in data using a reader with
+// small limits, and checks that the raw bytes of each command parse into the
+// same arguments. Returns the number of commands, and an error when the
+// parser is inconsistent.
+func fuzzReadCommands(data []byte) (int, error) {
+	rd := NewReader(bytes.NewReader(data))
+	rd.SetMaxMultiBulkLen(64)
+	rd.SetMaxBulkLen(1024)
+	rd.SetMaxBufferLen(8192)
+	rd.SetLargeArgThreshold(16)
+	var n int
+	for {
+		cmds, err := rd.ReadCommands()
+		if err != nil {
+			return n, nil
+		}
+		for _, cmd := range cmds {
+			if cmd.Stream != nil {
+				io.Copy(io.Discard, io.LimitReader(cmd.Stream, 8))
+				continue
+			}
+			if err := checkRaw(cmd); err != nil {
+				return n, err
+			}
+		}
+		n += len(cmds)
+	}
+}
+
+// fuzzParse parses data as a single command, and checks that the raw bytes
+// of the command parse into the same arguments. Returns the number of
+// commands, and an error when the parser is inconsistent.
+func fuzzParse(data []byte) (int, error) {
+	cmd, err := Parse(data)
+	if err != nil {
+		return 0, nil
+	}
+	return 1, checkRaw(cmd)
+}
+
+func checkRaw(cmd Command) error {
+	cmd2, err := Parse(cmd.Raw)
+	if err != nil {
+		return fmt.Errorf("%q: %v", cmd.Raw, err)
+	}
+	if len(cmd2.Args) != len(cmd.Args) {
+		return fmt.Errorf("%q: expected %q, got %q", cmd.Raw, cmd.Args,
+			cmd2.Args)
+	}
+	for i := range cmd.Args {
+		if !bytes.Equal(cmd.Args[i], cmd2.Args[i]) {
+			return fmt.Errorf("%q: expected %q, got %q", cmd.Raw, cmd.Args,
+				cmd2.Args)
+		}
+	}
+	if len(cmd.Args) == 0 {
+		return errors.New("empty command")
+	}
+	return nil
+}
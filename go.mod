@@ -1,8 +1,5 @@
 module github.com/tidwall/redcon
 
-go 1.15
+go 1.18
 
-require (
-	github.com/tidwall/btree v0.2.2
-	github.com/tidwall/match v1.0.1
-)
+require github.com/tidwall/btree v0.2.2
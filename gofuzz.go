@@ -0,0 +1,52 @@
+//go:build gofuzz
+// +build gofuzz
+
+package redcon
+
+import (
+	"bytes"
+	"io"
+)
+
+// The entry points below are for go-fuzz, which builds the package with the
+// gofuzz tag. Each returns 1 when data holds at least one command, and 0
+// otherwise. They look for crashes only, the native fuzz targets that run
+// with go test -fuzz also check that the raw bytes of each command parse
+// into the same arguments.
+//
+//   go-fuzz-build -func FuzzReadCommands
+//   go-fuzz
+
+// FuzzReadCommands reads all of the commands in data using a Reader.
+func FuzzReadCommands(data []byte) int {
+	rd := NewReader(bytes.NewReader(data))
+	rd.SetMaxMultiBulkLen(64)
+	rd.SetMaxBulkLen(1024)
+	rd.SetMaxBufferLen(8192)
+	rd.SetLargeArgThreshold(16)
+	var n int
+	for {
+		cmds, err := rd.ReadCommands()
+		if err != nil {
+			break
+		}
+		for _, cmd := range cmds {
+			if cmd.Stream != nil {
+				io.Copy(io.Discard, io.LimitReader(cmd.Stream, 8))
+			}
+		}
+		n += len(cmds)
+	}
+	if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+// FuzzParse parses data as a single command using Parse.
+func FuzzParse(data []byte) int {
+	if _, err := Parse(data); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,63 @@
+package redcon
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HealthHandler returns an http.Handler that answers liveness and readiness
+// probes for the server, allowing orchestrators to check the server without
+// speaking RESP.
+//
+//   /healthz  200 OK when the server is listening for connections.
+//   /readyz   200 OK when the server is listening, is not draining, and is
+//             not paused. Otherwise 503 Service Unavailable.
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.listening() {
+			http.Error(w, "not listening", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !s.listening():
+			http.Error(w, "not listening", http.StatusServiceUnavailable)
+		case s.Draining():
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+		case s.pausedAll():
+			http.Error(w, "paused", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok\n"))
+		}
+	})
+	return mux
+}
+
+// ServeHealth serves the HealthHandler probes over HTTP on the provided
+// listener. It returns when the listener is closed.
+func (s *Server) ServeHealth(ln net.Listener) error {
+	return http.Serve(ln, s.HealthHandler())
+}
+
+// listening returns true when the server is accepting connections. The
+// handler is not checked, because a server can't be created without one.
+func (s *Server) listening() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ln != nil && !s.done
+}
+
+// pausedAll returns true when all commands are currently paused.
+func (s *Server) pausedAll() bool {
+	if atomic.LoadInt32(&s.paused) == 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pauseMode == PauseAll && time.Now().Before(s.pauseEnd)
+}
@@ -0,0 +1,71 @@
+package redcon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {}, nil, nil)
+	h := s.HealthHandler()
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	if code := probe("/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v, got %v", http.StatusServiceUnavailable, code)
+	}
+	testServe(t, s)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code := probe(path); code != http.StatusOK {
+			t.Fatalf("%s: expected %v, got %v", path, http.StatusOK, code)
+		}
+	}
+	s.Drain()
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v, got %v", http.StatusServiceUnavailable, code)
+	}
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, code)
+	}
+	s.Undrain()
+	s.Pause(time.Hour, PauseAll)
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v, got %v", http.StatusServiceUnavailable, code)
+	}
+	s.Unpause()
+	if code := probe("/readyz"); code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, code)
+	}
+}
+
+func TestHealthStartup(t *testing.T) {
+	s := NewServer("127.0.0.1:0", func(conn Conn, cmd Command) {}, nil, nil)
+	h := s.HealthHandler()
+	probe := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		return w.Code
+	}
+	// probe while the server starts listening
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			probe()
+		}
+	}()
+	signal := make(chan error, 1)
+	go s.ListenServeAndSignal(signal)
+	if err := <-signal; err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	<-done
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, code)
+	}
+}
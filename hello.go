@@ -0,0 +1,182 @@
+package redcon
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+// Hello holds the arguments of a HELLO command, which clients use for
+// selecting the protocol version, such as HELLO 3 for RESP3.
+type Hello struct {
+	// Protocol is the requested protocol version, 2 or 3, or zero when no
+	// version was given.
+	Protocol int
+	// Auth is true when the AUTH option was given, with the User and Pass
+	// credentials.
+	Auth bool
+	User string
+	Pass string
+	// SetName is true when the SETNAME option was given, with the Name of
+	// the connection.
+	SetName bool
+	Name    string
+}
+
+// ParseHello parses the arguments of a HELLO command:
+//
+//   HELLO [protover [AUTH username password] [SETNAME clientname]]
+//
+// The message of the returned error is the reply that Redis sends to the
+// client, so it can be written using WriteError. When the command is valid
+// the handler should switch the protocol using Conn.SetProtocol and reply
+// with the server properties.
+//
+//   case "hello":
+//       hello, err := redcon.ParseHello(cmd)
+//       if err != nil {
+//           conn.WriteError(err.Error())
+//           return
+//       }
+//       if hello.Protocol != 0 {
+//           conn.SetProtocol(hello.Protocol)
+//       }
+//       props := resp.AppendMap(nil, resp.Protocol(conn.Protocol()), 3)
+//       ...
+func ParseHello(cmd Command) (Hello, error) {
+	var hello Hello
+	if len(cmd.Args) < 2 {
+		return hello, nil
+	}
+	proto, err := strconv.ParseInt(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		return hello, errors.New("ERR Protocol version is not an integer " +
+			"or out of range")
+	}
+	if proto < 2 || proto > 3 {
+		return hello, errors.New("NOPROTO unsupported protocol version")
+	}
+	hello.Protocol = int(proto)
+	for i := 2; i < len(cmd.Args); i++ {
+		left := len(cmd.Args) - i - 1
+		switch asciiLower(cmd.Args[i]) {
+		case "auth":
+			if left < 2 {
+				return hello, helloSyntaxError(cmd.Args[i])
+			}
+			hello.Auth = true
+			hello.User = string(cmd.Args[i+1])
+			hello.Pass = string(cmd.Args[i+2])
+			i += 2
+		case "setname":
+			if left < 1 {
+				return hello, helloSyntaxError(cmd.Args[i])
+			}
+			hello.SetName = true
+			hello.Name = string(cmd.Args[i+1])
+			i++
+		default:
+			return hello, helloSyntaxError(cmd.Args[i])
+		}
+	}
+	return hello, nil
+}
+
+func helloSyntaxError(opt []byte) error {
+	return errors.New("ERR Syntax error in HELLO option '" + string(opt) + "'")
+}
+
+// HelloConfig configures the built-in handling of the HELLO command, see
+// Server.SetHello.
+type HelloConfig struct {
+	// Server, Version, Mode, and Role are the server properties that are
+	// included in the reply. They default to "redis", "7.0.0",
+	// "standalone", and "master".
+	Server  string
+	Version string
+	Mode    string
+	Role    string
+	// Auth checks the credentials of the AUTH option. When nil, the AUTH
+	// option is checked using the function of SetAuth, or is refused when
+	// password authentication is not enabled.
+	Auth func(conn Conn, user, pass string) bool
+}
+
+// SetHello enables the built-in handling of the HELLO command:
+//
+//   HELLO [protover [AUTH username password] [SETNAME clientname]]
+//
+// The server switches the protocol of the connection, checks the
+// credentials using the Auth function, sets the name of the connection, and
+// replies with a map of the server properties. HELLO commands are not passed
+// to the handler. A nil config disables the handling, which is the default.
+// The setting applies to connections that are accepted after the call.
+//
+// When challenge-response authentication is enabled, see SetAuthChallenge,
+// clients must authenticate before sending HELLO, and the AUTH option is
+// refused.
+func (s *Server) SetHello(config *HelloConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hello = config
+}
+
+// hello handles the HELLO command, writing the reply to w.
+func (c *conn) hello(w *Writer, cmd Command) {
+	hello, err := ParseHello(cmd)
+	if err != nil {
+		w.WriteError(err.Error())
+		return
+	}
+	if hello.Auth {
+		auth := c.helloConfig.Auth
+		if auth == nil {
+			auth = c.authPass
+		}
+		if auth == nil || c.authSecret != nil {
+			w.WriteError("ERR AUTH option is not supported")
+			return
+		}
+		if !auth(c, hello.User, hello.Pass) {
+			w.WriteError("WRONGPASS invalid username-password pair " +
+				"or user is disabled.")
+			return
+		}
+		c.authed = true
+	} else if c.authPass != nil && !c.authed {
+		w.WriteError("NOAUTH HELLO must be called with the client already " +
+			"authenticated, otherwise the HELLO <proto> AUTH <user> <pass> " +
+			"option can be used to authenticate the client and select the " +
+			"RESP protocol version at the same time")
+		return
+	}
+	if hello.SetName {
+		c.SetName(hello.Name)
+	}
+	if hello.Protocol != 0 {
+		c.SetProtocol(hello.Protocol)
+		w.SetProtocol(resp.Protocol(hello.Protocol))
+	}
+	or := func(s, def string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	}
+	w.WriteMap(7)
+	w.WriteBulkString("server")
+	w.WriteBulkString(or(c.helloConfig.Server, "redis"))
+	w.WriteBulkString("version")
+	w.WriteBulkString(or(c.helloConfig.Version, "7.0.0"))
+	w.WriteBulkString("proto")
+	w.WriteInt(c.Protocol())
+	w.WriteBulkString("id")
+	w.WriteUint64(c.id)
+	w.WriteBulkString("mode")
+	w.WriteBulkString(or(c.helloConfig.Mode, "standalone"))
+	w.WriteBulkString("role")
+	w.WriteBulkString(or(c.helloConfig.Role, "master"))
+	w.WriteBulkString("modules")
+	w.WriteArray(0)
+}
@@ -0,0 +1,119 @@
+package redcon
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseHello(t *testing.T) {
+	tests := []struct {
+		args  []string
+		hello Hello
+		err   string
+	}{
+		{[]string{"hello"}, Hello{}, ""},
+		{[]string{"hello", "3"}, Hello{Protocol: 3}, ""},
+		{[]string{"HELLO", "2", "auth", "jane", "secret", "SetName", "app"},
+			Hello{Protocol: 2, Auth: true, User: "jane", Pass: "secret",
+				SetName: true, Name: "app"}, ""},
+		{[]string{"hello", "three"}, Hello{},
+			"ERR Protocol version is not an integer or out of range"},
+		{[]string{"hello", "4"}, Hello{},
+			"NOPROTO unsupported protocol version"},
+		{[]string{"hello", "3", "auth", "jane"}, Hello{Protocol: 3},
+			"ERR Syntax error in HELLO option 'auth'"},
+		{[]string{"hello", "3", "foo"}, Hello{Protocol: 3},
+			"ERR Syntax error in HELLO option 'foo'"},
+	}
+	for _, tt := range tests {
+		var cmd Command
+		for _, arg := range tt.args {
+			cmd.Args = append(cmd.Args, []byte(arg))
+		}
+		hello, err := ParseHello(cmd)
+		if hello != tt.hello {
+			t.Fatalf("%v: expected %+v, got %+v", tt.args, tt.hello, hello)
+		}
+		if (err == nil && tt.err != "") ||
+			(err != nil && err.Error() != tt.err) {
+			t.Fatalf("%v: expected %q, got %v", tt.args, tt.err, err)
+		}
+	}
+}
+
+func TestSetProtocol(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "hello":
+			hello, err := ParseHello(cmd)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			if hello.Protocol != 0 {
+				conn.SetProtocol(hello.Protocol)
+			}
+			conn.WriteInt(conn.Protocol())
+		case "float":
+			conn.WriteFloat(1.5)
+		}
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"float\r\n", "$3\r\n1.5\r\n"},
+		{"hello\r\n", ":2\r\n"},
+		{"hello 3\r\n", ":3\r\n"},
+		{"float\r\n", ",1.5\r\n"},
+		{"hello 1\r\n", "-NOPROTO unsupported protocol version\r\n"},
+		{"hello 2\r\n", ":2\r\n"},
+		{"float\r\n", "$3\r\n1.5\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
+
+func TestServerHello(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString(conn.Name())
+	}, nil, nil)
+	s.SetHello(&HelloConfig{
+		Server: "test",
+		Auth: func(conn Conn, user, pass string) bool {
+			return user == "jane" && pass == "secret"
+		},
+	})
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	props := func(proto string) string {
+		return "$6\r\nserver\r\n$4\r\ntest\r\n$7\r\nversion\r\n$5\r\n7.0.0\r\n" +
+			"$5\r\nproto\r\n:" + proto + "\r\n$2\r\nid\r\n:1\r\n" +
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n" +
+			"$7\r\nmodules\r\n*0\r\n"
+	}
+	for _, tt := range []struct{ cmd, exp string }{
+		{"hello\r\n", "*14\r\n" + props("2")},
+		{"hello 3 auth jane wrong setname app\r\n",
+			"-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"name\r\n", "+\r\n"},
+		{"hello 3 auth jane secret setname app\r\n", "%7\r\n" + props("3")},
+		{"name\r\n", "+app\r\n"},
+		{"hello 4\r\n", "-NOPROTO unsupported protocol version\r\n"},
+	} {
+		resp := testDo(t, c, tt.cmd)
+		for len(resp) < len(tt.exp) {
+			resp += testDo(t, c, "")
+		}
+		if resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package redcon
+
+// IncomingCommand is a command that is delivered on the channel that is
+// returned by Commands.
+type IncomingCommand struct {
+	// Conn is the connection that sent the command. Use Reply for writing
+	// the reply, instead of the write methods of the connection.
+	Conn Conn
+	// Command is the command.
+	Command Command
+
+	promise *ReplyPromise
+}
+
+// Reply writes the reply to the command using fn. The reply is sent to the
+// client once the replies to the commands before it have been sent. Every
+// command must be replied to exactly once, because the replies to the
+// commands that follow it are held until then.
+func (ic IncomingCommand) Reply(fn func(w *Writer)) {
+	ic.promise.Fulfill(fn)
+}
+
+// Commands returns a channel on which the server delivers all commands,
+// instead of calling the handler. It allows for processing the traffic of
+// all connections on a single goroutine, such as a state machine, without
+// locks.
+//
+//   cmds := s.Commands()
+//   go s.ListenAndServe()
+//   for ic := range cmds {
+//       switch strings.ToLower(string(ic.Command.Args[0])) {
+//       case "get":
+//           val, ok := store[string(ic.Command.Args[1])]
+//           ic.Reply(func(w *redcon.Writer) {
+//               w.WriteBulkOrNull(val, ok)
+//           })
+//       ...
+//       }
+//   }
+//
+// It must be called before the server is started. The commands of each
+// connection are delivered in order, and a connection waits while the
+// channel is full. It can't be used together with SetUnsafeNoCopy or
+// SetLargeArgThreshold, because the arguments of the commands must stay
+// valid after the server has read the next commands. The commands that are
+// answered by the server itself, such as AUTH and HELLO, are not delivered.
+// It's not supported by ServeMemcache.
+func (s *Server) Commands() <-chan IncomingCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.incoming == nil {
+		s.incoming = make(chan IncomingCommand, 256)
+		s.handler = s.deliver
+	}
+	return s.incoming
+}
+
+// deliver is the handler of the server when the commands are delivered on
+// the channel that is returned by Commands.
+func (s *Server) deliver(conn Conn, cmd Command) {
+	ic := IncomingCommand{
+		Conn:    conn,
+		Command: cmd,
+		promise: NewReplyPromise(conn),
+	}
+	select {
+	case s.incoming <- ic:
+	case <-conn.ClosedChan():
+	}
+}
@@ -0,0 +1,53 @@
+package redcon
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		t.Error("unexpected call to the handler")
+	}, nil, nil)
+	cmds := s.Commands()
+	addr := testServe(t, s)
+	go func() {
+		// a single goroutine owns the store
+		store := make(map[string][]byte)
+		for ic := range cmds {
+			args := ic.Command.Args
+			switch string(args[0]) {
+			case "set":
+				store[string(args[1])] = args[2]
+				ic.Reply(func(w *Writer) { w.WriteString("OK") })
+			case "get":
+				val, ok := store[string(args[1])]
+				ic.Reply(func(w *Writer) { w.WriteBulkOrNull(val, ok) })
+			}
+		}
+	}()
+	c1, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	exp := "+OK\r\n$1\r\n1\r\n"
+	resp := testDo(t, c1, "set a 1\r\nget a\r\n")
+	for len(resp) < len(exp) {
+		resp += testDo(t, c1, "")
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+	if resp := testDo(t, c2, "get a\r\n"); resp != "$1\r\n1\r\n" {
+		t.Fatalf("expected %q, got %q", "$1\r\n1\r\n", resp)
+	}
+	if resp := testDo(t, c2, "get b\r\n"); resp != "$-1\r\n" {
+		t.Fatalf("expected %q, got %q", "$-1\r\n", resp)
+	}
+}
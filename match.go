@@ -0,0 +1,178 @@
+package redcon
+
+// Command names, keys, and channels are binary strings. The functions in this
+// file operate on bytes and only fold the ASCII letters, like Redis does, so
+// that arbitrary bytes are never altered and non-ASCII characters can't be
+// folded into ASCII ones, such as the Kelvin sign into 'k'.
+
+// asciiLower returns b with the ASCII uppercase letters converted to
+// lowercase. All other bytes are unchanged.
+func asciiLower(b []byte) string {
+	for i := 0; i < len(b); i++ {
+		if b[i] >= 'A' && b[i] <= 'Z' {
+			lower := make([]byte, len(b))
+			copy(lower, b)
+			for ; i < len(lower); i++ {
+				if lower[i] >= 'A' && lower[i] <= 'Z' {
+					lower[i] += 'a' - 'A'
+				}
+			}
+			return string(lower)
+		}
+	}
+	return string(b)
+}
+
+// appendLower appends b with the ASCII uppercase letters converted to
+// lowercase.
+func appendLower(dst, b []byte) []byte {
+	for _, c := range b {
+		dst = append(dst, toLowerASCII(c))
+	}
+	return dst
+}
+
+// asciiUpper converts the ASCII lowercase letters of b to uppercase, in
+// place.
+func asciiUpper(b []byte) {
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+	}
+}
+
+// Is returns true when the name of the command, which is the first
+// argument, equals name. Only ASCII letters are compared case-insensitively,
+// like the command names of Redis, and no memory is allocated.
+//
+//   switch {
+//   case cmd.Is("get"):
+//       ...
+//   case cmd.Is("set"):
+//       ...
+//   }
+func (cmd Command) Is(name string) bool {
+	return len(cmd.Args) > 0 && asciiEqualFold(string(cmd.Args[0]), name)
+}
+
+// SetNormalizeCommands converts the name of each command, which is the first
+// argument, to uppercase before the command is passed to the handler, so
+// handlers can match the name without converting it, such as with
+// switch string(cmd.Args[0]). Only ASCII letters are converted, in place, so
+// the name in the Raw of the command is converted too. The setting is
+// disabled by default, and applies to connections that are accepted after
+// the call.
+func (s *Server) SetNormalizeCommands(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.normalize = on
+}
+
+// asciiEqualFold returns true when a and b are equal, ignoring the case of
+// ASCII letters.
+func asciiEqualFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if toLowerASCII(a[i]) != toLowerASCII(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func toLowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// globMatch returns true when str matches the glob-style pattern, using the
+// rules of the Redis KEYS and PSUBSCRIBE commands:
+//
+//   *       matches any sequence of bytes
+//   ?       matches any single byte
+//   [abc]   matches one of the bytes in the brackets
+//   [^abc]  matches any byte that is not in the brackets
+//   [a-z]   matches a byte in the range
+//   \x      matches the byte x
+//
+// Matching is done on bytes, so '?' matches a single byte of a multibyte
+// UTF-8 character.
+func globMatch(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			var match bool
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == str[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// like Redis, an unterminated class matches to the end
+				pattern = "]"
+			}
+			if match == not {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
@@ -0,0 +1,224 @@
+package redcon
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestASCIIFolding(t *testing.T) {
+	if s := asciiLower([]byte("\xffGET\xc4\xb0")); s != "\xffget\xc4\xb0" {
+		t.Fatalf("expected %q, got %q", "\xffget\xc4\xb0", s)
+	}
+	if s := asciiLower([]byte("get")); s != "get" {
+		t.Fatalf("expected %q, got %q", "get", s)
+	}
+	for _, tt := range []struct {
+		a, b string
+		exp  bool
+	}{
+		{"AUTH", "auth", true},
+		{"KEYS", "keys", false}, // Kelvin sign
+		{"ſet", "set", false},   // long s
+		{"\xff", "\xff", true},
+		{"\xff", "\xdf", false},
+	} {
+		if asciiEqualFold(tt.a, tt.b) != tt.exp {
+			t.Fatalf("%q %q: expected %v", tt.a, tt.b, tt.exp)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, str string
+		exp          bool
+	}{
+		{"*", "", true},
+		{"*", "\xff\x00", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "h\xc3\xa9llo", false}, // é is two bytes
+		{"h??llo", "h\xc3\xa9llo", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hallo", false},
+		{"news.\xff*", "news.\xff\xfe", true},
+		{"news.\xff*", "news.\xfe", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"[abc", "a", true},
+	} {
+		if globMatch(tt.pattern, tt.str) != tt.exp {
+			t.Fatalf("%q %q: expected %v", tt.pattern, tt.str, tt.exp)
+		}
+	}
+}
+
+func TestServeMuxBinaryCommands(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("keys", func(conn Conn, cmd Command) {
+		conn.WriteString("KEYS")
+	})
+	mux.HandleFunc("\xffcmd", func(conn Conn, cmd Command) {
+		conn.WriteString("BINARY")
+	})
+	s := NewServer("", mux.ServeRESP, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"KEYS", "+KEYS\r\n"},
+		{"KEYS", "-ERR unknown command 'KEYS', with args beginning with: \r\n"},
+		{"\xffCMD", "+BINARY\r\n"},
+	} {
+		b := AppendArray(nil, 1)
+		b = AppendBulkString(b, tt.cmd)
+		if resp := testDo(t, c, string(b)); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
+
+func TestServeMuxNotFound(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("GET", func(conn Conn, cmd Command) {
+		conn.WriteString("GET")
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected a panic")
+			}
+		}()
+		mux.HandleFunc("get", func(conn Conn, cmd Command) {})
+	}()
+	s := NewServer("", mux.ServeRESP, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"get", "+GET\r\n"},
+		{"Get", "+GET\r\n"},
+		{"SET", "-ERR unknown command 'SET', with args beginning with: \r\n"},
+	} {
+		b := AppendArray(nil, 1)
+		b = AppendBulkString(b, tt.cmd)
+		if resp := testDo(t, c, string(b)); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+	mux.NotFound = HandlerFunc(func(conn Conn, cmd Command) {
+		conn.WriteString("FALLBACK " + string(cmd.Args[0]))
+	})
+	if resp := testDo(t, c, "*1\r\n$3\r\nSET\r\n"); resp != "+FALLBACK SET\r\n" {
+		t.Fatalf("expected %q, got %q", "+FALLBACK SET\r\n", resp)
+	}
+}
+
+func TestServeMuxSubcommands(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("CONFIG GET", func(conn Conn, cmd Command) {
+		conn.WriteString("CONFIG GET")
+	})
+	mux.HandleFunc("config set", func(conn Conn, cmd Command) {
+		conn.WriteString("CONFIG SET")
+	})
+	mux.HandleFunc("client kill", func(conn Conn, cmd Command) {
+		conn.WriteString("CLIENT KILL")
+	})
+	mux.HandleFunc("client", func(conn Conn, cmd Command) {
+		conn.WriteString("CLIENT")
+	})
+	// only the ASCII letters are folded, U+017F isn't uppercased to 'S'
+	mux.HandleFunc("\u017fet get", func(conn Conn, cmd Command) {
+		conn.WriteString("\u017fET GET")
+	})
+	for _, command := range []string{"Config Get", "config ", "config a b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%q: expected a panic", command)
+				}
+			}()
+			mux.HandleFunc(command, func(conn Conn, cmd Command) {})
+		}()
+	}
+	s := NewServer("", mux.ServeRESP, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"CONFIG GET port", "+CONFIG GET\r\n"},
+		{"config Set port 1", "+CONFIG SET\r\n"},
+		{"config Foo", "-ERR unknown subcommand 'Foo'. Try CONFIG HELP.\r\n"},
+		{"config", "-ERR wrong number of arguments for 'config' command\r\n"},
+		{"\u017fet x", "-ERR unknown subcommand 'x'. Try \u017fET HELP.\r\n"},
+		{"client kill x", "+CLIENT KILL\r\n"},
+		{"client list", "+CLIENT\r\n"},
+		{"client", "+CLIENT\r\n"},
+		{"get x", "-ERR unknown command 'get', with args beginning with: 'x' \r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%q: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
+
+func TestCommandIs(t *testing.T) {
+	cmd := Command{Args: [][]byte{[]byte("GeT"), []byte("key")}}
+	if !cmd.Is("get") || !cmd.Is("GET") || cmd.Is("set") || cmd.Is("ge") {
+		t.Fatal("unexpected match")
+	}
+	if (Command{}).Is("") {
+		t.Fatal("expected no match for a command without arguments")
+	}
+	if n := testing.AllocsPerRun(100, func() { cmd.Is("get") }); n != 0 {
+		t.Fatalf("expected no allocations, got %v", n)
+	}
+}
+
+func TestServeMuxAllocs(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("get", func(conn Conn, cmd Command) {})
+	mux.HandleCommandFunc(CommandInfo{Name: "config get", Arity: 3},
+		func(conn Conn, cmd Command) {})
+	for _, args := range []string{"GET key", "Config Get port"} {
+		cmd := Command{Args: bytes.Fields([]byte(args))}
+		if n := testing.AllocsPerRun(100, func() {
+			mux.ServeRESP(nil, cmd)
+		}); n != 0 {
+			t.Fatalf("%q: expected no allocations, got %v", args, n)
+		}
+	}
+}
+
+func TestNormalizeCommands(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteBulk(cmd.Args[0])
+	}, nil, nil)
+	s.SetNormalizeCommands(true)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "*2\r\n$3\r\nsEt\r\n$3\r\nkey\r\n"); resp != "$3\r\nSET\r\n" {
+		t.Fatalf("expected %q, got %q", "$3\r\nSET\r\n", resp)
+	}
+	if resp := testDo(t, c, "\xe2\x84\xaaeys\r\n"); resp != "$6\r\n\xe2\x84\xaaEYS\r\n" {
+		t.Fatalf("expected %q, got %q", "$6\r\n\xe2\x84\xaaEYS\r\n", resp)
+	}
+}
@@ -0,0 +1,309 @@
+package redcon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ServeMemcache serves memcached text protocol clients on the provided
+// listener. Each memcached request is translated to the equivalent Redis
+// command and passed to the same handler that serves the Redis clients, which
+// makes it possible to move memcached clients onto a Redcon based store.
+//
+//   get/gets key [key ...]  -> GET key (for each key)
+//   set key flags exp n     -> SET key value [EX exp]
+//   add key flags exp n     -> SET key value NX [EX exp]
+//   replace key flags exp n -> SET key value XX [EX exp]
+//   delete key              -> DEL key
+//
+// The flags of stored items are not retained and are always reported as
+// zero, and so is the cas value of a gets reply, because the cas command is
+// not supported.
+//
+// Memcached connections are admitted and tracked like the Redis connections.
+// The accept rate limit, the access list, drain mode, and the maximum number
+// of clients apply, and the refused connections are sent the error message
+// as a SERVER_ERROR reply. The accept and closed functions of the server are
+// called, the connections are included in ClientList and the client count,
+// and they are closed when the server is closed. They are not included in
+// Broadcast.
+//
+// Values that are larger than the proto max bulk length of the server, see
+// SetProtoMaxBulkLen, and request lines that are longer than 64 KB are
+// refused, and the connection is closed. ServeMemcache returns when the
+// listener is closed.
+func (s *Server) ServeMemcache(ln net.Listener) error {
+	var limiter rateLimiter
+	for {
+		lnconn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			done := s.done
+			s.mu.Unlock()
+			if done {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if s.AcceptError != nil {
+					s.AcceptError(err)
+				}
+				continue
+			}
+			return err
+		}
+		if !s.admit(lnconn, &limiter, func(msg string) []byte {
+			return []byte("SERVER_ERROR " + msg + "\r\n")
+		}) {
+			continue
+		}
+		// the replies of the handler are read by memcacheDo
+		c := &conn{
+			id:       atomic.AddUint64(&s.nextID, 1),
+			conn:     lnconn,
+			addr:     lnconn.RemoteAddr().String(),
+			wr:       NewWriter(&bytes.Buffer{}),
+			rd:       NewReader(nil),
+			memcache: true,
+		}
+		s.mu.Lock()
+		if s.conns == nil {
+			// the server is closed
+			s.mu.Unlock()
+			lnconn.Close()
+			continue
+		}
+		if s.maxClients > 0 && len(s.conns) >= s.maxClients {
+			s.mu.Unlock()
+			atomic.AddUint64(&s.stats.rejectedConns, 1)
+			rejectConn(lnconn,
+				[]byte("SERVER_ERROR max number of clients reached\r\n"))
+			continue
+		}
+		c.idleClose = s.idleClose
+		c.largeReply = s.largeReply
+		c.outputWM = s.outputWM
+		c.rd.maxBulk = s.protoMaxBulk
+		c.handler = s.handler
+		c.auditArgs = s.auditArgs
+		s.conns[c] = true
+		s.mu.Unlock()
+		if s.accept != nil && !s.accept(c) {
+			atomic.AddUint64(&s.stats.rejectedConns, 1)
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+			continue
+		}
+		go handleMemcache(s, c)
+	}
+}
+
+// handleMemcache manages a memcached connection.
+func handleMemcache(s *Server, c *conn) {
+	// memcached connections are always considered to be dispatching, which
+	// makes a call to Detach close the connection once the request completes.
+	c.setDispatching(true)
+	err := func() error {
+		rd := bufio.NewReader(c.conn)
+		wr := bufio.NewWriter(c.conn)
+		for {
+			if c.idleClose != 0 {
+				c.conn.SetReadDeadline(time.Now().Add(c.idleClose))
+			}
+			line, err := readMemcacheLine(rd)
+			if err == errMemcacheLineTooLong {
+				wr.WriteString("CLIENT_ERROR line too long\r\n")
+				return wr.Flush()
+			}
+			if err != nil {
+				return err
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				wr.WriteString("ERROR\r\n")
+			} else if err := c.memcacheRequest(s, rd, wr, fields); err != nil {
+				return err
+			}
+			if c.isClosed() || c.isDetached() {
+				return nil
+			}
+			if c.isClosing() {
+				return wr.Flush()
+			}
+			if rd.Buffered() == 0 {
+				if err := wr.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}()
+	c.conn.Close()
+	c.disconnected()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	if s.closed != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		s.closed(c, err)
+	}
+	c.release()
+}
+
+// memcacheMaxLineLen is the maximum length of a request line, which leaves
+// room for a get of many keys.
+const memcacheMaxLineLen = 64 * 1024
+
+var errMemcacheLineTooLong = errors.New("line too long")
+
+// readMemcacheLine reads a request line, without buffering more than
+// memcacheMaxLineLen bytes.
+func readMemcacheLine(rd *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, err := rd.ReadSlice('\n')
+		if len(line)+len(b) > memcacheMaxLineLen {
+			return "", errMemcacheLineTooLong
+		}
+		line = append(line, b...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// memcacheRequest translates a single memcached request into Redis commands
+// and writes the memcached reply to wr.
+func (c *conn) memcacheRequest(s *Server, rd *bufio.Reader, wr *bufio.Writer,
+	fields []string,
+) error {
+	switch fields[0] {
+	case "get", "gets":
+		if len(fields) < 2 {
+			wr.WriteString("ERROR\r\n")
+			return nil
+		}
+		for _, key := range fields[1:] {
+			resp := c.memcacheDo(s, "GET", key)
+			switch resp.Type {
+			case Bulk:
+				if resp.Data == nil {
+					continue
+				}
+				wr.WriteString("VALUE " + key + " 0 " +
+					strconv.Itoa(len(resp.Data)))
+				if fields[0] == "gets" {
+					wr.WriteString(" 0")
+				}
+				wr.WriteString("\r\n")
+				wr.Write(resp.Data)
+				wr.WriteString("\r\n")
+			case Error:
+				wr.WriteString("SERVER_ERROR " + string(resp.Data) + "\r\n")
+				return nil
+			}
+		}
+		wr.WriteString("END\r\n")
+	case "set", "add", "replace":
+		if len(fields) < 5 {
+			wr.WriteString("ERROR\r\n")
+			return nil
+		}
+		exptime, err1 := strconv.ParseInt(fields[3], 10, 64)
+		n, err2 := strconv.Atoi(fields[4])
+		if err1 != nil || err2 != nil || n < 0 {
+			wr.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return nil
+		}
+		if n > c.rd.bulkLimit() || n+2 < n {
+			// the value is not read, so the connection can't continue
+			wr.WriteString("SERVER_ERROR object too large for cache\r\n")
+			c.CloseAfterFlush()
+			return nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return err
+		}
+		if data[n] != '\r' || data[n+1] != '\n' {
+			wr.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return nil
+		}
+		args := []string{"SET", fields[1], string(data[:n])}
+		switch fields[0] {
+		case "add":
+			args = append(args, "NX")
+		case "replace":
+			args = append(args, "XX")
+		}
+		if exptime > 60*60*24*30 {
+			// memcached treats large expiration times as unix timestamps.
+			args = append(args, "EXAT", strconv.FormatInt(exptime, 10))
+		} else if exptime > 0 {
+			args = append(args, "EX", strconv.FormatInt(exptime, 10))
+		}
+		resp := c.memcacheDo(s, args...)
+		if len(fields) > 5 && fields[5] == "noreply" {
+			return nil
+		}
+		switch resp.Type {
+		case String:
+			wr.WriteString("STORED\r\n")
+		case Error:
+			wr.WriteString("SERVER_ERROR " + string(resp.Data) + "\r\n")
+		default:
+			wr.WriteString("NOT_STORED\r\n")
+		}
+	case "delete":
+		if len(fields) < 2 {
+			wr.WriteString("ERROR\r\n")
+			return nil
+		}
+		resp := c.memcacheDo(s, "DEL", fields[1])
+		if len(fields) > 2 && fields[len(fields)-1] == "noreply" {
+			return nil
+		}
+		switch {
+		case resp.Type == Error:
+			wr.WriteString("SERVER_ERROR " + string(resp.Data) + "\r\n")
+		case resp.Type == Integer && string(resp.Data) != "0":
+			wr.WriteString("DELETED\r\n")
+		default:
+			wr.WriteString("NOT_FOUND\r\n")
+		}
+	case "quit":
+		atomic.StoreInt32(&c.closed, 1)
+	default:
+		wr.WriteString("ERROR\r\n")
+	}
+	return nil
+}
+
+// memcacheDo calls the server handler with a Redis command and returns the
+// first reply that was written by the handler.
+func (c *conn) memcacheDo(s *Server, args ...string) RESP {
+	var wr Writer
+	wr.WriteArray(len(args))
+	cmd := Command{Args: make([][]byte, len(args))}
+	for i, arg := range args {
+		wr.WriteBulkString(arg)
+		cmd.Args[i] = []byte(arg)
+	}
+	cmd.Raw = wr.b
+	out := c.wr.w.(*bytes.Buffer)
+	out.Reset()
+	c.wr.SetBuffer(nil)
+	c.dispatch(s, cmd)
+	c.wr.Flush()
+	_, resp := ReadNextRESP(out.Bytes())
+	return resp
+}
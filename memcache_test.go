@@ -0,0 +1,159 @@
+package redcon
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemcache(t *testing.T) {
+	var mu sync.Mutex
+	items := make(map[string]string)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.ToLower(string(cmd.Args[0])) {
+		case "get":
+			if val, ok := items[string(cmd.Args[1])]; ok {
+				conn.WriteBulkString(val)
+			} else {
+				conn.WriteNull()
+			}
+		case "set":
+			if len(cmd.Args) > 3 && string(cmd.Args[3]) == "NX" {
+				if _, ok := items[string(cmd.Args[1])]; ok {
+					conn.WriteNull()
+					return
+				}
+			}
+			items[string(cmd.Args[1])] = string(cmd.Args[2])
+			conn.WriteString("OK")
+		case "del":
+			if _, ok := items[string(cmd.Args[1])]; ok {
+				delete(items, string(cmd.Args[1]))
+				conn.WriteInt(1)
+			} else {
+				conn.WriteInt(0)
+			}
+		}
+	}, nil, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.ServeMemcache(ln)
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	tests := []struct{ req, res string }{
+		{"get foo\r\n", "END\r\n"},
+		{"set foo 0 0 5\r\nhello\r\n", "STORED\r\n"},
+		{"add foo 0 0 3\r\nbye\r\n", "NOT_STORED\r\n"},
+		{"get foo bar\r\n", "VALUE foo 0 5\r\nhello\r\nEND\r\n"},
+		{"gets foo\r\n", "VALUE foo 0 5 0\r\nhello\r\nEND\r\n"},
+		{"delete foo\r\n", "DELETED\r\n"},
+		{"delete foo\r\n", "NOT_FOUND\r\n"},
+		{"flush_all\r\n", "ERROR\r\n"},
+	}
+	for _, tt := range tests {
+		if res := testDo(t, c, tt.req); res != tt.res {
+			t.Fatalf("%q: expected %q, got %q", tt.req, tt.res, res)
+		}
+	}
+}
+
+func TestMemcacheLimits(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetProtoMaxBulkLen(16)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.ServeMemcache(ln)
+	for _, tt := range []struct{ req, res string }{
+		{"set k 0 0 9223372036854775807\r\n",
+			"SERVER_ERROR object too large for cache\r\n"},
+		{"set k 0 0 17\r\n", "SERVER_ERROR object too large for cache\r\n"},
+		{"get " + strings.Repeat("k", 70000) + "\r\n",
+			"CLIENT_ERROR line too long\r\n"},
+	} {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if res := testDo(t, c, tt.req); res != tt.res {
+			t.Fatalf("%.40q: expected %q, got %q", tt.req, tt.res, res)
+		}
+		// the connection is closed
+		if _, err := c.Read(make([]byte, 1)); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	req := "set k 0 0 16\r\n" + strings.Repeat("v", 16) + "\r\n"
+	if res := testDo(t, c, req); res != "STORED\r\n" {
+		t.Fatalf("expected %q, got %q", "STORED\r\n", res)
+	}
+}
+
+func TestMemcacheAdmission(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteNull()
+	}, nil, nil)
+	testServe(t, s)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.ServeMemcache(ln)
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if res := testDo(t, c, "get k\r\n"); res != "END\r\n" {
+		t.Fatalf("expected %q, got %q", "END\r\n", res)
+	}
+	if n := len(s.ClientList()); n != 1 {
+		t.Fatalf("expected %v, got %v", 1, n)
+	}
+	refused := func(exp string) {
+		t.Helper()
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if res := testDo(t, c, ""); res != exp {
+			t.Fatalf("expected %q, got %q", exp, res)
+		}
+	}
+	s.SetMaxClients(1)
+	refused("SERVER_ERROR max number of clients reached\r\n")
+	s.SetMaxClients(0)
+	s.SetDrainError("draining")
+	s.Drain()
+	refused("SERVER_ERROR draining\r\n")
+	s.Undrain()
+
+	// closing the server closes the memcached connections
+	s.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected error")
+	}
+}
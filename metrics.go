@@ -0,0 +1,223 @@
+package redcon
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MetricsFormat is the wire format used by a MetricsEmitter.
+type MetricsFormat int
+
+const (
+	// StatsD sends counters and gauges using the StatsD protocol.
+	StatsD MetricsFormat = iota
+	// Graphite sends values using the Graphite plaintext protocol.
+	Graphite
+)
+
+// MetricsEmitter periodically sends the server stats to a StatsD or Graphite
+// server.
+//
+//   m := &redcon.MetricsEmitter{
+//       Server: s,
+//       Format: redcon.StatsD,
+//       Addr:   "127.0.0.1:8125",
+//       Prefix: "myapp.redcon",
+//   }
+//   if err := m.Start(); err != nil {
+//       log.Fatal(err)
+//   }
+//   defer m.Stop()
+type MetricsEmitter struct {
+	// Server is the server that stats are collected from.
+	Server *Server
+	// Format is the wire format of the metrics.
+	Format MetricsFormat
+	// Network is the network used for sending metrics. Defaults to "udp" for
+	// StatsD and "tcp" for Graphite.
+	Network string
+	// Addr is the address of the metrics server.
+	Addr string
+	// Prefix is prepended to each metric name, separated by a dot.
+	Prefix string
+	// Interval is the time between flushes. Defaults to 10 seconds.
+	Interval time.Duration
+
+	fmu  sync.Mutex // serializes the flushes
+	mu   sync.Mutex // guards the fields below
+	conn net.Conn
+	last Stats
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start begins sending metrics in the background.
+func (m *MetricsEmitter) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Server == nil {
+		return errors.New("no server")
+	}
+	if m.stop != nil {
+		return errors.New("already started")
+	}
+	m.last = m.Server.Stats()
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.run(m.interval(), m.stop, m.done)
+	return nil
+}
+
+// Stop sends the final metrics and stops the emitter.
+func (m *MetricsEmitter) Stop() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// interval returns the time between flushes, which also limits the time that
+// a flush may take.
+func (m *MetricsEmitter) interval() time.Duration {
+	if m.Interval <= 0 {
+		return time.Second * 10
+	}
+	return m.Interval
+}
+
+func (m *MetricsEmitter) run(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			m.Flush()
+		case <-stop:
+			m.Flush()
+			m.fmu.Lock()
+			m.mu.Lock()
+			if m.conn != nil {
+				m.conn.Close()
+				m.conn = nil
+			}
+			m.mu.Unlock()
+			m.fmu.Unlock()
+			return
+		}
+	}
+}
+
+// Flush sends the current metrics immediately. Connecting and writing to the
+// metrics server may take up to the Interval each, so a metrics server that
+// stalls can't block the emitter.
+func (m *MetricsEmitter) Flush() error {
+	m.fmu.Lock()
+	defer m.fmu.Unlock()
+	m.mu.Lock()
+	stats := m.Server.Stats()
+	data := m.appendMetrics(nil, stats, time.Now())
+	conn := m.conn
+	timeout := m.interval()
+	m.mu.Unlock()
+	if conn == nil {
+		network := m.Network
+		if network == "" {
+			if m.Format == Graphite {
+				network = "tcp"
+			} else {
+				network = "udp"
+			}
+		}
+		var err error
+		conn, err = net.DialTimeout(network, m.Addr, timeout)
+		if err != nil {
+			return err
+		}
+	}
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := conn.Write(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		conn.Close()
+		m.conn = nil
+		return err
+	}
+	m.conn = conn
+	m.last = stats
+	return nil
+}
+
+// appendMetrics appends the metrics for stats using the emitter format.
+// StatsD counters are sent as the difference since the previous flush, while
+// Graphite values are sent as totals.
+func (m *MetricsEmitter) appendMetrics(b []byte, stats Stats, now time.Time,
+) []byte {
+	counters := []struct {
+		name      string
+		val, last uint64
+	}{
+		{"total_connections", stats.TotalConnections,
+			m.last.TotalConnections},
+		{"rejected_connections", stats.RejectedConnections,
+			m.last.RejectedConnections},
+		{"evicted_clients", stats.EvictedClients, m.last.EvictedClients},
+		{"total_commands", stats.TotalCommands, m.last.TotalCommands},
+	}
+	gauges := []struct {
+		name string
+		val  int64
+	}{
+		{"connected_clients", int64(stats.ConnectedClients)},
+		{"client_memory", stats.ClientMemory},
+	}
+	name := func(b []byte, name string) []byte {
+		if m.Prefix != "" {
+			b = append(b, m.Prefix...)
+			b = append(b, '.')
+		}
+		return append(b, name...)
+	}
+	switch m.Format {
+	case Graphite:
+		ts := strconv.FormatInt(now.Unix(), 10)
+		for _, c := range counters {
+			b = name(b, c.name)
+			b = append(b, ' ')
+			b = strconv.AppendUint(b, c.val, 10)
+			b = append(b, ' ')
+			b = append(b, ts...)
+			b = append(b, '\n')
+		}
+		for _, g := range gauges {
+			b = name(b, g.name)
+			b = append(b, ' ')
+			b = strconv.AppendInt(b, g.val, 10)
+			b = append(b, ' ')
+			b = append(b, ts...)
+			b = append(b, '\n')
+		}
+	default:
+		for _, c := range counters {
+			b = name(b, c.name)
+			b = append(b, ':')
+			b = strconv.AppendUint(b, c.val-c.last, 10)
+			b = append(b, "|c\n"...)
+		}
+		for _, g := range gauges {
+			b = name(b, g.name)
+			b = append(b, ':')
+			b = strconv.AppendInt(b, g.val, 10)
+			b = append(b, "|g\n"...)
+		}
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+package redcon
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEmitter(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	m := &MetricsEmitter{
+		Server:   s,
+		Addr:     pc.LocalAddr().String(),
+		Prefix:   "test",
+		Interval: time.Hour,
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	testDo(t, c, "ping\r\n")
+	testDo(t, c, "ping\r\n")
+	m.Stop()
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := string(buf[:n])
+	for _, exp := range []string{
+		"test.total_commands:2|c\n",
+		"test.connected_clients:1|g\n",
+	} {
+		if !strings.Contains(packet, exp) {
+			t.Fatalf("expected %q in %q", exp, packet)
+		}
+	}
+	var g MetricsEmitter
+	g.Format = Graphite
+	data := string(g.appendMetrics(nil, Stats{TotalCommands: 10},
+		time.Unix(100, 0)))
+	if !strings.Contains(data, "total_commands 10 100\n") {
+		t.Fatalf("unexpected graphite data %q", data)
+	}
+}
+
+func TestMetricsEmitterStall(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {}, nil, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// accept the connection, but never read from it
+		c, err := ln.Accept()
+		if err == nil {
+			defer c.Close()
+			<-done
+		}
+	}()
+	m := &MetricsEmitter{
+		Server:   s,
+		Format:   Graphite,
+		Addr:     ln.Addr().String(),
+		Prefix:   strings.Repeat("x", 4096),
+		Interval: time.Millisecond * 100,
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		// fill the socket buffers until a write times out
+		for {
+			if err := m.Flush(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-errs:
+		if err, ok := err.(net.Error); !ok || !err.Timeout() {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("flush is blocked")
+	}
+	m.Stop()
+}
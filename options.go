@@ -0,0 +1,179 @@
+package redcon
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+)
+
+// ParseOptions parses the trailing options of a command, such as the
+// EX seconds, PX milliseconds, NX, and XX options of SET, into the struct
+// that v points to. Each exported field is an option, whose name is the name
+// of the field, or the name in the "redcon" tag of the field, and options are
+// matched case-insensitively. A tag of "-" skips the field.
+//
+//   type SetOptions struct {
+//       EX      int64 `redcon:"ex,group=expire"`
+//       PX      int64 `redcon:"px,group=expire"`
+//       KeepTTL bool  `redcon:"keepttl,group=expire"`
+//       NX      bool  `redcon:"nx,group=cond"`
+//       XX      bool  `redcon:"xx,group=cond"`
+//       Get     bool  `redcon:"get"`
+//   }
+//
+//   var opts SetOptions
+//   if err := redcon.ParseOptions(cmd.Args[3:], &opts); err != nil {
+//       conn.WriteError(err.Error())
+//       return
+//   }
+//
+// Boolean fields are options without a value, which are set to true when the
+// option is given. Strings, byte slices, and numbers are options that are
+// followed by a value, and arrays are options that are followed by a value
+// for each element, such as LIMIT offset count into a [2]int64. Pointer
+// fields are nil when the option is not given. Options that share a group
+// are mutually exclusive.
+//
+// The message of the returned error is the reply that Redis sends to the
+// client, such as "ERR syntax error" for an unknown option, a missing value,
+// an option that is given twice, or options of the same group, and
+// "ERR value is not an integer or out of range" for an invalid number.
+func ParseOptions(args [][]byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		panic("redcon: ParseOptions requires a pointer to a struct")
+	}
+	rv = rv.Elem()
+	opts := optionFields(rv.Type())
+	seen := make([]bool, len(opts))
+	groups := make(map[string]bool)
+	for i := 0; i < len(args); {
+		j := findOption(opts, string(args[i]))
+		if j < 0 || seen[j] || groups[opts[j].group] {
+			return errSyntax
+		}
+		seen[j] = true
+		if opts[j].group != "" {
+			groups[opts[j].group] = true
+		}
+		fv := rv.Field(opts[j].index)
+		n := optionValues(fv.Type())
+		if len(args)-i-1 < n {
+			return errSyntax
+		}
+		if err := setOption(fv, args[i+1:i+1+n]); err != nil {
+			return err
+		}
+		i += 1 + n
+	}
+	return nil
+}
+
+// option is an option of the struct that is passed to ParseOptions.
+type option struct {
+	name  string
+	group string
+	index int
+}
+
+// optionFields returns the options of the struct type.
+func optionFields(t reflect.Type) []option {
+	var opts []option
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("redcon")
+		if tag == "-" || !sf.IsExported() {
+			continue
+		}
+		name, rest, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		opt := option{name: name, index: i}
+		for _, part := range strings.Split(rest, ",") {
+			if strings.HasPrefix(part, "group=") {
+				opt.group = part[len("group="):]
+			}
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+// findOption returns the index of the option with the name, or -1.
+func findOption(opts []option, name string) int {
+	for i := range opts {
+		if asciiEqualFold(opts[i].name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// optionValues returns the number of values that follow an option of the
+// type.
+func optionValues(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Bool:
+		return 0
+	case reflect.Ptr:
+		return optionValues(t.Elem())
+	case reflect.Array:
+		return t.Len()
+	}
+	return 1
+}
+
+// setOption sets the field of an option that was given with its values.
+func setOption(fv reflect.Value, vals [][]byte) error {
+	switch fv.Kind() {
+	case reflect.Bool:
+		fv.SetBool(true)
+	case reflect.Ptr:
+		fv.Set(reflect.New(fv.Type().Elem()))
+		return setOption(fv.Elem(), vals)
+	case reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			if err := setOption(fv.Index(i), vals[i:i+1]); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		fv.SetString(string(vals[0]))
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.Uint8 {
+			panic("redcon: unsupported option type " + fv.Type().String())
+		}
+		fv.SetBytes(append([]byte(nil), vals[0]...))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		n, err := strconv.ParseInt(string(vals[0]), 10, fv.Type().Bits())
+		if err != nil {
+			return errNotInteger
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		n, err := strconv.ParseUint(string(vals[0]), 10, fv.Type().Bits())
+		if err != nil {
+			return errNotInteger
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(string(vals[0]), fv.Type().Bits())
+		if err != nil || f != f {
+			return errNotFloat
+		}
+		fv.SetFloat(f)
+	default:
+		panic("redcon: unsupported option type " + fv.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package redcon
+
+import (
+	"strings"
+	"testing"
+)
+
+type testSetOptions struct {
+	EX      int64   `redcon:"ex,group=expire"`
+	PX      int64   `redcon:"px,group=expire"`
+	KeepTTL bool    `redcon:"keepttl,group=expire"`
+	NX      bool    `redcon:"nx,group=cond"`
+	XX      bool    `redcon:"xx,group=cond"`
+	Get     bool    `redcon:"get"`
+	Limit   [2]int  `redcon:"limit"`
+	Match   *string `redcon:"match"`
+	Score   float64
+	Skip    bool `redcon:"-"`
+}
+
+func TestParseOptions(t *testing.T) {
+	parse := func(args string) (testSetOptions, error) {
+		var opts testSetOptions
+		var bargs [][]byte
+		for _, arg := range strings.Fields(args) {
+			bargs = append(bargs, []byte(arg))
+		}
+		err := ParseOptions(bargs, &opts)
+		return opts, err
+	}
+	opts, err := parse("")
+	if err != nil || opts != (testSetOptions{}) {
+		t.Fatalf("expected no options, got %+v, %v", opts, err)
+	}
+	opts, err = parse("Ex 10 nx GET limit 5 20 score 1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.EX != 10 || !opts.NX || !opts.Get || opts.Limit != [2]int{5, 20} ||
+		opts.Score != 1.5 || opts.Match != nil {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	opts, err = parse("match h*llo")
+	if err != nil || opts.Match == nil || *opts.Match != "h*llo" {
+		t.Fatalf("unexpected options %+v, %v", opts, err)
+	}
+	for _, tc := range []struct {
+		args string
+		err  string
+	}{
+		{"foo", "ERR syntax error"},
+		{"skip", "ERR syntax error"},
+		{"ex", "ERR syntax error"},
+		{"limit 5", "ERR syntax error"},
+		{"nx nx", "ERR syntax error"},
+		{"nx xx", "ERR syntax error"},
+		{"ex 10 px 100", "ERR syntax error"},
+		{"keepttl ex 10", "ERR syntax error"},
+		{"ex ten", "ERR value is not an integer or out of range"},
+		{"ex 99999999999999999999", "ERR value is not an integer or out of range"},
+		{"limit 0 x", "ERR value is not an integer or out of range"},
+		{"score x", "ERR value is not a valid float"},
+		{"score nan", "ERR value is not a valid float"},
+	} {
+		if _, err := parse(tc.args); err == nil || err.Error() != tc.err {
+			t.Fatalf("%q: expected %q, got %v", tc.args, tc.err, err)
+		}
+	}
+}
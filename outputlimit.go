@@ -0,0 +1,77 @@
+package redcon
+
+import (
+	"net"
+	"time"
+)
+
+// outputLimit holds the output buffer limits of a connection.
+type outputLimit struct {
+	hard    int
+	soft    int
+	softDur time.Duration
+	since   time.Time // when the soft limit was first exceeded
+}
+
+// SetOutputBufferLimit limits the size of the output buffer of each
+// connection, which holds the replies that have not been sent to the client
+// yet, like the client-output-buffer-limit setting of Redis. It protects the
+// server from slow clients that don't read their replies, and from handlers
+// that write huge replies.
+//
+// The connection is closed as soon as its buffer reaches the hard limit, or
+// when the buffer stays at or above the soft limit for longer than softDur.
+// The pending replies are discarded, and the closed function receives
+// ErrOutputLimit. Use zero for the limits to disable them. The setting
+// applies to connections that are accepted after the call.
+func (s *Server) SetOutputBufferLimit(hard, soft int, softDur time.Duration) {
+	s.mu.Lock()
+	s.outputLimit = outputLimit{hard: hard, soft: soft, softDur: softDur}
+	s.mu.Unlock()
+}
+
+// exceeded returns true when the buffer size breaks the limits.
+func (l *outputLimit) exceeded(size int, now time.Time) bool {
+	if l.hard > 0 && size >= l.hard {
+		return true
+	}
+	if l.soft <= 0 || size < l.soft {
+		l.since = time.Time{}
+		return false
+	}
+	if l.since.IsZero() {
+		l.since = now
+	}
+	return now.Sub(l.since) > l.softDur
+}
+
+// outputLimitExceeded returns true when the output buffer of the connection
+// breaks the limits.
+func (c *conn) outputLimitExceeded() bool {
+	if c.outputLimit.hard <= 0 && c.outputLimit.soft <= 0 {
+		return false
+	}
+	return c.outputLimit.exceeded(c.wr.buffered(), time.Now())
+}
+
+// flush flushes the output buffer. When the buffer is at or above the soft
+// limit, the write must complete before the soft limit duration expires,
+// otherwise ErrOutputLimit is returned.
+func (c *conn) flush() error {
+	l := &c.outputLimit
+	if l.soft <= 0 || c.wr.buffered() < l.soft {
+		l.since = time.Time{}
+		return c.wr.Flush()
+	}
+	if l.since.IsZero() {
+		l.since = time.Now()
+	}
+	c.conn.SetWriteDeadline(l.since.Add(l.softDur))
+	err := c.wr.Flush()
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return ErrOutputLimit
+	}
+	c.conn.SetWriteDeadline(time.Time{})
+	l.since = time.Time{}
+	return err
+}
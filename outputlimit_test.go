@@ -0,0 +1,71 @@
+package redcon
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOutputBufferLimit(t *testing.T) {
+	closed := make(chan error, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		n, _ := strconv.Atoi(string(cmd.Args[1]))
+		conn.WriteBulk(make([]byte, n))
+	}, nil, func(conn Conn, err error) {
+		closed <- err
+	})
+	s.SetOutputBufferLimit(1<<20, 1<<10, time.Millisecond*100)
+	addr := testServe(t, s)
+
+	// below the soft limit
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := testDo(t, c, "get 5\r\n"); resp != "$5\r\n\x00\x00\x00\x00\x00\r\n" {
+		t.Fatalf("unexpected reply %q", resp)
+	}
+	c.Close()
+	if err := <-closed; err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// the hard limit discards the reply
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "get 2000000\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; err != ErrOutputLimit {
+		t.Fatalf("expected %v, got %v", ErrOutputLimit, err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if n, err := io.Copy(io.Discard, c); err != nil || n != 0 {
+		t.Fatalf("expected no reply, got %d bytes, %v", n, err)
+	}
+
+	// a client that doesn't read exceeds the soft limit
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := io.WriteString(c, "get 900000\r\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-closed:
+		if err != ErrOutputLimit {
+			t.Fatalf("expected %v, got %v", ErrOutputLimit, err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,63 @@
+package redcon
+
+import "sync"
+
+// ReplyPromise is the reply to a command that is written later, possibly
+// from another goroutine, such as the reply to a blocking command like BLPOP.
+// The handler creates the promise and returns without writing a reply. The
+// server keeps handling the commands of the connection, and holds their
+// replies until the promise is fulfilled, so the replies are still sent in
+// the order of the commands.
+//
+//   case "blpop":
+//       p := redcon.NewReplyPromise(conn)
+//       go func() {
+//           key, val := waitForPush(ctx, keys)
+//           p.Fulfill(func(w *redcon.Writer) {
+//               w.WriteArray(2)
+//               w.WriteBulkString(key)
+//               w.WriteBulk(val)
+//           })
+//       }()
+//
+// All replies that follow a promise are buffered until the promise is
+// fulfilled, so a promise should be fulfilled promptly, for example with
+// a null reply when a timeout expires.
+type ReplyPromise struct {
+	c    *conn
+	slot *replySlot
+	once sync.Once
+}
+
+// NewReplyPromise returns a promise for the reply to the command that is
+// being handled. The handler must not write a reply to the connection after
+// calling it. The connection must be the one that was passed to the handler
+// by the server. NewReplyPromise panics for other connections, such as a
+// detached connection or a Conn that wraps the one of the server, because
+// their replies are not ordered by the server.
+func NewReplyPromise(c Conn) *ReplyPromise {
+	switch c := c.(type) {
+	case *slotConn:
+		if c.promise != nil {
+			return c.promise()
+		}
+		c.slot.promised = true
+		return &ReplyPromise{c: c.conn, slot: c.slot}
+	case *conn:
+		slot := c.newSlot()
+		slot.promised = true
+		return &ReplyPromise{c: c, slot: slot}
+	}
+	panic("redcon: promise requires a server connection")
+}
+
+// Fulfill writes the reply using fn, and sends it to the client once the
+// replies before it have been sent. It's safe to call from any goroutine.
+// Only the first call has effect.
+func (p *ReplyPromise) Fulfill(fn func(w *Writer)) {
+	p.once.Do(func() {
+		fn(p.slot.wr)
+		p.c.complete(p.slot)
+		p.c.wr.Flush()
+	})
+}
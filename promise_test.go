@@ -0,0 +1,106 @@
+package redcon
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplyPromise(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		var promise *ReplyPromise
+		s := NewServer("", func(conn Conn, cmd Command) {
+			switch string(cmd.Args[0]) {
+			case "block":
+				promise = NewReplyPromise(conn)
+			case "fulfill":
+				p := promise
+				go p.Fulfill(func(w *Writer) {
+					w.WriteString("done")
+				})
+				conn.WriteString("OK")
+			default:
+				conn.WriteString("PONG")
+			}
+		}, nil, nil)
+		s.SetWorkers(workers)
+		c, err := net.Dial("tcp", testServe(t, s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := c.Write([]byte("ping\r\nblock\r\nping\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if resp := testDo(t, c, ""); resp != "+PONG\r\n" {
+			t.Fatalf("expected %q, got %q", "+PONG\r\n", resp)
+		}
+		// the reply to the second ping waits for the promise
+		c.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+		if n, err := c.Read(make([]byte, 64)); n != 0 || err == nil {
+			t.Fatalf("expected a timeout, got %d bytes", n)
+		}
+		exp := "+done\r\n+PONG\r\n+OK\r\n"
+		resp := testDo(t, c, "fulfill\r\n")
+		for len(resp) < len(exp) {
+			resp += testDo(t, c, "")
+		}
+		if resp != exp {
+			t.Fatalf("expected %q, got %q", exp, resp)
+		}
+		if resp := testDo(t, c, "ping\r\n"); resp != "+PONG\r\n" {
+			t.Fatalf("expected %q, got %q", "+PONG\r\n", resp)
+		}
+	}
+}
+
+func TestQuitAfterPromise(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		s := NewServer("", func(conn Conn, cmd Command) {
+			switch string(cmd.Args[0]) {
+			case "block":
+				p := NewReplyPromise(conn)
+				go func() {
+					time.Sleep(time.Millisecond * 50)
+					p.Fulfill(func(w *Writer) { w.WriteString("done") })
+				}()
+			case "close":
+				// the reply of a worker is sent before the close
+				conn.WriteString("OK")
+				conn.Close()
+			}
+		}, nil, nil)
+		s.SetBuiltins(true)
+		s.SetWorkers(workers)
+		c, err := net.Dial("tcp", testServe(t, s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		cmds := "ping\r\nblock\r\nquit\r\nping\r\n"
+		if workers > 0 {
+			// the commands after it may already be running
+			cmds = "ping\r\nblock\r\nclose\r\n"
+		}
+		if _, err := c.Write([]byte(cmds)); err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(time.Second * 5))
+		exp := "+PONG\r\n+done\r\n+OK\r\n"
+		resp, err := io.ReadAll(c)
+		if err != nil || string(resp) != exp {
+			t.Fatalf("expected %q, got %q, %v", exp, resp, err)
+		}
+	}
+}
+
+func TestReplyPromiseForeignConn(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	// a Conn that wraps the one of the server is not managed by it
+	NewReplyPromise(struct{ Conn }{})
+}
@@ -0,0 +1,36 @@
+package redcon
+
+import "github.com/tidwall/redcon/resp"
+
+// WritePush writes an out-of-band push message, such as a pub/sub message or
+// a client-side caching invalidation, see Conn.WritePush.
+func (c *conn) WritePush(kind string, args ...interface{}) {
+	proto := c.wr.Protocol()
+	b := resp.AppendPush(nil, proto, len(args)+1)
+	b = resp.AppendBulkString(b, kind)
+	for _, arg := range args {
+		b = resp.AppendAnyProto(b, proto, arg)
+	}
+	c.dmu.Lock()
+	if c.dispatching {
+		// A handler may be writing a reply that spans multiple calls, so
+		// the message is held until the reply is complete.
+		c.pushes = append(c.pushes, b...)
+		c.dmu.Unlock()
+		return
+	}
+	c.wr.WriteRaw(b)
+	c.dmu.Unlock()
+	c.wr.Flush()
+}
+
+// writePushes writes the push messages that were held while a command was
+// dispatched, and returns true when there were any. The caller must hold dmu.
+func (c *conn) writePushes() bool {
+	if len(c.pushes) == 0 {
+		return false
+	}
+	c.wr.WriteRaw(c.pushes)
+	c.pushes = nil
+	return true
+}
@@ -0,0 +1,55 @@
+package redcon
+
+import (
+	"net"
+	"testing"
+)
+
+func TestWritePush(t *testing.T) {
+	conns := make(chan Conn, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "resp3":
+			conn.SetProtocol(3)
+			conn.WriteString("OK")
+			conns <- conn
+		case "reply":
+			// a push from another goroutine must not split the reply
+			conn.WriteArray(2)
+			conn.WriteBulkString("a")
+			done := make(chan bool)
+			go func() {
+				conn.WritePush("message", "chan", "during")
+				done <- true
+			}()
+			<-done
+			conn.WriteBulkString("b")
+		}
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "resp3\r\n"); resp != "+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "+OK\r\n", resp)
+	}
+	conn := <-conns
+
+	// an idle connection receives the push immediately
+	conn.WritePush("invalidate", []string{"key"})
+	exp := ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nkey\r\n"
+	if resp := testDo(t, c, ""); resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+
+	exp = "*2\r\n$1\r\na\r\n$1\r\nb\r\n" +
+		">3\r\n$7\r\nmessage\r\n$4\r\nchan\r\n$6\r\nduring\r\n"
+	var resp string
+	for resp = testDo(t, c, "reply\r\n"); len(resp) < len(exp); {
+		resp += testDo(t, c, "")
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
@@ -0,0 +1,175 @@
+package redcon
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows up to rate events per second,
+// with bursts of up to burst events. It is not safe for concurrent use.
+type rateLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether an event may happen at now, taking a token from the
+// bucket when it does.
+func (rl *rateLimiter) allow(now time.Time, rate float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+	if rl.last.IsZero() {
+		rl.tokens = float64(burst)
+	} else if elapsed := now.Sub(rl.last); elapsed > 0 {
+		rl.tokens += elapsed.Seconds() * rate
+	}
+	if rl.tokens > float64(burst) {
+		rl.tokens = float64(burst)
+	}
+	rl.last = now
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
+
+// SetAcceptRateLimit limits the number of connections that each listener
+// accepts to rate connections per second, allowing bursts of up to burst
+// connections. This protects the server from a storm of reconnecting clients,
+// such as after a network blip.
+//
+// Connections that exceed the limit are rejected. When msg is not empty it's
+// sent to the client as an error reply before the connection is closed,
+// otherwise the connection is reset. A rate of zero disables the limit.
+func (s *Server) SetAcceptRateLimit(rate float64, burst int, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceptRate = rate
+	s.acceptBurst = burst
+	s.acceptRateErr = msg
+}
+
+// acceptLimit returns the accept rate limit settings.
+func (s *Server) acceptLimit() (rate float64, burst int, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acceptRate, s.acceptBurst, s.acceptRateErr
+}
+
+// rejectConn closes a connection that was refused before it was handed to
+// the server. The reply is written first, if any, otherwise the connection
+// is reset.
+func rejectConn(conn net.Conn, reply []byte) {
+	if len(reply) > 0 {
+		// do not let a slow client stall the accept loop.
+		conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 100))
+		conn.Write(reply)
+	} else if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// RateLimitHandler returns a Handler that limits each connection to rate
+// commands per second, allowing bursts of up to burst commands. Commands that
+// exceed the limit are not passed to handler, and the client is sent the
+// error "BUSY rate limit exceeded". A rate of zero disables the limit.
+//
+//   s := redcon.NewServerHandler(addr,
+//       redcon.RateLimitHandler(mux, 1000, 100), nil, nil)
+//
+// Connections that were not created by this package are not limited.
+func RateLimitHandler(handler Handler, rate float64, burst int) Handler {
+	if rate <= 0 {
+		return handler
+	}
+	return &rateLimitHandler{handler: handler, rate: rate, burst: burst}
+}
+
+// IPRateLimitHandler is like RateLimitHandler, but the limit is shared by
+// all connections from the same IP address, so a client can't get around it
+// by opening more connections. Connections without an IP address, such as
+// Unix socket connections, share a single limit.
+func IPRateLimitHandler(handler Handler, rate float64, burst int) Handler {
+	if rate <= 0 {
+		return handler
+	}
+	return &rateLimitHandler{handler: handler, rate: rate, burst: burst,
+		ips: make(map[string]*rateLimiter)}
+}
+
+// rateLimitHandler is the handler of RateLimitHandler and IPRateLimitHandler.
+// The limiter of a connection is stored with the values of the connection,
+// and the limiters of IP addresses are stored in ips.
+type rateLimitHandler struct {
+	handler Handler
+	rate    float64
+	burst   int
+	mu      sync.Mutex
+	ips     map[string]*rateLimiter // nil when limiting each connection
+	swept   time.Time
+}
+
+func (h *rateLimitHandler) ServeRESP(conn Conn, cmd Command) {
+	c := baseConn(conn)
+	if c == nil {
+		h.handler.ServeRESP(conn, cmd)
+		return
+	}
+	if !h.allow(c, time.Now()) {
+		conn.WriteError("BUSY rate limit exceeded")
+		return
+	}
+	h.handler.ServeRESP(conn, cmd)
+}
+
+// allow reports whether the connection may run a command at now.
+func (h *rateLimitHandler) allow(c *conn, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var rl *rateLimiter
+	if h.ips == nil {
+		c.vmu.Lock()
+		rl, _ = c.vals[h].(*rateLimiter)
+		if rl == nil {
+			rl = &rateLimiter{}
+			if c.vals == nil {
+				c.vals = make(map[interface{}]interface{})
+			}
+			c.vals[h] = rl
+		}
+		c.vmu.Unlock()
+	} else {
+		h.sweep(now)
+		ip := c.addr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		rl = h.ips[ip]
+		if rl == nil {
+			rl = &rateLimiter{}
+			h.ips[ip] = rl
+		}
+	}
+	return rl.allow(now, h.rate, h.burst)
+}
+
+// sweep removes the limiters of the IP addresses whose buckets have filled
+// up again, which are the same as new limiters, at most once a minute.
+func (h *rateLimitHandler) sweep(now time.Time) {
+	if now.Sub(h.swept) < time.Minute {
+		return
+	}
+	h.swept = now
+	burst := float64(h.burst)
+	if burst < 1 {
+		burst = 1
+	}
+	for ip, rl := range h.ips {
+		if rl.tokens+now.Sub(rl.last).Seconds()*h.rate >= burst {
+			delete(h.ips, ip)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package redcon
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var rl rateLimiter
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !rl.allow(now, 10, 3) {
+			t.Fatalf("expected burst event %d to be allowed", i)
+		}
+	}
+	if rl.allow(now, 10, 3) {
+		t.Fatal("expected event to be limited")
+	}
+	now = now.Add(time.Millisecond * 100)
+	if !rl.allow(now, 10, 3) {
+		t.Fatal("expected refilled event to be allowed")
+	}
+	if rl.allow(now, 10, 3) {
+		t.Fatal("expected event to be limited")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !rl.allow(now, 10, 3) {
+			t.Fatalf("expected burst event %d to be allowed", i)
+		}
+	}
+	if rl.allow(now, 10, 3) {
+		t.Fatal("expected bucket to be capped at burst")
+	}
+}
+
+func TestAcceptRateLimit(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetAcceptRateLimit(0.001, 2, "ERR too many connections")
+	addr := testServe(t, s)
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if resp := testDo(t, c, "ping\r\n"); resp != "+OK\r\n" {
+			t.Fatalf("expected %q, got %q", "+OK\r\n", resp)
+		}
+	}
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "-ERR too many connections\r\n" {
+		t.Fatalf("expected %q, got %q", "-ERR too many connections\r\n", line)
+	}
+	if n := s.Stats().RejectedConnections; n != 1 {
+		t.Fatalf("expected %d, got %d", 1, n)
+	}
+
+	// without a message the connection is reset
+	s.SetAcceptRateLimit(0.001, 2, "")
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	ok := HandlerFunc(func(conn Conn, cmd Command) { conn.WriteString("OK") })
+	for _, perIP := range []bool{false, true} {
+		h := RateLimitHandler(ok, 0.001, 2)
+		if perIP {
+			h = IPRateLimitHandler(ok, 0.001, 2)
+		}
+		addr := testServe(t, NewServerHandler("", h, nil, nil))
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		for i, exp := range []string{"+OK\r\n", "+OK\r\n",
+			"-BUSY rate limit exceeded\r\n"} {
+			if resp := testDo(t, c, "ping\r\n"); resp != exp {
+				t.Fatalf("%v %d: expected %q, got %q", perIP, i, exp, resp)
+			}
+		}
+
+		// a new connection has its own limit, unless it's limited by IP
+		c, err = net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		exp := "+OK\r\n"
+		if perIP {
+			exp = "-BUSY rate limit exceeded\r\n"
+		}
+		if resp := testDo(t, c, "ping\r\n"); resp != exp {
+			t.Fatalf("%v: expected %q, got %q", perIP, exp, resp)
+		}
+	}
+	if _, ok := RateLimitHandler(ok, 0, 2).(HandlerFunc); !ok {
+		t.Fatal("expected a zero rate to disable the limit")
+	}
+}
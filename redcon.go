@@ -1,19 +1,34 @@
 // Package redcon implements a Redis compatible server framework
+//
+// Command arguments, keys, channels, and bulk replies are binary strings,
+// which are passed through unchanged. Command names and other keywords are
+// compared by folding the ASCII letters only, and glob patterns are matched
+// byte by byte. This makes the handling of arbitrary bytes, including
+// invalid UTF-8, independent of the locale and of the Unicode case mappings.
+// Simple strings and errors can't contain CR or LF bytes. WriteString sends
+// such strings as bulk strings instead, and WriteError replaces them with
+// spaces.
 package redcon
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tidwall/btree"
-	"github.com/tidwall/match"
+	"github.com/tidwall/redcon/resp"
 )
 
 var (
@@ -23,6 +38,19 @@ var (
 	errDetached               = errors.New("detached")
 	errIncompleteCommand      = errors.New("incomplete command")
 	errTooMuchData            = errors.New("too much data")
+	errStreamClosed           = errors.New("stream closed")
+	errQueryBufferLimit       = errors.New("query buffer limit reached")
+)
+
+var (
+	// ErrClientEvicted is passed to the closed function of the server when
+	// a client is disconnected because of the client memory limit, see
+	// SetMaxMemoryClients.
+	ErrClientEvicted = errors.New("client evicted")
+	// ErrOutputLimit is passed to the closed function of the server when a
+	// client is disconnected because its output buffer broke the limits,
+	// see SetOutputBufferLimit.
+	ErrOutputLimit = errors.New("output buffer limit reached")
 )
 
 type errProtocol struct {
@@ -34,14 +62,44 @@ func (err *errProtocol) Error() string {
 }
 
 // Conn represents a client connection
+//
+// The Write* functions may be called from other goroutines while the handler
+// is running, such as from a publisher that pushes messages to subscribers.
+// Each call writes one complete frame, and frames from concurrent callers are
+// never torn. A multi-frame reply, such as an array header followed by its
+// elements, should be written with a single WriteRaw call when other
+// goroutines may write to the same connection. Writes made outside of the
+// handler are sent when the pending replies of the connection are flushed.
 type Conn interface {
 	// RemoteAddr returns the remote address of the client connection.
 	RemoteAddr() string
-	// Close closes the connection.
+	// Close flushes the replies that have been written, and closes the
+	// connection. Use CloseAfterFlush for commands like QUIT, which also
+	// waits for the replies that are not written yet, such as the replies
+	// of ReplyPromises.
 	Close() error
-	// WriteError writes an error to the client.
+	// ClosedChan returns a channel that is closed when the connection is
+	// closed, either by the server or because the client disconnected.
+	// Background goroutines that write to the connection can use it to stop
+	// promptly. A disconnect is noticed when the server next reads from the
+	// connection, which happens while the connection is waiting for commands,
+	// or while a command is dispatched when the handler has called
+	// ConnContext.
+	ClosedChan() <-chan struct{}
+	// CloseAfterFlush marks the connection to be closed once the current
+	// command returns and the pending replies have been flushed, including
+	// the replies of ReplyPromises that are fulfilled later. The remaining
+	// commands in the pipeline are not processed. Useful for commands like
+	// QUIT, or for fatal errors, where the final reply must reach the client
+	// before the connection is closed.
+	CloseAfterFlush()
+	// WriteError writes an error to the client. The message should start
+	// with an error code, such as "ERR", see WriteErr. CR and LF characters
+	// are replaced with spaces, so the reply can't corrupt the stream.
 	WriteError(msg string)
-	// WriteString writes a string to the client.
+	// WriteString writes a string to the client. A string that contains CR
+	// or LF characters is written as a bulk string, because a simple string
+	// can't hold them.
 	WriteString(str string)
 	// WriteBulk writes bulk bytes to the client.
 	WriteBulk(bulk []byte)
@@ -51,8 +109,24 @@ type Conn interface {
 	WriteInt(num int)
 	// WriteInt64 writes a 64-bit signed integer to the client.
 	WriteInt64(num int64)
-	// WriteUint64 writes a 64-bit unsigned integer to the client.
+	// WriteUint64 writes a 64-bit unsigned integer to the client. Numbers
+	// larger than math.MaxInt64 do not fit in a RESP integer, and are
+	// written as bulk strings instead.
 	WriteUint64(num uint64)
+	// WriteFloat writes a floating point number to the client, using the
+	// formatting of Redis. It's written as a bulk string for RESP2, and as a
+	// double for RESP3.
+	WriteFloat(f float64)
+	// WriteBool writes a boolean to the client. It's written as a boolean
+	// for RESP3, and as the integer 1 or 0 for RESP2.
+	WriteBool(v bool)
+	// WriteBigNumber writes an integer of arbitrary size to the client. It's
+	// written as a big number for RESP3, and as a bulk string for RESP2. A
+	// nil number is written as a null.
+	WriteBigNumber(num *big.Int)
+	// WriteBigNumberString is like WriteBigNumber, where num is the decimal
+	// representation of the integer.
+	WriteBigNumberString(num string)
 	// WriteArray writes an array header. You must then write additional
 	// sub-responses to the client to complete the response.
 	// For example to write two strings:
@@ -61,8 +135,71 @@ type Conn interface {
 	//   c.WriteBulk("item 1")
 	//   c.WriteBulk("item 2")
 	WriteArray(count int)
-	// WriteNull writes a null to the client
+	// WriteMap writes a map header with count key/value pairs. You must
+	// then write the keys and values. For RESP2 clients an array header with
+	// count*2 elements is written instead.
+	WriteMap(count int)
+	// WriteMapStringString writes a map of strings, such as the reply of
+	// CONFIG GET. The keys are written in sorted order.
+	WriteMapStringString(m map[string]string)
+	// WriteSet writes a set header with count elements. You must then write
+	// the elements. For RESP2 clients an array header is written instead.
+	WriteSet(count int)
+	// WriteAttribute writes attributes, which is metadata about the reply
+	// that follows, such as the popularity of keys. The key/value pairs are
+	// written by fn, and pairs is the number of pairs. Attributes only exist
+	// in RESP3, so nothing is written for RESP2 clients and fn is not called.
+	//
+	//   conn.WriteAttribute(1, func(w *redcon.Writer) {
+	//       w.WriteString("ttl")
+	//       w.WriteInt(3600)
+	//   })
+	//   conn.WriteBulk(value)
+	WriteAttribute(pairs int, fn func(w *Writer))
+	// BeginArray starts an array whose number of elements is counted while
+	// the elements are written, see ArrayWriter. The array is written when
+	// End is called on the returned ArrayWriter.
+	BeginArray() *ArrayWriter
+	// WriteNull writes a null to the client. It's written as a null bulk
+	// string for RESP2, and as the null type for RESP3.
 	WriteNull()
+	// WriteNullBulk writes a null bulk string to the client, regardless of
+	// the protocol.
+	WriteNullBulk()
+	// WriteNullArray writes a null array to the client, which is the reply
+	// of a blocking command that timed out. It's written as the null type
+	// for RESP3.
+	WriteNullArray()
+	// BeginStreamedArray starts an array that is streamed to RESP3 clients,
+	// which allows for replies of unlimited size. The elements are written
+	// directly, and End writes the terminator. For RESP2 clients the
+	// elements are buffered, like BeginArray does.
+	BeginStreamedArray() *ArrayWriter
+	// BeginStreamedMap is like BeginStreamedArray for a map, where the keys
+	// and values are written as elements.
+	BeginStreamedMap() *ArrayWriter
+	// BeginStreamedBulk starts a bulk string whose length is not known up
+	// front, see StreamedBulk. The string is complete when Close is called
+	// on the returned StreamedBulk.
+	BeginStreamedBulk() *StreamedBulk
+	// WriteBulkFrom writes a bulk string of n bytes that are read from r.
+	// The pending replies and the bulk header are flushed first, and then the
+	// bytes are copied to the client without buffering the whole value. When
+	// an error is returned, the reply is incomplete and the connection
+	// should be closed.
+	WriteBulkFrom(r io.Reader, n int64) error
+	// WriteBulkOrNull writes bulk bytes to the client when ok is true,
+	// otherwise a null.
+	WriteBulkOrNull(bulk []byte, ok bool)
+	// WriteBulkArray writes an array of bulk bytes to the client, such as
+	// the reply of MGET. Nil elements are written as nulls.
+	//
+	//   vals := make([][]byte, len(keys))
+	//   for i, key := range keys {
+	//       vals[i] = store[key] // nil when missing
+	//   }
+	//   conn.WriteBulkArray(vals)
+	WriteBulkArray(bulks [][]byte)
 	// WriteRaw writes raw data to the client.
 	WriteRaw(data []byte)
 	// WriteAny writes any type to the client.
@@ -72,12 +209,35 @@ type Conn interface {
 	//   numbers         -> bulk-string
 	//   []byte          -> bulk-string
 	//   bool            -> bulk-string ("0" or "1")
-	//   slice           -> array
+	//   slice, array    -> array
 	//   map             -> array with key/value pairs
+	//   pointer         -> the value it points to, or null when nil
 	//   SimpleString    -> string
 	//   SimpleInt       -> integer
 	//   everything-else -> bulk-string representation using fmt.Sprint()
+	// For RESP3 clients, nil, bools, floats, and maps are written as the
+	// RESP3 null, boolean, double, and map types.
 	WriteAny(any interface{})
+	// WritePush writes an out-of-band push message, which is an array of
+	// the kind followed by the arguments, as encoded by WriteAny. It's
+	// written as the push type for RESP3, and as an array for RESP2, like
+	// the messages of pub/sub. The message is flushed immediately, unless
+	// a command is being handled, in which case it's written after the reply
+	// to the command. It's safe to call from any goroutine.
+	//
+	//   conn.WritePush("invalidate", []string{"key1", "key2"})
+	WritePush(kind string, args ...interface{})
+	// Flush writes any pending replies to the client. Replies are flushed
+	// automatically after the handler returns, so Flush is only needed for
+	// streaming parts of a reply while the handler is still running.
+	//
+	//   conn.WriteArray(len(keys))
+	//   conn.Flush()
+	//   for _, key := range keys {
+	//       conn.WriteBulk(compute(key))
+	//       conn.Flush()
+	//   }
+	Flush() error
 	// Context returns a user-defined context
 	Context() interface{}
 	// SetContext sets a user-defined context
@@ -109,11 +269,42 @@ type Conn interface {
 	// PeekPipeline returns all commands in current pipeline, if any.
 	// The commands remain in the pipeline.
 	PeekPipeline() []Command
-	// NetConn returns the base net.Conn connection
+	// NetConn returns the base net.Conn connection. It may be used for
+	// setting socket options, or for inspecting the TLS connection state
+	// through a *tls.Conn type assertion. Reading from or writing to the
+	// base connection while it's managed by the server will corrupt the
+	// stream, use Detach for taking control of the connection instead.
 	NetConn() net.Conn
+	// ID returns the unique identifier of the connection. Identifiers are
+	// assigned in increasing order as connections are accepted.
+	ID() uint64
+	// SetName sets the name of the connection, such as with the CLIENT
+	// SETNAME command. The name is included in the ClientList output, which
+	// helps operators to identify the application that owns a connection.
+	SetName(name string)
+	// Name returns the name of the connection, or an empty string when no
+	// name has been set.
+	Name() string
+	// SetNoEvict protects the connection from being disconnected when the
+	// server-wide client memory limit is reached.
+	SetNoEvict(on bool)
+	// Protocol returns the RESP version that is used for the replies to the
+	// client, which is 2 or 3.
+	Protocol() int
+	// SetProtocol sets the RESP version that is used for the replies to the
+	// client, which must be 2 or 3. Use it for implementing the HELLO
+	// command, see ParseHello. The default is 2.
+	SetProtocol(proto int)
+	// Info returns a snapshot of the connection, which includes the same
+	// details and statistics as ClientList.
+	Info() ClientInfo
 }
 
 // NewServer returns a new Redcon server configured on "tcp" network net.
+//
+// The handler is called once for each command. The replies to pipelined
+// commands are buffered, and flushed to the client together once all of the
+// commands that were read have been handled, see SetMaxBatchSize.
 func NewServer(addr string,
 	handler func(conn Conn, cmd Command),
 	accept func(conn Conn) bool,
@@ -122,6 +313,19 @@ func NewServer(addr string,
 	return NewServerNetwork("tcp", addr, handler, accept, closed)
 }
 
+// NewServerHandler is like NewServer, but takes a Handler, such as a
+// ServeMux, instead of a handler function.
+func NewServerHandler(addr string,
+	handler Handler,
+	accept func(conn Conn) bool,
+	closed func(conn Conn, err error),
+) *Server {
+	if handler == nil {
+		panic("handler is nil")
+	}
+	return NewServer(addr, handler.ServeRESP, accept, closed)
+}
+
 // NewServerTLS returns a new Redcon TLS server configured on "tcp" network net.
 func NewServerTLS(addr string,
 	handler func(conn Conn, cmd Command),
@@ -292,7 +496,9 @@ func (s *Server) ListenServeAndSignal(signal chan error) error {
 		}
 		return err
 	}
+	s.mu.Lock()
 	s.ln = ln
+	s.mu.Unlock()
 	if signal != nil {
 		signal <- nil
 	}
@@ -301,9 +507,11 @@ func (s *Server) ListenServeAndSignal(signal chan error) error {
 
 // Serve serves incoming connections with the given net.Listener.
 func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
 	s.ln = ln
 	s.net = ln.Addr().Network()
 	s.laddr = ln.Addr().String()
+	s.mu.Unlock()
 	return serve(s)
 }
 
@@ -317,7 +525,9 @@ func (s *TLSServer) ListenServeAndSignal(signal chan error) error {
 		}
 		return err
 	}
+	s.mu.Lock()
 	s.ln = ln
+	s.mu.Unlock()
 	if signal != nil {
 		signal <- nil
 	}
@@ -336,6 +546,7 @@ func serve(s *Server) error {
 			s.conns = nil
 		}()
 	}()
+	var limiter rateLimiter
 	for {
 		lnconn, err := s.ln.Accept()
 		if err != nil {
@@ -351,16 +562,50 @@ func serve(s *Server) error {
 			continue
 		}
 		c := &conn{
-			conn: lnconn,
-			addr: lnconn.RemoteAddr().String(),
-			wr:   NewWriter(lnconn),
-			rd:   NewReader(lnconn),
+			id:     atomic.AddUint64(&s.nextID, 1),
+			server: s,
+			conn:   lnconn,
+			addr:   lnconn.RemoteAddr().String(),
+			wr:     NewWriter(lnconn),
+			rd:     NewReader(lnconn),
+		}
+		if !s.admit(lnconn, &limiter, func(msg string) []byte {
+			return AppendError(nil, msg)
+		}) {
+			continue
 		}
 		s.mu.Lock()
+		if s.maxClients > 0 && len(s.conns) >= s.maxClients {
+			s.mu.Unlock()
+			atomic.AddUint64(&s.stats.rejectedConns, 1)
+			rejectConn(lnconn,
+				AppendError(nil, "ERR max number of clients reached"))
+			continue
+		}
 		c.idleClose = s.idleClose
+		c.largeReply = s.largeReply
+		c.outputLimit = s.outputLimit
+		c.outputWM = s.outputWM
+		c.pipelineWM = s.pipelineWM
+		c.rd.maxMultiBulk = s.maxMultiBulk
+		c.rd.maxBuffer = s.maxQueryBuffer
+		c.rd.maxBulk = s.protoMaxBulk
+		c.rd.strict = s.strictRESP
+		c.rd.largeArg = s.largeArg
+		c.rd.noCopy = s.noCopy
+		c.rd.maxBatch = s.maxBatch
+		c.workers = s.workers
+		c.handler = s.handler
+		c.authSecret = s.authSecret
+		c.authPass = s.authPass
+		c.helloConfig = s.hello
+		c.builtins = s.builtins
+		c.normalize = s.normalize
+		c.auditArgs = s.auditArgs
 		s.conns[c] = true
 		s.mu.Unlock()
 		if s.accept != nil && !s.accept(c) {
+			atomic.AddUint64(&s.stats.rejectedConns, 1)
 			s.mu.Lock()
 			delete(s.conns, c)
 			s.mu.Unlock()
@@ -371,6 +616,41 @@ func serve(s *Server) error {
 	}
 }
 
+// admit applies the accept rate limit, the access list, and the drain mode
+// to a new connection. A refused connection is sent the error message that
+// is formatted by reply, if any, and closed. Returns false when the
+// connection was refused.
+func (s *Server) admit(lnconn net.Conn, limiter *rateLimiter,
+	reply func(msg string) []byte,
+) bool {
+	atomic.AddUint64(&s.stats.totalConns, 1)
+	refuse := func(msg string, reset bool) bool {
+		atomic.AddUint64(&s.stats.rejectedConns, 1)
+		if msg != "" {
+			rejectConn(lnconn, reply(msg))
+		} else if reset {
+			rejectConn(lnconn, nil)
+		} else {
+			lnconn.Close()
+		}
+		return false
+	}
+	rate, burst, msg := s.acceptLimit()
+	if rate > 0 && !limiter.allow(time.Now(), rate, burst) {
+		return refuse(msg, true)
+	}
+	if ok, msg := s.allowed(lnconn); !ok {
+		return refuse(msg, false)
+	}
+	if atomic.LoadInt32(&s.draining) != 0 {
+		s.mu.Lock()
+		msg := s.drainErr
+		s.mu.Unlock()
+		return refuse(msg, false)
+	}
+	return true
+}
+
 // handle manages the server connection.
 func handle(s *Server, c *conn) {
 	var err error
@@ -378,39 +658,68 @@ func handle(s *Server, c *conn) {
 		if err != errDetached {
 			// do not close the connection when a detach is detected.
 			c.conn.Close()
+			c.disconnected()
 		}
-		func() {
-			// remove the conn from the server
-			s.mu.Lock()
-			defer s.mu.Unlock()
-			delete(s.conns, c)
-			if s.closed != nil {
-				if err == io.EOF {
-					err = nil
-				}
-				s.closed(c, err)
+		atomic.AddInt64(&s.clientMem, -atomic.SwapInt64(&c.mem, 0))
+		if atomic.LoadInt32(&c.evicted) == 1 {
+			err = ErrClientEvicted
+		} else if atomic.LoadInt32(&c.overLimit) == 1 {
+			err = ErrOutputLimit
+		}
+		// remove the conn from the server, and release it under the same
+		// lock, so a Restore can't add it back before it has been removed.
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.conns, c)
+		if s.closed != nil {
+			if err == io.EOF {
+				err = nil
 			}
-		}()
+			s.closed(c, err)
+		}
+		c.release()
 	}()
 
 	err = func() error {
 		// read commands and feed back to the client
 		for {
-			// read pipeline commands
-			if c.idleClose != 0 {
-				c.conn.SetReadDeadline(time.Now().Add(c.idleClose))
-			}
-			cmds, err := c.rd.readCommands(nil)
-			if err != nil {
-				if err, ok := err.(*errProtocol); ok {
-					// All protocol errors should attempt a response to
-					// the client. Ignore write errors.
-					c.wr.WriteError("ERR " + err.Error())
-					c.wr.Flush()
+			if len(c.cmds) == 0 {
+				// read pipeline commands
+				if c.idleClose != 0 {
+					c.conn.SetReadDeadline(time.Now().Add(c.idleClose))
 				}
-				return err
+				if !c.setReading(true) {
+					// client was detached by another goroutine
+					return errDetached
+				}
+				cmds, err := c.rd.readCommands(nil)
+				if !c.setReading(false) {
+					// client was detached by another goroutine while
+					// reading. Any commands that were read are handed over
+					// by release.
+					c.cmds = cmds
+					return errDetached
+				}
+				c.trackMemory(s)
+				atomic.StoreInt64(&c.lastIO, time.Now().UnixNano())
+				if err != nil {
+					if err, ok := err.(*errProtocol); ok {
+						// All protocol errors should attempt a response to
+						// the client. Ignore write errors.
+						c.wr.WriteError("ERR " + err.Error())
+						c.wr.Flush()
+					}
+					return err
+				}
+				c.cmds = cmds
+			}
+			c.pipelineWM.check(c, &c.pipelineHigh, len(c.cmds))
+			c.setDispatching(true)
+			if c.workers != nil {
+				cmds := c.cmds
+				c.cmds = nil
+				c.dispatchWorkers(s, cmds)
 			}
-			c.cmds = cmds
 			for len(c.cmds) > 0 {
 				cmd := c.cmds[0]
 				if len(c.cmds) == 1 {
@@ -418,42 +727,311 @@ func handle(s *Server, c *conn) {
 				} else {
 					c.cmds = c.cmds[1:]
 				}
-				s.handler(c, cmd)
+				c.dispatch(s, cmd)
+				if c.isDetached() || c.isClosing() {
+					break
+				}
+				if c.outputLimitExceeded() {
+					// discard the replies and close the connection
+					return ErrOutputLimit
+				}
+				c.pipelineWM.check(c, &c.pipelineHigh, len(c.cmds))
 			}
-			if c.detached {
+			c.setDispatching(false)
+			if c.isDetached() {
 				// client has been detached
 				return errDetached
 			}
-			if c.closed {
+			if c.isClosed() {
 				return nil
 			}
-			if err := c.wr.Flush(); err != nil {
+			c.trackMemory(s)
+			if c.isClosing() {
+				// the final replies may wait for a promise
+				c.waitPending()
+			}
+			err := c.flush()
+			atomic.StoreInt64(&c.outputSize, int64(c.wr.buffered()))
+			atomic.StoreInt64(&c.lastIO, time.Now().UnixNano())
+			if err != nil {
 				return err
 			}
+			if c.isClosing() {
+				return nil
+			}
+			c.outputWM.check(c, &c.outputHigh, c.wr.buffered())
 		}
 	}()
 }
 
+// setReading marks that the server loop is reading from the connection.
+// Returns false when the connection has been detached.
+func (c *conn) setReading(reading bool) bool {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	c.reading = reading
+	return !c.isDetached()
+}
+
+// setDispatching marks that the server loop is dispatching commands to the
+// handler.
+func (c *conn) setDispatching(dispatching bool) {
+	c.dmu.Lock()
+	c.dispatching = dispatching
+	watch := c.watch
+	c.watch = nil
+	pushed := c.writePushes()
+	detached := c.isDetached()
+	c.dmu.Unlock()
+	if watch != nil {
+		c.stopWatch(watch)
+	}
+	if pushed && detached {
+		// the server loop will not flush the held push messages
+		c.wr.Flush()
+	}
+}
+
+// release marks that the server loop is no longer managing the connection,
+// and hands the connection over to the detached connection, if any, along
+// with the commands that were read but not dispatched.
+func (c *conn) release() {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	c.released = true
+	if dc := c.dconn; dc != nil {
+		dc.cmds = append(dc.cmds, c.cmds...)
+		c.cmds = nil
+		close(dc.ready)
+	}
+}
+
+func (c *conn) isDetached() bool {
+	return atomic.LoadInt32(&c.detached) != 0
+}
+
+func (c *conn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+func (c *conn) isClosing() bool {
+	return atomic.LoadInt32(&c.closeAfterFlush) != 0
+}
+
+// waitPause blocks until the server is no longer paused for the command.
+// Pending replies are flushed before blocking.
+func (s *Server) waitPause(c *conn, cmd Command) {
+	var flushed bool
+	for {
+		s.mu.Lock()
+		wait := time.Until(s.pauseEnd)
+		if wait <= 0 {
+			atomic.StoreInt32(&s.paused, 0)
+		}
+		mode := s.pauseMode
+		unpaused := s.unpaused
+		s.mu.Unlock()
+		if wait <= 0 || (mode == PauseWrite &&
+			s.WriteCommand != nil && !s.WriteCommand(cmd)) {
+			return
+		}
+		if !flushed {
+			c.wr.Flush()
+			flushed = true
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-unpaused:
+			t.Stop()
+		}
+	}
+}
+
+// dispatch passes the command to the server handler and reports replies
+// that exceed the large reply threshold.
+func (c *conn) dispatch(s *Server, cmd Command) {
+	if c.hasPending() {
+		// the reply must wait for the replies before it
+		slot := c.newSlot()
+		if c.prepare(s, cmd, slot.wr) {
+			c.handleSlot(s, cmd, slot)
+		} else {
+			c.complete(slot)
+		}
+	} else {
+		if !c.prepare(s, cmd, c.wr) {
+			return
+		}
+		mark := c.wr.written()
+		c.handler(c, cmd)
+		if c.largeReply > 0 && s.LargeReply != nil && !c.isDetached() {
+			if size := int(c.wr.written() - mark); size >= c.largeReply {
+				s.LargeReply(c, cmd, size)
+			}
+		}
+	}
+	c.dmu.Lock()
+	c.writePushes()
+	c.dmu.Unlock()
+	if !c.isDetached() {
+		size := c.wr.buffered()
+		atomic.StoreInt64(&c.outputSize, int64(size))
+		c.outputWM.check(c, &c.outputHigh, size)
+	}
+}
+
+// prepare waits for a pause, updates the statistics, and handles the
+// commands that are answered by the server itself, such as AUTH, HELLO, and
+// the built-in commands, whose replies are written to w. Returns false when
+// the command must not be passed to the handler.
+func (c *conn) prepare(s *Server, cmd Command, w *Writer) bool {
+	if atomic.LoadInt32(&s.paused) != 0 {
+		s.waitPause(c, cmd)
+	}
+	atomic.StoreInt64(&c.lastCmd, time.Now().UnixNano())
+	atomic.AddUint64(&c.numCmds, 1)
+	atomic.AddUint64(&s.stats.totalCmds, 1)
+	if s.Audit != nil {
+		c.audit(s, cmd)
+	}
+	if c.normalize && len(cmd.Args) > 0 {
+		asciiUpper(cmd.Args[0])
+	}
+	if (c.authSecret != nil || c.authPass != nil) && !c.authorize(w, cmd) {
+		return false
+	}
+	if c.helloConfig != nil && len(cmd.Args) > 0 &&
+		asciiEqualFold(string(cmd.Args[0]), "hello") {
+		c.hello(w, cmd)
+		return false
+	}
+	if c.builtins && c.builtin(s, w, cmd) {
+		return false
+	}
+	return true
+}
+
+// trackMemory updates the server-wide client memory usage with the size of
+// the connection buffers, evicting clients when the limit is exceeded.
+func (c *conn) trackMemory(s *Server) {
+	mem := int64(cap(c.rd.buf) + c.wr.capacity())
+	delta := mem - atomic.SwapInt64(&c.mem, mem)
+	if delta == 0 {
+		return
+	}
+	total := atomic.AddInt64(&s.clientMem, delta)
+	limit := atomic.LoadInt64(&s.maxClientMem)
+	if limit > 0 && total > limit {
+		s.evictClients(limit)
+	}
+}
+
+// evictClients disconnects the clients using the most buffer memory until
+// the server-wide client memory usage is within limit. Clients that have
+// called SetNoEvict are never evicted.
+func (s *Server) evictClients(limit int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var conns []*conn
+	for c := range s.conns {
+		if atomic.LoadInt32(&c.noEvict) == 0 &&
+			atomic.LoadInt32(&c.evicted) == 0 {
+			conns = append(conns, c)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return atomic.LoadInt64(&conns[i].mem) >
+			atomic.LoadInt64(&conns[j].mem)
+	})
+	total := atomic.LoadInt64(&s.clientMem)
+	for _, c := range conns {
+		if total <= limit {
+			break
+		}
+		atomic.StoreInt32(&c.evicted, 1)
+		atomic.AddUint64(&s.stats.evictedClients, 1)
+		c.conn.Close()
+		total -= atomic.LoadInt64(&c.mem)
+	}
+}
+
 // conn represents a client connection
 type conn struct {
-	conn      net.Conn
-	wr        *Writer
-	rd        *Reader
-	addr      string
-	ctx       interface{}
-	detached  bool
-	closed    bool
-	cmds      []Command
-	idleClose time.Duration
+	mem             int64 // must be first for 64-bit atomic alignment
+	lastCmd         int64
+	lastIO          int64
+	outputSize      int64
+	id              uint64
+	numCmds         uint64
+	evicted         int32
+	overLimit       int32
+	noEvict         int32
+	detached        int32
+	closed          int32
+	closeAfterFlush int32
+	server          *Server // nil when the conn cannot be restored
+	conn            net.Conn
+	wr              *Writer
+	rd              *Reader
+	addr            string
+	ctx             interface{}
+	cmds            []Command
+	idleClose       time.Duration
+	largeReply      int
+	outputLimit     outputLimit
+	handler         func(conn Conn, cmd Command)
+	builtins        bool
+	memcache        bool // a memcached connection, see ServeMemcache
+	normalize       bool
+	auditArgs       bool
+	workers         chan struct{} // limits the commands on workers
+	pmu             sync.Mutex    // guards pending
+	pending         []*replySlot  // replies that wait for earlier replies
+	drained         chan struct{} // closed when pending becomes empty
+
+	outputWM     *Watermark
+	outputHigh   bool
+	pipelineWM   *Watermark
+	pipelineHigh bool
+
+	dmu         sync.Mutex // guards the detach state
+	dconn       *detachedConn
+	reading     bool
+	dispatching bool
+	pushes      []byte        // push messages that are held during dispatch
+	watch       chan struct{} // closed when the client watcher stops
+	released    bool
+	gone        bool
+	closedCh    chan struct{}
+	vmu         sync.Mutex // guards ctx, vals, name, and user
+	vals        map[interface{}]interface{}
+	name        string
+	user        string // the ACL user, or empty for the default user
+	authSecret  func(user string) ([]byte, bool)
+	authPass    func(conn Conn, user, pass string) bool
+	helloConfig *HelloConfig
+	authed      bool
+	nonce       []byte
 }
 
 func (c *conn) Close() error {
 	c.wr.Flush()
-	c.closed = true
-	return c.conn.Close()
+	atomic.StoreInt32(&c.closed, 1)
+	err := c.conn.Close()
+	c.disconnected()
+	return err
+}
+func (c *conn) Context() interface{} {
+	c.vmu.Lock()
+	defer c.vmu.Unlock()
+	return c.ctx
+}
+func (c *conn) SetContext(v interface{}) {
+	c.vmu.Lock()
+	c.ctx = v
+	c.vmu.Unlock()
 }
-func (c *conn) Context() interface{}        { return c.ctx }
-func (c *conn) SetContext(v interface{})    { c.ctx = v }
 func (c *conn) SetReadBuffer(n int)         {}
 func (c *conn) WriteString(str string)      { c.wr.WriteString(str) }
 func (c *conn) WriteBulk(bulk []byte)       { c.wr.WriteBulk(bulk) }
@@ -462,11 +1040,49 @@ func (c *conn) WriteInt(num int)            { c.wr.WriteInt(num) }
 func (c *conn) WriteInt64(num int64)        { c.wr.WriteInt64(num) }
 func (c *conn) WriteUint64(num uint64)      { c.wr.WriteUint64(num) }
 func (c *conn) WriteError(msg string)       { c.wr.WriteError(msg) }
-func (c *conn) WriteArray(count int)        { c.wr.WriteArray(count) }
-func (c *conn) WriteNull()                  { c.wr.WriteNull() }
-func (c *conn) WriteRaw(data []byte)        { c.wr.WriteRaw(data) }
-func (c *conn) WriteAny(v interface{})      { c.wr.WriteAny(v) }
-func (c *conn) RemoteAddr() string          { return c.addr }
+func (c *conn) WriteFloat(f float64)        { c.wr.WriteFloat(f) }
+func (c *conn) WriteBool(v bool)            { c.wr.WriteBool(v) }
+func (c *conn) WriteBigNumber(num *big.Int) { c.wr.WriteBigNumber(num) }
+func (c *conn) WriteBigNumberString(num string) {
+	c.wr.WriteBigNumberString(num)
+}
+func (c *conn) WriteArray(count int)     { c.wr.WriteArray(count) }
+func (c *conn) BeginArray() *ArrayWriter { return c.wr.BeginArray() }
+func (c *conn) BeginStreamedArray() *ArrayWriter {
+	return c.wr.BeginStreamedArray()
+}
+func (c *conn) BeginStreamedMap() *ArrayWriter { return c.wr.BeginStreamedMap() }
+func (c *conn) BeginStreamedBulk() *StreamedBulk {
+	return c.wr.BeginStreamedBulk()
+}
+func (c *conn) WriteMap(count int) { c.wr.WriteMap(count) }
+func (c *conn) WriteSet(count int) { c.wr.WriteSet(count) }
+func (c *conn) WriteAttribute(pairs int, fn func(w *Writer)) {
+	c.wr.WriteAttribute(pairs, fn)
+}
+func (c *conn) WriteMapStringString(m map[string]string) {
+	c.wr.WriteMapStringString(m)
+}
+func (c *conn) WriteNull()      { c.wr.WriteNull() }
+func (c *conn) WriteNullBulk()  { c.wr.WriteNullBulk() }
+func (c *conn) WriteNullArray() { c.wr.WriteNullArray() }
+func (c *conn) WriteBulkFrom(r io.Reader, n int64) error {
+	return c.wr.WriteBulkFrom(r, n)
+}
+func (c *conn) WriteBulkOrNull(bulk []byte, ok bool) {
+	c.wr.WriteBulkOrNull(bulk, ok)
+}
+func (c *conn) WriteBulkArray(bulks [][]byte) { c.wr.WriteBulkArray(bulks) }
+func (c *conn) WriteRaw(data []byte)          { c.wr.WriteRaw(data) }
+func (c *conn) WriteAny(v interface{})        { c.wr.WriteAny(v) }
+func (c *conn) Flush() error                  { return c.wr.Flush() }
+func (c *conn) RemoteAddr() string            { return c.addr }
+func (c *conn) ID() uint64                    { return c.id }
+func (c *conn) Info() ClientInfo              { return c.info() }
+func (c *conn) Protocol() int                 { return int(c.wr.Protocol()) }
+func (c *conn) SetProtocol(proto int) {
+	c.wr.SetProtocol(resp.Protocol(proto))
+}
 func (c *conn) ReadPipeline() []Command {
 	cmds := c.cmds
 	c.cmds = nil
@@ -478,10 +1094,57 @@ func (c *conn) PeekPipeline() []Command {
 func (c *conn) NetConn() net.Conn {
 	return c.conn
 }
+func (c *conn) SetNoEvict(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.noEvict, v)
+}
+func (c *conn) SetName(name string) {
+	c.vmu.Lock()
+	c.name = name
+	c.vmu.Unlock()
+}
+func (c *conn) Name() string {
+	c.vmu.Lock()
+	defer c.vmu.Unlock()
+	return c.name
+}
+func (c *conn) CloseAfterFlush() {
+	atomic.StoreInt32(&c.closeAfterFlush, 1)
+}
+func (c *conn) ClosedChan() <-chan struct{} {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	if c.closedCh == nil {
+		c.closedCh = make(chan struct{})
+		if c.gone {
+			close(c.closedCh)
+		}
+	}
+	return c.closedCh
+}
+
+// disconnected marks that the connection is gone and closes the channel
+// returned by ClosedChan.
+func (c *conn) disconnected() {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	if !c.gone {
+		c.gone = true
+		if c.closedCh != nil {
+			close(c.closedCh)
+		}
+	}
+}
 
 // BaseWriter returns the underlying connection writer, if any
 func BaseWriter(c Conn) *Writer {
-	if c, ok := c.(*conn); ok {
+	if sc, ok := c.(*slotConn); ok {
+		return sc.slot.wr
+	}
+	if c := baseConn(c); c != nil {
 		return c.wr
 	}
 	return nil
@@ -495,6 +1158,35 @@ type DetachedConn interface {
 	ReadCommand() (Command, error)
 	// Flush flushes any writes to the network.
 	Flush() error
+	// SetDeadline sets the read and write deadlines of the connection.
+	SetDeadline(t time.Time) error
+	// SetReadDeadline sets the deadline for future ReadCommand calls.
+	// A ReadCommand that times out returns an error that can be checked
+	// using net.Error's Timeout method, and the connection remains usable.
+	SetReadDeadline(t time.Time) error
+	// SetWriteDeadline sets the deadline for future Flush calls.
+	SetWriteDeadline(t time.Time) error
+	// ReadBuffered returns the data that has been received from the client
+	// but not yet returned by ReadCommand, and removes it from the
+	// connection. Commands that have already been parsed are returned in
+	// their RESP form. Use it before reading from NetConn directly, such as
+	// when switching to another protocol, so no client data is lost.
+	ReadBuffered() []byte
+	// Restore hands the connection back to the server, which resumes
+	// reading commands and passing them to the handler. Any commands that
+	// were read, but not returned by ReadCommand, are passed to the handler
+	// first. It waits until the server loop has released the connection,
+	// so it must not be called by the handler that detached it. The
+	// detached connection must not be used after a successful Restore.
+	//
+	// A restored connection is admitted like a new one, so Restore fails
+	// while the server is draining, or when it has reached the maximum
+	// number of clients, see SetMaxClients, and the connection remains
+	// detached. The accept function is not called again. The closed
+	// function was called with a "detached" error when the connection was
+	// released, and it's called again when the restored connection is
+	// closed or detached.
+	Restore() error
 }
 
 // Detach removes the current connection from the server loop and returns
@@ -502,16 +1194,97 @@ type DetachedConn interface {
 // The detached connection must be closed by calling Close() when done.
 // All writes such as WriteString() will not be written to the client
 // until Flush() is called.
+//
+// Detach may be called by the handler, or by another goroutine. When called
+// by another goroutine while the server loop is waiting for client data, the
+// pending read is interrupted and Detach waits for the loop to release the
+// connection. When called while a handler is running, the connection is
+// released once the handler returns, and ReadCommand, ReadBuffered, and
+// Restore of the detached connection wait until then, so the handler must
+// not call them itself. Any data that has been read from the client but not
+// yet processed, including the rest of a pipeline, is handed over to the
+// detached connection. Calling Detach more than once returns the same
+// detached connection. The closed function of the server is called with a
+// "detached" error once the connection is released, see Restore.
 func (c *conn) Detach() DetachedConn {
-	c.detached = true
-	cmds := c.cmds
-	c.cmds = nil
-	return &detachedConn{conn: c, cmds: cmds}
+	c.dmu.Lock()
+	if c.dconn != nil {
+		dc := c.dconn
+		c.dmu.Unlock()
+		return dc
+	}
+	dc := &detachedConn{conn: c, ready: make(chan struct{})}
+	c.dconn = dc
+	atomic.StoreInt32(&c.detached, 1)
+	if c.released {
+		// the server loop has already released the connection
+		dc.cmds = c.cmds
+		c.cmds = nil
+		close(dc.ready)
+		c.dmu.Unlock()
+		return dc
+	}
+	if c.dispatching {
+		// The connection is released by the server loop once the current
+		// handler returns. The caller may be the handler, so don't wait.
+		c.dmu.Unlock()
+		return dc
+	}
+	if c.reading {
+		// interrupt the pending read
+		c.conn.SetReadDeadline(time.Now())
+	}
+	c.dmu.Unlock()
+	<-dc.ready
+	c.conn.SetReadDeadline(time.Time{})
+	return dc
 }
 
 type detachedConn struct {
 	*conn
-	cmds []Command
+	cmds  []Command
+	ready chan struct{} // closed when the server loop has released the conn
+}
+
+// Restore hands the connection back to the server loop. It waits until the
+// server loop has released the connection.
+func (dc *detachedConn) Restore() error {
+	c := dc.conn
+	s := c.server
+	if s == nil {
+		return errors.New("connection cannot be restored")
+	}
+	<-dc.ready
+	if c.isClosed() {
+		return errors.New("connection closed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.conns == nil {
+		return errors.New("server closed")
+	}
+	if atomic.LoadInt32(&s.draining) != 0 {
+		return errors.New("server draining")
+	}
+	if s.maxClients > 0 && len(s.conns) >= s.maxClients {
+		return errors.New("max number of clients reached")
+	}
+	c.dmu.Lock()
+	if c.dconn != dc || !c.released {
+		c.dmu.Unlock()
+		return errors.New("connection not detached")
+	}
+	c.dconn = nil
+	c.reading = false
+	c.dispatching = false
+	c.released = false
+	atomic.StoreInt32(&c.detached, 0)
+	c.dmu.Unlock()
+	c.cmds = dc.cmds
+	dc.cmds = nil
+	s.conns[c] = true
+	go handle(s, c)
+	return nil
 }
 
 // Flush writes and Write* calls to the client.
@@ -519,8 +1292,31 @@ func (dc *detachedConn) Flush() error {
 	return dc.conn.wr.Flush()
 }
 
+func (dc *detachedConn) SetDeadline(t time.Time) error {
+	return dc.conn.conn.SetDeadline(t)
+}
+
+func (dc *detachedConn) SetReadDeadline(t time.Time) error {
+	return dc.conn.conn.SetReadDeadline(t)
+}
+
+func (dc *detachedConn) SetWriteDeadline(t time.Time) error {
+	return dc.conn.conn.SetWriteDeadline(t)
+}
+
+func (dc *detachedConn) ReadBuffered() []byte {
+	<-dc.ready
+	var b []byte
+	for _, cmd := range dc.cmds {
+		b = append(b, cmd.Raw...)
+	}
+	dc.cmds = nil
+	return append(b, dc.rd.takeBuffered()...)
+}
+
 // ReadCommand read the next command from the client.
 func (dc *detachedConn) ReadCommand() (Command, error) {
+	<-dc.ready
 	if len(dc.cmds) > 0 {
 		cmd := dc.cmds[0]
 		if len(dc.cmds) == 1 {
@@ -532,6 +1328,11 @@ func (dc *detachedConn) ReadCommand() (Command, error) {
 	}
 	cmd, err := dc.rd.ReadCommand()
 	if err != nil {
+		if _, ok := err.(*errProtocol); !ok {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				dc.disconnected()
+			}
+		}
 		return Command{}, err
 	}
 	return cmd, nil
@@ -539,51 +1340,282 @@ func (dc *detachedConn) ReadCommand() (Command, error) {
 
 // Command represent a command
 type Command struct {
-	// Raw is a encoded RESP message.
+	// Raw is a encoded RESP message. For RESP commands it's the exact bytes
+	// that were received, so it can be forwarded as-is, such as by proxies
+	// and replication, and its length is the size of the command. Inline
+	// commands are converted to RESP.
 	Raw []byte
 	// Args is a series of arguments that make up the command.
 	Args [][]byte
+	// Stream reads the last argument when it's too large to be buffered,
+	// see Server.SetLargeArgThreshold. The argument is nil in Args, and Raw
+	// ends before its data.
+	Stream *BulkReader
 }
 
 // Server defines a server for clients for managing client connections.
 type Server struct {
-	mu        sync.Mutex
-	net       string
-	laddr     string
-	handler   func(conn Conn, cmd Command)
-	accept    func(conn Conn) bool
-	closed    func(conn Conn, err error)
-	conns     map[*conn]bool
-	ln        net.Listener
-	done      bool
-	idleClose time.Duration
+	stats          serverStats // must be first for 64-bit atomic alignment
+	clientMem      int64
+	maxClientMem   int64
+	nextID         uint64
+	paused         int32
+	draining       int32
+	mu             sync.Mutex
+	net            string
+	laddr          string
+	handler        func(conn Conn, cmd Command)
+	accept         func(conn Conn) bool
+	closed         func(conn Conn, err error)
+	conns          map[*conn]bool
+	ln             net.Listener
+	done           bool
+	idleClose      time.Duration
+	maxClients     int
+	largeReply     int
+	outputLimit    outputLimit
+	pauseEnd       time.Time
+	pauseMode      PauseMode
+	unpaused       chan struct{}
+	drainErr       string
+	outputWM       *Watermark
+	pipelineWM     *Watermark
+	acceptRate     float64
+	acceptBurst    int
+	acceptRateErr  string
+	acl            *AccessList
+	authSecret     func(user string) ([]byte, bool)
+	authPass       func(conn Conn, user, pass string) bool
+	hello          *HelloConfig
+	maxMultiBulk   int
+	maxQueryBuffer int
+	protoMaxBulk   int
+	strictRESP     bool
+	largeArg       int
+	noCopy         bool
+	maxBatch       int
+	workers        chan struct{}
+	incoming       chan IncomingCommand
+	builtins       bool
+	normalize      bool
+	auditArgs      bool
 
 	// AcceptError is an optional function used to handle Accept errors.
 	AcceptError func(err error)
+
+	// WriteCommand is an optional function that reports whether a command
+	// writes data. It's used to select the commands that are suspended by
+	// PauseWrite. When nil, PauseWrite suspends all commands.
+	WriteCommand func(cmd Command) bool
+
+	// LargeReply is an optional function that is called when the reply to
+	// a single command is at least the size set by SetLargeReplyThreshold.
+	LargeReply func(conn Conn, cmd Command, size int)
+
+	// Reset is an optional function that is called when a client resets
+	// its connection using the built-in RESET command, see SetBuiltins.
+	Reset func(conn Conn)
+	// Audit is an optional function that is called with the record of each
+	// command that the server receives, before the command is handled,
+	// including the commands that are rejected, such as those of clients
+	// that have not authenticated. It's called from the goroutine of the
+	// connection, in the order of the commands. See SetAuditArgs.
+	Audit func(rec AuditRecord)
 }
 
 // TLSServer defines a server for clients for managing client connections.
 type TLSServer struct {
 	*Server
 	config *tls.Config
+
+	// RotateError is an optional function that is called when the session
+	// ticket keys can't be rotated in the background, see
+	// RotateSessionTicketKeys.
+	RotateError func(err error)
 }
 
 // Writer allows for writing RESP messages.
+//
+// A Writer is safe to use from multiple goroutines. Each Write* call appends
+// one complete frame while holding an internal lock, so frames written by
+// different goroutines never tear, and Flush never sends a partial frame.
+// Frames from different goroutines may however interleave with each other, so
+// a reply that spans multiple calls, such as WriteArray followed by its
+// elements, must not be written while another goroutine is also writing.
+// Build such replies with BeginArray, or with the Append* functions and a
+// single WriteRaw call, instead.
+//
+// The write methods of RESP3 types, such as WriteMap, WriteSet, WriteBool,
+// and WriteFloat, use the type when the protocol is RESP3, and write the
+// RESP2 equivalent otherwise. Handlers can use these methods for all clients,
+// and the replies are downgraded for clients that did not send HELLO 3.
 type Writer struct {
-	w io.Writer
-	b []byte
-}
-
-// NewWriter creates a new RESP writer.
+	flushed  int64 // must be first for 64-bit atomic alignment
+	mu       sync.Mutex
+	w        io.Writer
+	b        []byte
+	err      error
+	recovers bool
+	proto    resp.Protocol
+}
+
+// NewWriter creates a new RESP writer. The writer can be any io.Writer, such
+// as a file, a pipe, or a TLS connection, so the encoding can be used without
+// a server. Replies are buffered until Flush is called.
 func NewWriter(wr io.Writer) *Writer {
 	return &Writer{
 		w: wr,
 	}
 }
 
-// WriteNull writes a null to the client
+// Protocol returns the RESP version that is used for writing replies.
+func (w *Writer) Protocol() resp.Protocol {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.proto == 0 {
+		return resp.RESP2
+	}
+	return w.proto
+}
+
+// SetProtocol sets the RESP version that is used for writing replies. Write
+// methods that have a RESP3 type, such as WriteFloat, use the type when the
+// protocol is RESP3, and the RESP2 equivalent otherwise.
+func (w *Writer) SetProtocol(proto resp.Protocol) {
+	w.mu.Lock()
+	w.proto = proto
+	w.mu.Unlock()
+}
+
+// WriteFloat writes a floating point number to the client, using the
+// formatting of Redis. It's written as a bulk string for RESP2, and as a
+// double for RESP3.
+func (w *Writer) WriteFloat(f float64) {
+	w.mu.Lock()
+	w.b = resp.AppendFloat(w.b, w.proto, f)
+	w.mu.Unlock()
+}
+
+// WriteBool writes a boolean to the client. It's written as a boolean for
+// RESP3, and as the integer 1 or 0 for RESP2.
+func (w *Writer) WriteBool(v bool) {
+	w.mu.Lock()
+	w.b = resp.AppendBool(w.b, w.proto, v)
+	w.mu.Unlock()
+}
+
+// WriteBigNumber writes an integer of arbitrary size to the client. It's
+// written as a big number for RESP3, and as a bulk string for RESP2. A nil
+// number is written as a null.
+func (w *Writer) WriteBigNumber(num *big.Int) {
+	if num == nil {
+		w.WriteNull()
+		return
+	}
+	w.WriteBigNumberString(num.String())
+}
+
+// WriteBigNumberString is like WriteBigNumber, where num is the decimal
+// representation of the integer.
+func (w *Writer) WriteBigNumberString(num string) {
+	w.mu.Lock()
+	w.b = resp.AppendBigNumber(w.b, w.proto, num)
+	w.mu.Unlock()
+}
+
+// WriteBulkFrom writes a bulk string of n bytes that are read from r. The
+// unflushed buffer and the bulk header are written first, and then the bytes
+// are copied to the underlying writer without buffering the whole value.
+// Other goroutines that write to the Writer wait until the copy is done.
+//
+// When an error is returned the stream is incomplete and the error is
+// recorded, see Err. This includes a reader that ends before n bytes.
+func (w *Writer) WriteBulkFrom(r io.Reader, n int64) error {
+	if n < 0 {
+		return errors.New("negative bulk length")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.b = append(w.b, '$')
+	w.b = strconv.AppendInt(w.b, n, 10)
+	w.b = append(w.b, '\r', '\n')
+	nn, err := w.w.Write(w.b)
+	atomic.AddInt64(&w.flushed, int64(nn))
+	if err != nil {
+		w.b = w.b[:copy(w.b, w.b[nn:])]
+		w.err = err
+		return err
+	}
+	w.b = w.b[:0]
+	copied, err := io.CopyN(w.w, r, n)
+	atomic.AddInt64(&w.flushed, copied)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		w.err = err
+		return err
+	}
+	w.b = append(w.b, '\r', '\n')
+	return nil
+}
+
+// WriteBulkOrNull writes bulk bytes to the client when ok is true, otherwise
+// a null.
+func (w *Writer) WriteBulkOrNull(bulk []byte, ok bool) {
+	w.mu.Lock()
+	if ok {
+		w.b = AppendBulk(w.b, bulk)
+	} else {
+		w.b = resp.AppendNullProto(w.b, w.proto)
+	}
+	w.mu.Unlock()
+}
+
+// WriteBulkArray writes an array of bulk bytes to the client. Nil elements
+// are written as nulls.
+func (w *Writer) WriteBulkArray(bulks [][]byte) {
+	w.mu.Lock()
+	w.b = AppendArray(w.b, len(bulks))
+	for _, bulk := range bulks {
+		if bulk != nil {
+			w.b = AppendBulk(w.b, bulk)
+		} else {
+			w.b = resp.AppendNullProto(w.b, w.proto)
+		}
+	}
+	w.mu.Unlock()
+}
+
+// WriteNull writes a null to the client. It's written as a null bulk string
+// for RESP2, and as the null type for RESP3.
 func (w *Writer) WriteNull() {
+	w.mu.Lock()
+	w.b = resp.AppendNullProto(w.b, w.proto)
+	w.mu.Unlock()
+}
+
+// WriteNullBulk writes a null bulk string to the client, regardless of the
+// protocol. It's for clients that expect the RESP2 null after HELLO 3.
+func (w *Writer) WriteNullBulk() {
+	w.mu.Lock()
 	w.b = AppendNull(w.b)
+	w.mu.Unlock()
+}
+
+// WriteNullArray writes a null array to the client. It's written as the null
+// type for RESP3.
+func (w *Writer) WriteNullArray() {
+	w.mu.Lock()
+	if w.proto >= resp.RESP3 {
+		w.b = resp.AppendNullProto(w.b, w.proto)
+	} else {
+		w.b = resp.AppendNullArray(w.b)
+	}
+	w.mu.Unlock()
 }
 
 // WriteArray writes an array header. You must then write additional
@@ -594,48 +1626,165 @@ func (w *Writer) WriteNull() {
 //   c.WriteBulk("item 1")
 //   c.WriteBulk("item 2")
 func (w *Writer) WriteArray(count int) {
+	w.mu.Lock()
 	w.b = AppendArray(w.b, count)
+	w.mu.Unlock()
+}
+
+// WriteMap writes a map header with count key/value pairs. You must then
+// write the keys and values. For RESP2 an array header with count*2 elements
+// is written instead.
+func (w *Writer) WriteMap(count int) {
+	w.mu.Lock()
+	w.b = resp.AppendMap(w.b, w.proto, count)
+	w.mu.Unlock()
+}
+
+// WriteSet writes a set header with count elements. You must then write the
+// elements. For RESP2 an array header is written instead.
+func (w *Writer) WriteSet(count int) {
+	w.mu.Lock()
+	w.b = resp.AppendSet(w.b, w.proto, count)
+	w.mu.Unlock()
+}
+
+// WriteAttribute writes attributes, which is metadata about the reply that
+// follows. The key/value pairs are written by fn, and pairs is the number of
+// pairs. Nothing is written for RESP2, and fn is not called. The attributes
+// are written as a single frame.
+func (w *Writer) WriteAttribute(pairs int, fn func(w *Writer)) {
+	w.mu.Lock()
+	proto := w.proto
+	w.mu.Unlock()
+	if proto < resp.RESP3 {
+		return
+	}
+	attr := Writer{proto: proto}
+	attr.b = resp.AppendAttribute(attr.b, pairs)
+	fn(&attr)
+	w.WriteRaw(attr.b)
+}
+
+// WriteMapStringString writes a map of strings. The keys are written in
+// sorted order.
+func (w *Writer) WriteMapStringString(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w.mu.Lock()
+	w.b = resp.AppendMap(w.b, w.proto, len(keys))
+	for _, key := range keys {
+		w.b = AppendBulkString(w.b, key)
+		w.b = AppendBulkString(w.b, m[key])
+	}
+	w.mu.Unlock()
 }
 
 // WriteBulk writes bulk bytes to the client.
 func (w *Writer) WriteBulk(bulk []byte) {
+	w.mu.Lock()
 	w.b = AppendBulk(w.b, bulk)
+	w.mu.Unlock()
 }
 
 // WriteBulkString writes a bulk string to the client.
 func (w *Writer) WriteBulkString(bulk string) {
+	w.mu.Lock()
 	w.b = AppendBulkString(w.b, bulk)
+	w.mu.Unlock()
 }
 
 // Buffer returns the unflushed buffer. This is a copy so changes
 // to the resulting []byte will not affect the writer.
 func (w *Writer) Buffer() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return append([]byte(nil), w.b...)
 }
 
 // SetBuffer replaces the unflushed buffer with new bytes.
 func (w *Writer) SetBuffer(raw []byte) {
+	w.mu.Lock()
 	w.b = w.b[:0]
 	w.b = append(w.b, raw...)
+	w.mu.Unlock()
 }
 
 // Flush writes all unflushed Write* calls to the underlying writer.
+//
+// When the underlying writer fails, the bytes that were written are removed
+// from the buffer and the error is recorded. Following calls to Flush return
+// the recorded error without writing, see Err.
 func (w *Writer) Flush() error {
-	if _, err := w.w.Write(w.b); err != nil {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	n, err := w.w.Write(w.b)
+	atomic.AddInt64(&w.flushed, int64(n))
+	if err != nil {
+		w.b = w.b[:copy(w.b, w.b[n:])]
+		w.err = err
 		return err
 	}
 	w.b = w.b[:0]
 	return nil
 }
 
-// WriteError writes an error to the client.
+// Err returns the error that occurred while flushing, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// SetRecoverTimeouts allows ClearErr to clear timeout errors, such as a
+// write deadline that was exceeded while sending a keepalive. Recovering is
+// safe because the bytes that were not written remain in the buffer, and the
+// next Flush continues where the failed one stopped. Disabled by default.
+func (w *Writer) SetRecoverTimeouts(on bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.recovers = on
+}
+
+// ClearErr clears a recoverable error, allowing the writer to continue.
+// Returns false when the error was not cleared, which is when recovering is
+// not enabled by SetRecoverTimeouts or the error is not a timeout.
+func (w *Writer) ClearErr() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		return true
+	}
+	if ne, ok := w.err.(net.Error); !w.recovers || !ok || !ne.Timeout() {
+		return false
+	}
+	w.err = nil
+	return true
+}
+
+// WriteError writes an error to the client. CR and LF characters are
+// replaced with spaces.
 func (w *Writer) WriteError(msg string) {
+	w.mu.Lock()
 	w.b = AppendError(w.b, msg)
+	w.mu.Unlock()
 }
 
-// WriteString writes a string to the client.
+// WriteString writes a string to the client. A string that contains CR or LF
+// characters is written as a bulk string.
 func (w *Writer) WriteString(msg string) {
-	w.b = AppendString(w.b, msg)
+	w.mu.Lock()
+	if strings.ContainsAny(msg, "\r\n") {
+		w.b = AppendBulkString(w.b, msg)
+	} else {
+		w.b = AppendString(w.b, msg)
+	}
+	w.mu.Unlock()
 }
 
 // WriteInt writes an integer to the client.
@@ -645,17 +1794,51 @@ func (w *Writer) WriteInt(num int) {
 
 // WriteInt64 writes a 64-bit signed integer to the client.
 func (w *Writer) WriteInt64(num int64) {
+	w.mu.Lock()
 	w.b = AppendInt(w.b, num)
+	w.mu.Unlock()
 }
 
-// WriteUint64 writes a 64-bit unsigned integer to the client.
+// WriteUint64 writes a 64-bit unsigned integer to the client. Numbers larger
+// than math.MaxInt64 do not fit in a RESP integer, and are written as bulk
+// strings instead.
 func (w *Writer) WriteUint64(num uint64) {
-	w.b = AppendUint(w.b, num)
+	w.mu.Lock()
+	if num > math.MaxInt64 {
+		w.b = AppendBulkUint(w.b, num)
+	} else {
+		w.b = AppendUint(w.b, num)
+	}
+	w.mu.Unlock()
 }
 
 // WriteRaw writes raw data to the client.
 func (w *Writer) WriteRaw(data []byte) {
+	w.mu.Lock()
 	w.b = append(w.b, data...)
+	w.mu.Unlock()
+}
+
+// buffered returns the number of unflushed bytes.
+func (w *Writer) buffered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.b)
+}
+
+// written returns the number of bytes that have been written, both flushed
+// and unflushed.
+func (w *Writer) written() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushed + int64(len(w.b))
+}
+
+// capacity returns the capacity of the unflushed buffer.
+func (w *Writer) capacity() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return cap(w.b)
 }
 
 // WriteAny writes any type to client.
@@ -665,32 +1848,139 @@ func (w *Writer) WriteRaw(data []byte) {
 //   numbers         -> bulk-string
 //   []byte          -> bulk-string
 //   bool            -> bulk-string ("0" or "1")
-//   slice           -> array
+//   slice, array    -> array
 //   map             -> array with key/value pairs
+//   pointer         -> the value it points to, or null when nil
 //   SimpleString    -> string
 //   SimpleInt       -> integer
 //   everything-else -> bulk-string representation using fmt.Sprint()
+// For RESP3, nil, bools, floats, and maps are written as the RESP3 null,
+// boolean, double, and map types, see resp.AppendAnyProto.
 func (w *Writer) WriteAny(v interface{}) {
-	w.b = AppendAny(w.b, v)
+	w.mu.Lock()
+	w.b = resp.AppendAnyProto(w.b, w.proto, v)
+	w.mu.Unlock()
 }
 
-// Reader represent a reader for RESP or telnet commands.
+// Reader represent a reader for RESP or telnet commands. It reads from any
+// io.Reader, such as a file or a pipe, so it can be used for parsing
+// commands without a server, for example in proxies, test harnesses, and
+// replication consumers.
 type Reader struct {
-	rd    *bufio.Reader
-	buf   []byte
-	start int
-	end   int
-	cmds  []Command
+	buffered     int64 // must be first for 64-bit atomic alignment
+	read         int64
+	partial      int64
+	expected     int64
+	rd           *bufio.Reader
+	buf          []byte
+	start        int
+	end          int
+	cmds         []Command
+	maxMultiBulk int
+	maxBulk      int
+	maxBuffer    int
+	strict       bool
+	largeArg     int
+	stream       *BulkReader
+	noCopy       bool
+	maxBatch     int
+}
+
+const (
+	// DefaultMaxMultiBulkLen is the default maximum number of arguments of
+	// a command, which is the limit of Redis.
+	DefaultMaxMultiBulkLen = 1024 * 1024
+	// DefaultMaxBulkLen is the default maximum size of an argument, which is
+	// the default proto-max-bulk-len of Redis.
+	DefaultMaxBulkLen = 512 * 1024 * 1024
+	// DefaultMaxQueryBufferLen is the default maximum size of the read
+	// buffer, which is the default client-query-buffer-limit of Redis.
+	DefaultMaxQueryBufferLen = 1024 * 1024 * 1024
+)
+
+// readBufferLen is the initial size of the read buffer. The buffer grows
+// for larger commands, and is shrunk back to this size once the commands
+// have been read.
+const readBufferLen = 4096
+
+// SetMaxMultiBulkLen sets the maximum number of arguments of a command. A
+// command with more arguments is a protocol error. Use zero for the default,
+// DefaultMaxMultiBulkLen.
+func (rd *Reader) SetMaxMultiBulkLen(n int) {
+	rd.maxMultiBulk = n
+}
+
+// SetMaxBulkLen sets the maximum size of an argument, in bytes. A larger
+// argument is a protocol error, which is returned before the argument is
+// read, so a client can't make the reader allocate memory for data that it
+// never sends. Use zero for the default, DefaultMaxBulkLen.
+func (rd *Reader) SetMaxBulkLen(n int) {
+	rd.maxBulk = n
+}
+
+// SetMaxBufferLen sets the maximum size of the read buffer, in bytes, which
+// must hold an entire command, or pipelined commands, before they are
+// returned. When the buffer would grow beyond the maximum, the read fails.
+// Use zero for the default, DefaultMaxQueryBufferLen.
+func (rd *Reader) SetMaxBufferLen(n int) {
+	rd.maxBuffer = n
+}
+
+// SetStrictRESP disables the plain text commands, also known as inline
+// commands, which are sent by telnet clients. In strict mode every command
+// must be a RESP array, and any other input is a protocol error.
+func (rd *Reader) SetStrictRESP(strict bool) {
+	rd.strict = strict
+}
+
+// SetUnsafeNoCopy disables the copying of RESP commands. The Raw bytes and
+// Args of the commands are slices of the read buffer of the reader, which are
+// only valid until the next call to ReadCommand or ReadCommands, and must not
+// be modified or retained.
+func (rd *Reader) SetUnsafeNoCopy(noCopy bool) {
+	rd.noCopy = noCopy
+}
+
+// SetMaxBatchSize sets the maximum number of commands that ReadCommands
+// returns at once. The rest of the pipelined commands are returned by the
+// next calls, without reading from the underlying reader. Use zero for no
+// limit, which is the default.
+func (rd *Reader) SetMaxBatchSize(n int) {
+	rd.maxBatch = n
+}
+
+func (rd *Reader) multiBulkLimit() int {
+	if rd.maxMultiBulk > 0 {
+		return rd.maxMultiBulk
+	}
+	return DefaultMaxMultiBulkLen
+}
+
+func (rd *Reader) bulkLimit() int {
+	if rd.maxBulk > 0 {
+		return rd.maxBulk
+	}
+	return DefaultMaxBulkLen
+}
+
+func (rd *Reader) bufferLimit() int {
+	if rd.maxBuffer > 0 {
+		return rd.maxBuffer
+	}
+	return DefaultMaxQueryBufferLen
 }
 
 // NewReader returns a command reader which will read RESP or telnet commands.
 func NewReader(rd io.Reader) *Reader {
 	return &Reader{
 		rd:  bufio.NewReader(rd),
-		buf: make([]byte, 4096),
+		buf: make([]byte, readBufferLen),
 	}
 }
 
+// parseInt parses a signed decimal integer, such as the length of a
+// multibulk or bulk. Returns false when b is not a number, or when the number
+// does not fit in an int.
 func parseInt(b []byte) (int, bool) {
 	if len(b) == 1 && b[0] >= '0' && b[0] <= '9' {
 		return int(b[0] - '0'), true
@@ -702,11 +1992,18 @@ func parseInt(b []byte) (int, bool) {
 		sign = true
 		i++
 	}
+	if i == len(b) {
+		return 0, false
+	}
 	for ; i < len(b); i++ {
 		if b[i] < '0' || b[i] > '9' {
 			return 0, false
 		}
-		n = n*10 + int(b[i]-'0')
+		d := int(b[i] - '0')
+		if n > (math.MaxInt-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
 	}
 	if sign {
 		n *= -1
@@ -714,20 +2011,139 @@ func parseInt(b []byte) (int, bool) {
 	return n, true
 }
 
+// splitArgs splits the line of a plain text command into arguments, like
+// the sdssplitargs function of Redis. Arguments are separated by spaces, and
+// may be quoted. Double quoted arguments support the \n, \r, \t, \b, \a,
+// and \xHH escapes, and single quoted arguments support the \' escape. A
+// closing quote must be followed by a space or the end of the line.
+func splitArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var inq, insq, done bool
+		arg := []byte{}
+		for !done {
+			if i == len(line) {
+				if inq || insq {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inq:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					arg = append(arg, hexDigitVal(line[i+2])<<4|
+						hexDigitVal(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch c = line[i]; c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					arg = append(arg, c)
+				} else if c == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			case insq:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t':
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			i++
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') ||
+		(c >= 'A' && c <= 'F')
+}
+
+func hexDigitVal(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
 func (rd *Reader) readCommands(leftover *int) ([]Command, error) {
+	if rd.stream != nil {
+		// skip the rest of the streamed argument
+		if err := rd.stream.discard(); err != nil {
+			return nil, err
+		}
+		rd.stream = nil
+	}
 	var cmds []Command
 	b := rd.buf[rd.start:rd.end]
-	if rd.end-rd.start == 0 && len(rd.buf) > 4096 {
-		rd.buf = rd.buf[:4096]
-		rd.start = 0
-		rd.end = 0
-	}
+	rd.shrink()
 	if len(b) > 0 {
 		// we have data, yay!
 		// but is this enough data for a complete command? or multiple?
 	next:
+		if rd.maxBatch > 0 && len(cmds) == rd.maxBatch {
+			// leave the rest for the next batch
+			goto done
+		}
 		switch b[0] {
 		default:
+			if rd.strict {
+				return nil, &errProtocol{"expected '*', got '" +
+					string(b[0]) + "'"}
+			}
 			// just a plain text command
 			for i := 0; i < len(b); i++ {
 				if b[i] == '\n' {
@@ -737,74 +2153,17 @@ func (rd *Reader) readCommands(leftover *int) ([]Command, error) {
 					} else {
 						line = b[:i]
 					}
-					var cmd Command
-					var quote bool
-					var quotech byte
-					var escape bool
-				outer:
-					for {
-						nline := make([]byte, 0, len(line))
-						for i := 0; i < len(line); i++ {
-							c := line[i]
-							if !quote {
-								if c == ' ' {
-									if len(nline) > 0 {
-										cmd.Args = append(cmd.Args, nline)
-									}
-									line = line[i+1:]
-									continue outer
-								}
-								if c == '"' || c == '\'' {
-									if i != 0 {
-										return nil, errUnbalancedQuotes
-									}
-									quotech = c
-									quote = true
-									line = line[i+1:]
-									continue outer
-								}
-							} else {
-								if escape {
-									escape = false
-									switch c {
-									case 'n':
-										c = '\n'
-									case 'r':
-										c = '\r'
-									case 't':
-										c = '\t'
-									}
-								} else if c == quotech {
-									quote = false
-									quotech = 0
-									cmd.Args = append(cmd.Args, nline)
-									line = line[i+1:]
-									if len(line) > 0 && line[0] != ' ' {
-										return nil, errUnbalancedQuotes
-									}
-									continue outer
-								} else if c == '\\' {
-									escape = true
-									continue
-								}
-							}
-							nline = append(nline, c)
-						}
-						if quote {
-							return nil, errUnbalancedQuotes
-						}
-						if len(line) > 0 {
-							cmd.Args = append(cmd.Args, line)
-						}
-						break
+					args, err := splitArgs(line)
+					if err != nil {
+						return nil, err
 					}
+					cmd := Command{Args: args}
 					if len(cmd.Args) > 0 {
 						// convert this to resp command syntax
 						var wr Writer
 						wr.WriteArray(len(cmd.Args))
 						for i := range cmd.Args {
 							wr.WriteBulk(cmd.Args[i])
-							cmd.Args[i] = append([]byte(nil), cmd.Args[i]...)
 						}
 						cmd.Raw = wr.b
 						cmds = append(cmds, cmd)
@@ -827,9 +2186,17 @@ func (rd *Reader) readCommands(leftover *int) ([]Command, error) {
 						return nil, errInvalidMultiBulkLength
 					}
 					count, ok := parseInt(b[1 : i-1])
-					if !ok || count <= 0 {
+					if !ok || count > rd.multiBulkLimit() {
 						return nil, errInvalidMultiBulkLength
 					}
+					if count <= 0 {
+						// empty and null multibulks are skipped, like Redis.
+						b = b[i+1:]
+						if len(b) > 0 {
+							goto next
+						}
+						goto done
+					}
 					marks = marks[:0]
 					for j := 0; j < count; j++ {
 						// read bulk length
@@ -846,9 +2213,19 @@ func (rd *Reader) readCommands(leftover *int) ([]Command, error) {
 										return nil, errInvalidBulkLength
 									}
 									size, ok := parseInt(b[si+1 : i-1])
-									if !ok || size < 0 {
+									if !ok || size < 0 ||
+										size > rd.bulkLimit() {
 										return nil, errInvalidBulkLength
 									}
+									if j == count-1 && rd.largeArg > 0 &&
+										size > rd.largeArg && rd.rd != nil {
+										// stream the last argument.
+										cmds = append(cmds,
+											rd.streamCommand(b[:i+1], marks,
+												count, size))
+										b = b[i+1:]
+										goto done
+									}
 									if i+size+2 >= len(b) {
 										// not ready
 										break outer2
@@ -867,7 +2244,7 @@ func (rd *Reader) readCommands(leftover *int) ([]Command, error) {
 					}
 					if len(marks) == count*2 {
 						var cmd Command
-						if rd.rd != nil {
+						if rd.rd != nil && !rd.noCopy {
 							// make a raw copy of the entire command when
 							// there's a underlying reader.
 							cmd.Raw = append([]byte(nil), b[:i+1]...)
@@ -898,30 +2275,118 @@ func (rd *Reader) readCommands(leftover *int) ([]Command, error) {
 	if leftover != nil {
 		*leftover = rd.end - rd.start
 	}
+	if rd.rd != nil {
+		rd.updateState()
+	}
 	if len(cmds) > 0 {
 		return cmds, nil
 	}
 	if rd.rd == nil {
 		return nil, errIncompleteCommand
 	}
+	if err := rd.fill(); err != nil {
+		return nil, err
+	}
+	return rd.readCommands(leftover)
+}
+
+// shrink releases the memory of a buffer that was grown for large commands,
+// once it has been read entirely.
+func (rd *Reader) shrink() {
+	if rd.end-rd.start == 0 && rd.rd != nil && cap(rd.buf) > readBufferLen {
+		rd.buf = make([]byte, readBufferLen)
+		rd.start = 0
+		rd.end = 0
+	}
+}
+
+// fill reads more data from the underlying reader into the buffer.
+func (rd *Reader) fill() error {
 	if rd.end == len(rd.buf) {
 		// at the end of the buffer.
-		if rd.start == rd.end {
-			// rewind the to the beginning
-			rd.start, rd.end = 0, 0
+		if rd.start > 0 {
+			// move the incomplete command to the beginning
+			rd.end = copy(rd.buf, rd.buf[rd.start:rd.end])
+			rd.start = 0
 		} else {
 			// must grow the buffer
-			newbuf := make([]byte, len(rd.buf)*2)
+			size := len(rd.buf) * 2
+			if size > rd.bufferLimit() {
+				size = rd.bufferLimit()
+			}
+			if size <= len(rd.buf) {
+				return errQueryBufferLimit
+			}
+			newbuf := make([]byte, size)
 			copy(newbuf, rd.buf)
 			rd.buf = newbuf
 		}
 	}
 	n, err := rd.rd.Read(rd.buf[rd.end:])
+	atomic.AddInt64(&rd.read, int64(n))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	rd.end += n
-	return rd.readCommands(leftover)
+	return nil
+}
+
+// updateState records the number of unparsed bytes and the progress of the
+// incomplete command, if any, for diagnostics.
+func (rd *Reader) updateState() {
+	b := rd.buf[rd.start:rd.end]
+	var args, expected int
+	if len(b) > 0 && b[0] == '*' {
+		args, expected = partialArgs(b)
+	}
+	atomic.StoreInt64(&rd.buffered, int64(len(b)))
+	atomic.StoreInt64(&rd.partial, int64(args))
+	atomic.StoreInt64(&rd.expected, int64(expected))
+}
+
+// partialArgs returns the number of arguments that have been completely
+// received for the incomplete RESP command in b, and the number of arguments
+// that the command header expects.
+func partialArgs(b []byte) (args, expected int) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 2 {
+		return 0, 0
+	}
+	expected, _ = parseInt(b[1 : i-1])
+	b = b[i+1:]
+	for args < expected {
+		i = bytes.IndexByte(b, '\n')
+		if i < 2 || b[0] != '$' {
+			break
+		}
+		size, ok := parseInt(b[1 : i-1])
+		if !ok || size < 0 || len(b) < i+1+size+2 {
+			break
+		}
+		b = b[i+1+size+2:]
+		args++
+	}
+	return args, expected
+}
+
+// takeBuffered returns and removes all unread data, including the commands
+// that have been parsed but not returned by ReadCommand.
+func (rd *Reader) takeBuffered() []byte {
+	var b []byte
+	for _, cmd := range rd.cmds {
+		b = append(b, cmd.Raw...)
+	}
+	rd.cmds = nil
+	b = append(b, rd.buf[rd.start:rd.end]...)
+	rd.start, rd.end = 0, 0
+	if rd.rd != nil {
+		n := rd.rd.Buffered()
+		p, _ := rd.rd.Peek(n)
+		b = append(b, p...)
+		rd.rd.Discard(n)
+	}
+	rd.updateState()
+	return b
 }
 
 // ReadCommand reads the next command.
@@ -939,7 +2404,61 @@ func (rd *Reader) ReadCommand() (Command, error) {
 	return rd.ReadCommand()
 }
 
-// Parse parses a raw RESP message and returns a command.
+// ReadCommands reads the next batch of commands, which is all of the complete
+// commands that are available, and at least one. Reading in batches is
+// useful for handling pipelines. Commands that remain from ReadCommand calls
+// are returned first.
+func (rd *Reader) ReadCommands() ([]Command, error) {
+	if len(rd.cmds) > 0 {
+		cmds := rd.cmds
+		rd.cmds = nil
+		return cmds, nil
+	}
+	return rd.readCommands(nil)
+}
+
+// ReadReply reads the next reply, which may be any RESP2 or RESP3 type, such
+// as when the reader is used by a client, a proxy, or a replica that reads
+// the replies of a server. The reply is copied from the read buffer.
+//
+//   wr := redcon.NewWriter(conn)
+//   rd := redcon.NewReader(conn)
+//   wr.WriteArray(2)
+//   wr.WriteBulkString("GET")
+//   wr.WriteBulkString("key")
+//   wr.Flush()
+//   reply, err := rd.ReadReply()
+//
+// Do not mix calls to ReadReply with ReadCommand or ReadCommands, unless all
+// of the commands that were read have been returned.
+func (rd *Reader) ReadReply() (resp.Value, error) {
+	rd.shrink()
+	for {
+		n, v, err := resp.ReadValue(rd.buf[rd.start:rd.end])
+		if err != nil {
+			return resp.Value{}, err
+		}
+		if n > 0 {
+			raw := append([]byte(nil), rd.buf[rd.start:rd.start+n]...)
+			rd.start += n
+			_, v, _ = resp.ReadValue(raw)
+			return v, nil
+		}
+		if rd.rd == nil {
+			return resp.Value{}, errIncompleteCommand
+		}
+		if err := rd.fill(); err != nil {
+			return resp.Value{}, err
+		}
+	}
+}
+
+// Parse parses a raw RESP message and returns a command. The message may also
+// be an inline command, such as "SET key value\r\n". It must contain exactly
+// one complete command. The Raw and Args of a RESP command reference raw, so
+// raw must not be modified while the command is in use. For parsing a buffer
+// that holds many commands, such as an AOF file, use ReadNextCommand or a
+// Reader instead.
 func Parse(raw []byte) (Command, error) {
 	rd := Reader{buf: raw, end: len(raw)}
 	var leftover int
@@ -954,7 +2473,10 @@ func Parse(raw []byte) (Command, error) {
 
 }
 
-// A Handler responds to an RESP request.
+// A Handler responds to an RESP request. Handlers can be composed, such as a
+// handler that checks a condition before calling another handler, and are
+// served using NewServerHandler, or by passing their ServeRESP method to
+// NewServer.
 type Handler interface {
 	ServeRESP(conn Conn, cmd Command)
 }
@@ -965,24 +2487,50 @@ type Handler interface {
 // Handler that calls f.
 type HandlerFunc func(conn Conn, cmd Command)
 
-// ServeRESP calls f(w, r)
+// ServeRESP calls f(conn, cmd).
 func (f HandlerFunc) ServeRESP(conn Conn, cmd Command) {
 	f(conn, cmd)
 }
 
-// ServeMux is an RESP command multiplexer.
+// ServeMux is an RESP command multiplexer. It calls the handler that is
+// registered for the name of each command, which is matched
+// case-insensitively.
+//
+//   mux := redcon.NewServeMux()
+//   mux.HandleFunc("get", get)
+//   mux.HandleFunc("set", set)
+//   redcon.ListenAndServe(addr, mux.ServeRESP, nil, nil)
+//
+// Handlers can also be registered for the subcommands of container
+// commands, such as "CONFIG GET" and "CLIENT KILL", see Handle.
+//
+// Unless a handler is registered for it, the mux answers the COMMAND,
+// COMMAND COUNT, COMMAND INFO, and COMMAND DOCS commands from the registered
+// commands, which includes the arity, flags, and documentation of the
+// commands that were registered using HandleCommand.
 type ServeMux struct {
 	handlers map[string]Handler
+	// containers are the commands that have subcommands
+	containers map[string]bool
+	// infos are the commands that were registered using HandleCommand
+	infos map[string]*CommandInfo
+	// NotFound is called for the commands that have no handler, which
+	// allows for customizing the reply. When nil, the unknown command error
+	// of Redis is written to the client, see WriteUnknownCommand.
+	NotFound Handler
 }
 
 // NewServeMux allocates and returns a new ServeMux.
 func NewServeMux() *ServeMux {
 	return &ServeMux{
-		handlers: make(map[string]Handler),
+		handlers:   make(map[string]Handler),
+		containers: make(map[string]bool),
+		infos:      make(map[string]*CommandInfo),
 	}
 }
 
-// HandleFunc registers the handler function for the given command.
+// HandleFunc registers the handler function for the given command, see
+// Handle.
 func (m *ServeMux) HandleFunc(command string, handler func(conn Conn, cmd Command)) {
 	if handler == nil {
 		panic("redcon: nil handler")
@@ -990,30 +2538,82 @@ func (m *ServeMux) HandleFunc(command string, handler func(conn Conn, cmd Comman
 	m.Handle(command, HandlerFunc(handler))
 }
 
-// Handle registers the handler for the given command.
-// If a handler already exists for command, Handle panics.
+// Handle registers the handler for the given command. The command is
+// case-insensitive, so "GET" and "get" are the same command. If a handler
+// already exists for command, Handle panics.
+//
+// A command and subcommand that are separated by a space, such as
+// "CONFIG GET", registers the handler for the subcommand. Commands with
+// unknown subcommands are passed to the handler of the command itself, if
+// any, or else an unknown subcommand error is written to the client.
+//
+//   mux.HandleFunc("config get", configGet)
+//   mux.HandleFunc("config set", configSet)
+//   mux.HandleFunc("config help", configHelp)
 func (m *ServeMux) Handle(command string, handler Handler) {
+	m.handle(command, handler)
+}
+
+// handle registers the handler, and returns the key of the command.
+func (m *ServeMux) handle(command string, handler Handler) string {
 	if command == "" {
 		panic("redcon: invalid command")
 	}
 	if handler == nil {
 		panic("redcon: nil handler")
 	}
+	command = asciiLower([]byte(command))
+	if name, sub, ok := strings.Cut(command, " "); ok {
+		if name == "" || sub == "" || strings.Contains(sub, " ") {
+			panic("redcon: invalid command " + command)
+		}
+		m.containers[name] = true
+		command = name + "|" + sub
+	}
 	if _, exist := m.handlers[command]; exist {
 		panic("redcon: multiple registrations for " + command)
 	}
 
 	m.handlers[command] = handler
+	return command
 }
 
-// ServeRESP dispatches the command to the handler.
+// ServeRESP dispatches the command to the handler. The handler is looked
+// up without allocating memory.
 func (m *ServeMux) ServeRESP(conn Conn, cmd Command) {
-	command := strings.ToLower(string(cmd.Args[0]))
-
-	if handler, ok := m.handlers[command]; ok {
-		handler.ServeRESP(conn, cmd)
+	var buf [64]byte
+	command := appendLower(buf[:0], cmd.Args[0])
+
+	if m.containers[string(command)] {
+		if len(cmd.Args) > 1 {
+			var subbuf [64]byte
+			sub := append(append(subbuf[:0], command...), '|')
+			sub = appendLower(sub, cmd.Args[1])
+			if handler, ok := m.handlers[string(sub)]; ok {
+				m.serve(conn, cmd, sub, handler)
+				return
+			}
+		}
+		if handler, ok := m.handlers[string(command)]; ok {
+			m.serve(conn, cmd, command, handler)
+		} else if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for '" +
+				string(command) + "' command")
+		} else {
+			asciiUpper(command)
+			conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) +
+				"'. Try " + string(command) + " HELP.")
+		}
+		return
+	}
+	if handler, ok := m.handlers[string(command)]; ok {
+		m.serve(conn, cmd, command, handler)
+	} else if string(command) == "command" {
+		m.serveCommand(conn, cmd)
+	} else if m.NotFound != nil {
+		m.NotFound.ServeRESP(conn, cmd)
 	} else {
-		conn.WriteError("ERR unknown command '" + command + "'")
+		WriteUnknownCommand(conn, cmd)
 	}
 }
 
@@ -1060,7 +2660,7 @@ func (ps *PubSub) Publish(channel, message string) int {
 	pivot = &pubSubEntry{pattern: true}
 	ps.chans.Ascend(pivot, func(item interface{}) bool {
 		entry := item.(*pubSubEntry)
-		if match.Match(channel, entry.channel) {
+		if globMatch(entry.channel, channel) {
 			entry.sconn.writeMessage(entry.pattern, entry.channel, channel,
 				message)
 		}
@@ -1089,18 +2689,10 @@ func (sconn *pubSubConn) writeMessage(pat bool, pchan, channel, msg string) {
 	sconn.mu.Lock()
 	defer sconn.mu.Unlock()
 	if pat {
-		sconn.dconn.WriteArray(4)
-		sconn.dconn.WriteBulkString("pmessage")
-		sconn.dconn.WriteBulkString(pchan)
-		sconn.dconn.WriteBulkString(channel)
-		sconn.dconn.WriteBulkString(msg)
+		sconn.dconn.WritePush("pmessage", pchan, channel, msg)
 	} else {
-		sconn.dconn.WriteArray(3)
-		sconn.dconn.WriteBulkString("message")
-		sconn.dconn.WriteBulkString(channel)
-		sconn.dconn.WriteBulkString(msg)
+		sconn.dconn.WritePush("message", channel, msg)
 	}
-	sconn.dconn.Flush()
 }
 
 // bgrunner runs in the background and reads incoming commands from the
@@ -1127,7 +2719,7 @@ func (sconn *pubSubConn) bgrunner(ps *PubSub) {
 		if len(cmd.Args) == 0 {
 			continue
 		}
-		switch strings.ToLower(string(cmd.Args[0])) {
+		switch asciiLower(cmd.Args[0]) {
 		case "psubscribe", "subscribe":
 			if len(cmd.Args) < 2 {
 				func() {
@@ -1139,7 +2731,7 @@ func (sconn *pubSubConn) bgrunner(ps *PubSub) {
 				}()
 				continue
 			}
-			command := strings.ToLower(string(cmd.Args[0]))
+			command := asciiLower(cmd.Args[0])
 			for i := 1; i < len(cmd.Args); i++ {
 				if command == "psubscribe" {
 					ps.Psubscribe(sconn.conn, string(cmd.Args[i]))
@@ -1148,7 +2740,7 @@ func (sconn *pubSubConn) bgrunner(ps *PubSub) {
 				}
 			}
 		case "unsubscribe", "punsubscribe":
-			pattern := strings.ToLower(string(cmd.Args[0])) == "punsubscribe"
+			pattern := asciiLower(cmd.Args[0]) == "punsubscribe"
 			if len(cmd.Args) == 1 {
 				ps.unsubscribe(sconn.conn, pattern, true, "")
 			} else {
@@ -1361,3 +2953,403 @@ func (s *Server) SetIdleClose(dur time.Duration) {
 	s.idleClose = dur
 	s.mu.Unlock()
 }
+
+// SetMaxClients limits the number of connections that are managed by the
+// server. New connections beyond the limit are sent an error and closed.
+// Use zero to disable this feature.
+func (s *Server) SetMaxClients(n int) {
+	s.mu.Lock()
+	s.maxClients = n
+	s.mu.Unlock()
+}
+
+// SetMaxMultiBulkLen sets the maximum number of arguments of a command. A
+// client that sends a command with more arguments is sent a protocol error
+// and disconnected. Use zero for the default, DefaultMaxMultiBulkLen.
+func (s *Server) SetMaxMultiBulkLen(n int) {
+	s.mu.Lock()
+	s.maxMultiBulk = n
+	s.mu.Unlock()
+}
+
+// SetMaxBatchSize sets the maximum number of pipelined commands that are
+// handled before the replies are flushed to the client, such as 128. The
+// rest of the pipeline is handled in the next batches. A limit keeps clients
+// that send very large pipelines from causing latency spikes and from
+// holding all of their commands and replies in memory at once. Use zero for
+// no limit, which is the default.
+func (s *Server) SetMaxBatchSize(n int) {
+	s.mu.Lock()
+	s.maxBatch = n
+	s.mu.Unlock()
+}
+
+// SetUnsafeNoCopy disables the copying of the commands that are received
+// from clients, which saves an allocation and a copy per command. The Raw
+// bytes and Args of a command are slices of the read buffer of the
+// connection, which are only valid for the duration of the handler call, and
+// must not be modified or retained. Use this for high-throughput servers,
+// such as proxies, that forward or encode the arguments right away. Handlers
+// that hold on to commands, such as for queueing them, must copy them.
+func (s *Server) SetUnsafeNoCopy(noCopy bool) {
+	s.mu.Lock()
+	s.noCopy = noCopy
+	s.mu.Unlock()
+}
+
+// SetStrictRESP disables the plain text commands, also known as inline
+// commands, which are sent by telnet clients. In strict mode a client that
+// sends anything other than a RESP array is replied a protocol error and
+// disconnected. Use this when all clients are expected to use a Redis client
+// library.
+func (s *Server) SetStrictRESP(strict bool) {
+	s.mu.Lock()
+	s.strictRESP = strict
+	s.mu.Unlock()
+}
+
+// SetProtoMaxBulkLen sets the maximum size of an argument, in bytes, like
+// the proto-max-bulk-len setting of Redis. A client that sends a larger
+// argument is replied "ERR Protocol error: invalid bulk length" and
+// disconnected, before the argument is read. Use zero for the default,
+// DefaultMaxBulkLen.
+func (s *Server) SetProtoMaxBulkLen(n int) {
+	s.mu.Lock()
+	s.protoMaxBulk = n
+	s.mu.Unlock()
+}
+
+// SetMaxQueryBufferLen sets the maximum size of the read buffer of a
+// connection, in bytes. A client that sends a command, or pipelined commands,
+// that do not fit in the buffer is disconnected. Use zero for the default,
+// DefaultMaxQueryBufferLen. The read buffer is shrunk back to its initial
+// size once the commands have been read.
+func (s *Server) SetMaxQueryBufferLen(n int) {
+	s.mu.Lock()
+	s.maxQueryBuffer = n
+	s.mu.Unlock()
+}
+
+// SetLargeReplyThreshold sets the reply size, in bytes, at which the
+// LargeReply function is called. Use zero to disable this feature.
+func (s *Server) SetLargeReplyThreshold(size int) {
+	s.mu.Lock()
+	s.largeReply = size
+	s.mu.Unlock()
+}
+
+// SetMaxMemoryClients sets the maximum number of bytes that all client
+// connection buffers may use together. When the limit is reached the clients
+// using the most memory are disconnected first, and the closed function
+// receives ErrClientEvicted. Use zero to disable this feature.
+func (s *Server) SetMaxMemoryClients(bytes int64) {
+	atomic.StoreInt64(&s.maxClientMem, bytes)
+}
+
+// broadcastTimeout is the time that each connection is given to accept a
+// broadcast message, so a client that is not reading can't stall the others.
+const broadcastTimeout = time.Second
+
+// Broadcast writes a message to every connection that is managed by the
+// server. The message is built by calling fn once for each protocol that is
+// in use by the connections, see Writer.Protocol. It is added to the output
+// buffer of each connection like a push message, so it is not interleaved
+// with the reply of a command, and it counts against the output buffer
+// limits. The connections are flushed concurrently, and a connection that
+// does not accept the message within one second is closed. Detached and
+// memcached connections are not included. Returns the number of connections that the
+// message was written to.
+func (s *Server) Broadcast(fn func(w *Writer)) int {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		if !c.memcache {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.Unlock()
+	msgs := make(map[resp.Protocol][]byte)
+	var sent int32
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		proto := c.wr.Protocol()
+		msg, ok := msgs[proto]
+		if !ok {
+			wr := Writer{proto: proto}
+			fn(&wr)
+			msg = wr.b
+			msgs[proto] = msg
+		}
+		wg.Add(1)
+		go func(c *conn) {
+			defer wg.Done()
+			if c.writeBroadcast(msg) {
+				atomic.AddInt32(&sent, 1)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return int(sent)
+}
+
+// writeBroadcast writes a broadcast message to the connection. The message
+// is held while a command is dispatched, and flushed by the server loop
+// with the reply. Returns false when the connection did not take the
+// message.
+func (c *conn) writeBroadcast(msg []byte) bool {
+	c.dmu.Lock()
+	if c.isDetached() || c.isClosed() {
+		c.dmu.Unlock()
+		return false
+	}
+	size := c.wr.buffered() + len(c.pushes) + len(msg)
+	if c.outputLimit.hard > 0 && size >= c.outputLimit.hard {
+		c.dmu.Unlock()
+		atomic.StoreInt32(&c.overLimit, 1)
+		c.conn.Close()
+		return false
+	}
+	if c.dispatching {
+		c.pushes = append(c.pushes, msg...)
+		c.dmu.Unlock()
+		return true
+	}
+	c.wr.WriteRaw(msg)
+	c.dmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(broadcastTimeout))
+	err := c.wr.Flush()
+	c.conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		c.conn.Close()
+		return false
+	}
+	atomic.StoreInt64(&c.outputSize, int64(c.wr.buffered()))
+	atomic.StoreInt64(&c.lastIO, time.Now().UnixNano())
+	return true
+}
+
+// ClientInfo is a point-in-time snapshot of a client connection, which is
+// useful for diagnosing misbehaving clients.
+type ClientInfo struct {
+	// ID is the unique identifier of the connection.
+	ID uint64
+	// Addr is the remote address of the client.
+	Addr string
+	// Name is the name that was set using SetName.
+	Name string
+	// Buffered is the number of bytes that have been read from the client
+	// but not yet parsed into a complete command.
+	Buffered int
+	// PartialArgs is the number of arguments that have been received for
+	// an incomplete command.
+	PartialArgs int
+	// ExpectedArgs is the number of arguments that the incomplete command
+	// expects, or zero when there's no incomplete command.
+	ExpectedArgs int
+	// LastCommand is the time that the last command was dispatched, or the
+	// zero time when no command has been dispatched.
+	LastCommand time.Time
+	// LastActivity is the time that data was last read from the client or
+	// replies were last flushed to the client.
+	LastActivity time.Time
+	// Commands is the number of commands that have been dispatched.
+	Commands uint64
+	// BytesRead is the number of bytes that have been read from the client.
+	BytesRead int64
+	// BytesWritten is the number of bytes that have been written to the
+	// client.
+	BytesWritten int64
+	// OutputBuffer is the size of the output buffer, which holds the
+	// replies that have not been written to the client yet. It's updated
+	// after each command and each flush by the server.
+	OutputBuffer int
+}
+
+// ClientList returns a snapshot of all connections that are managed by the
+// server, ordered by connection ID. Detached connections are not included.
+func (s *Server) ClientList() []ClientInfo {
+	s.mu.Lock()
+	infos := make([]ClientInfo, 0, len(s.conns))
+	for c := range s.conns {
+		infos = append(infos, c.info())
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+func (c *conn) info() ClientInfo {
+	info := ClientInfo{
+		ID:           c.id,
+		Addr:         c.addr,
+		Name:         c.Name(),
+		Buffered:     int(atomic.LoadInt64(&c.rd.buffered)),
+		PartialArgs:  int(atomic.LoadInt64(&c.rd.partial)),
+		ExpectedArgs: int(atomic.LoadInt64(&c.rd.expected)),
+		Commands:     atomic.LoadUint64(&c.numCmds),
+		BytesRead:    atomic.LoadInt64(&c.rd.read),
+		BytesWritten: atomic.LoadInt64(&c.wr.flushed),
+		OutputBuffer: int(atomic.LoadInt64(&c.outputSize)),
+	}
+	if last := atomic.LoadInt64(&c.lastCmd); last != 0 {
+		info.LastCommand = time.Unix(0, last)
+	}
+	if last := atomic.LoadInt64(&c.lastIO); last != 0 {
+		info.LastActivity = time.Unix(0, last)
+	}
+	return info
+}
+
+// PauseMode is the mode used for pausing a server.
+type PauseMode int
+
+const (
+	// PauseAll suspends the processing of all commands.
+	PauseAll PauseMode = iota
+	// PauseWrite suspends the processing of write commands only.
+	PauseWrite
+)
+
+// Pause suspends processing of client commands for the specified duration.
+// Incoming commands remain buffered and are dispatched to the handler when
+// the pause ends, or when Unpause is called. Commands that are already being
+// processed are not interrupted. This is equivalent to the Redis CLIENT PAUSE
+// command.
+func (s *Server) Pause(dur time.Duration, mode PauseMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unpaused == nil {
+		s.unpaused = make(chan struct{})
+	}
+	s.pauseEnd = time.Now().Add(dur)
+	s.pauseMode = mode
+	atomic.StoreInt32(&s.paused, 1)
+}
+
+// Unpause resumes the processing of commands that were suspended by Pause.
+func (s *Server) Unpause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unpaused != nil {
+		close(s.unpaused)
+		s.unpaused = nil
+	}
+	s.pauseEnd = time.Time{}
+	atomic.StoreInt32(&s.paused, 0)
+}
+
+// Stats are the counters of a server.
+type Stats struct {
+	// TotalConnections is the number of connections accepted by the server.
+	TotalConnections uint64
+	// RejectedConnections is the number of connections that were refused.
+	RejectedConnections uint64
+	// EvictedClients is the number of clients that were disconnected due to
+	// the client memory limit.
+	EvictedClients uint64
+	// TotalCommands is the number of commands processed by the server.
+	TotalCommands uint64
+	// ConnectedClients is the number of connections managed by the server.
+	ConnectedClients int
+	// ClientMemory is the number of bytes used by the client buffers.
+	ClientMemory int64
+}
+
+type serverStats struct {
+	totalConns     uint64
+	rejectedConns  uint64
+	evictedClients uint64
+	totalCmds      uint64
+}
+
+// Stats returns the current counters of the server.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	nconns := len(s.conns)
+	s.mu.Unlock()
+	return Stats{
+		TotalConnections:    atomic.LoadUint64(&s.stats.totalConns),
+		RejectedConnections: atomic.LoadUint64(&s.stats.rejectedConns),
+		EvictedClients:      atomic.LoadUint64(&s.stats.evictedClients),
+		TotalCommands:       atomic.LoadUint64(&s.stats.totalCmds),
+		ConnectedClients:    nconns,
+		ClientMemory:        atomic.LoadInt64(&s.clientMem),
+	}
+}
+
+// Drain puts the server into drain mode. New connections are refused, while
+// existing connections continue to be served. This allows for traffic to be
+// bled off gracefully, such as during a rolling restart, prior to calling
+// Close. Use SetDrainError to reply with an error to refused connections.
+func (s *Server) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+// Undrain takes the server out of drain mode.
+func (s *Server) Undrain() {
+	atomic.StoreInt32(&s.draining, 0)
+}
+
+// Draining returns true when the server is in drain mode.
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// SetDrainError sets the error message that is sent to connections that are
+// refused while the server is draining, such as "LOADING server is
+// restarting". Use an empty string to close connections without a reply.
+func (s *Server) SetDrainError(msg string) {
+	s.mu.Lock()
+	s.drainErr = msg
+	s.mu.Unlock()
+}
+
+// Watermark defines high and low thresholds for the depth of a queue. OnHigh
+// is called when the depth reaches High, and OnLow is called once the depth
+// subsequently drops to Low or below. This allows for applications to shed
+// load, alert, or degrade features before hard limits are reached.
+type Watermark struct {
+	High   int
+	Low    int
+	OnHigh func(conn Conn, depth int)
+	OnLow  func(conn Conn, depth int)
+}
+
+// check calls the watermark functions when depth crosses a threshold. The
+// high argument tracks whether the high watermark has been reached.
+func (wm *Watermark) check(conn Conn, high *bool, depth int) {
+	if wm == nil {
+		return
+	}
+	if !*high {
+		if wm.High > 0 && depth >= wm.High {
+			*high = true
+			if wm.OnHigh != nil {
+				wm.OnHigh(conn, depth)
+			}
+		}
+	} else if depth <= wm.Low {
+		*high = false
+		if wm.OnLow != nil {
+			wm.OnLow(conn, depth)
+		}
+	}
+}
+
+// SetOutputWatermark sets the watermark for the number of bytes in each
+// connection output buffer that are waiting to be written to the client.
+func (s *Server) SetOutputWatermark(wm Watermark) {
+	s.mu.Lock()
+	s.outputWM = &wm
+	s.mu.Unlock()
+}
+
+// SetPipelineWatermark sets the watermark for the number of pipelined
+// commands on each connection that are waiting to be processed.
+func (s *Server) SetPipelineWatermark(wm Watermark) {
+	s.mu.Lock()
+	s.pipelineWM = &wm
+	s.mu.Unlock()
+}
@@ -5,7 +5,10 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/big"
 	"math/rand"
 	"net"
 	"os"
@@ -14,6 +17,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/tidwall/redcon/resp"
 )
 
 // TestRandomCommands fills a bunch of random commands and test various
@@ -67,7 +72,7 @@ func TestRandomCommands(t *testing.T) {
 				switch arg[k] {
 				default:
 					narg = append(narg, arg[k])
-				case ' ':
+				case ' ', '\t', '\v', '\f', '\'':
 					quotes = true
 					narg = append(narg, arg[k])
 				case '\\', '"', '*':
@@ -512,6 +517,381 @@ func TestPlainReader(t *testing.T) {
 	}
 }
 
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+	}{
+		{`set key value`, []string{"set", "key", "value"}},
+		{" \tset  key\tvalue \t", []string{"set", "key", "value"}},
+		{`set "a b" 'c d'`, []string{"set", "a b", "c d"}},
+		{`set "" ''`, []string{"set", "", ""}},
+		{`"\x41\x6a\x7A" "\xZZ" "\x4"`, []string{"Ajz", "xZZ", "x4"}},
+		{`"\n\r\t\b\a\"\\\q"`, []string{"\n\r\t\b\a\"\\q"}},
+		{`'it\'s' 'a\nb' '"'`, []string{"it's", `a\nb`, `"`}},
+		{`key"a b" x'c d'`, []string{"keya b", "xc d"}},
+	}
+	for _, tt := range tests {
+		args, err := splitArgs([]byte(tt.line))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.line, err)
+		}
+		if len(args) != len(tt.args) {
+			t.Fatalf("%q: expected %q, got %q", tt.line, tt.args, args)
+		}
+		for i := range args {
+			if string(args[i]) != tt.args[i] {
+				t.Fatalf("%q: expected %q, got %q", tt.line, tt.args, args)
+			}
+		}
+	}
+	for _, line := range []string{`"a"b`, `"a""b"`, `"a"'b'`, `'a'b`, `"a`,
+		`'a`, `a "b`} {
+		if _, err := splitArgs([]byte(line)); err != errUnbalancedQuotes {
+			t.Fatalf("%q: expected %v, got %v", line, errUnbalancedQuotes, err)
+		}
+	}
+}
+
+func TestReadCommands(t *testing.T) {
+	rd := NewReader(strings.NewReader("ping\r\n*1\r\n$4\r\ntime\r\n" +
+		"get key\r\n"))
+	cmd, err := rd.ReadCommand()
+	if err != nil || string(cmd.Args[0]) != "ping" {
+		t.Fatalf("unexpected command %q, %v", cmd.Args, err)
+	}
+	cmds, err := rd.ReadCommands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 || string(cmds[0].Args[0]) != "time" ||
+		string(cmds[1].Args[1]) != "key" {
+		t.Fatalf("unexpected commands %v", cmds)
+	}
+	if _, err := rd.ReadCommands(); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	rd := NewReader(strings.NewReader("*3\r\n"))
+	rd.SetMaxMultiBulkLen(2)
+	if _, err := rd.ReadCommand(); err != errInvalidMultiBulkLength {
+		t.Fatalf("expected %v, got %v", errInvalidMultiBulkLength, err)
+	}
+	// the bulk length is checked before the data is read
+	rd = NewReader(strings.NewReader("*1\r\n$11\r\n"))
+	rd.SetMaxBulkLen(10)
+	if _, err := rd.ReadCommand(); err != errInvalidBulkLength {
+		t.Fatalf("expected %v, got %v", errInvalidBulkLength, err)
+	}
+	rd = NewReader(strings.NewReader("*2\r\n$10\r\n0123456789\r\n" +
+		"$1\r\na\r\n"))
+	rd.SetMaxMultiBulkLen(2)
+	rd.SetMaxBulkLen(10)
+	if cmd, err := rd.ReadCommand(); err != nil || len(cmd.Args) != 2 {
+		t.Fatalf("unexpected command %q, %v", cmd.Args, err)
+	}
+	_, err := Parse([]byte(fmt.Sprintf("*%d\r\n", DefaultMaxMultiBulkLen+1)))
+	if err != errInvalidMultiBulkLength {
+		t.Fatalf("expected %v, got %v", errInvalidMultiBulkLength, err)
+	}
+
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetMaxMultiBulkLen(1)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	exp := "-ERR Protocol error: invalid multibulk length\r\n"
+	if resp := testDo(t, c, "*2\r\n"); resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
+
+func TestEmptyMultiBulk(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString(string(cmd.Args[0]))
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resp := testDo(t, c, "*0\r\n*-1\r\n*1\r\n$4\r\nPING\r\n")
+	if resp != "+PING\r\n" {
+		t.Fatalf("expected %q, got %q", "+PING\r\n", resp)
+	}
+}
+
+func TestUnsafeNoCopy(t *testing.T) {
+	data := "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"
+	rd := NewReader(strings.NewReader(data))
+	cmd, err := rd.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &cmd.Raw[0] == &rd.buf[0] {
+		t.Fatal("expected a copy of the command")
+	}
+	rd = NewReader(strings.NewReader(data))
+	rd.SetUnsafeNoCopy(true)
+	cmd, err = rd.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &cmd.Raw[0] != &rd.buf[0] || string(cmd.Raw) != data {
+		t.Fatal("expected a slice of the read buffer")
+	}
+	if string(cmd.Args[0]) != "GET" || string(cmd.Args[1]) != "key" {
+		t.Fatalf("unexpected args %q", cmd.Args)
+	}
+
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteBulk(cmd.Args[1])
+	}, nil, nil)
+	s.SetUnsafeNoCopy(true)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resp := testDo(t, c, data+"*2\r\n$3\r\nGET\r\n$4\r\nkey2\r\n")
+	if resp != "$3\r\nkey\r\n$4\r\nkey2\r\n" {
+		t.Fatalf("unexpected reply %q", resp)
+	}
+}
+
+func TestStrictRESP(t *testing.T) {
+	rd := NewReader(strings.NewReader("PING\r\n"))
+	rd.SetStrictRESP(true)
+	_, err := rd.ReadCommand()
+	if err == nil || err.Error() != "Protocol error: expected '*', got 'P'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetStrictRESP(true)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "*1\r\n$4\r\nPING\r\n"); resp != "+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "+OK\r\n", resp)
+	}
+	exp := "-ERR Protocol error: expected '*', got 'P'\r\n"
+	if resp := testDo(t, c, "PING\r\n"); resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
+
+func TestProtoMaxBulkLen(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetProtoMaxBulkLen(10)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "*1\r\n$10\r\n0123456789\r\n"); resp != "+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "+OK\r\n", resp)
+	}
+	exp := "-ERR Protocol error: invalid bulk length\r\n"
+	if resp := testDo(t, c, "*1\r\n$11\r\n"); resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestReaderBuffer(t *testing.T) {
+	big := strings.Repeat("x", 100000)
+	rd := NewReader(io.MultiReader(
+		strings.NewReader("*1\r\n$100000\r\n"+big+"\r\n"),
+		strings.NewReader("*1\r\n$4\r\nPING\r\n")))
+	cmd, err := rd.ReadCommand()
+	if err != nil || string(cmd.Args[0]) != big {
+		t.Fatalf("unexpected command, %v", err)
+	}
+	if cap(rd.buf) < 100000 {
+		t.Fatalf("expected a grown buffer, got %d", cap(rd.buf))
+	}
+	cmd, err = rd.ReadCommand()
+	if err != nil || string(cmd.Args[0]) != "PING" {
+		t.Fatalf("unexpected command %q, %v", cmd.Args, err)
+	}
+	if cap(rd.buf) != readBufferLen {
+		t.Fatalf("expected %d, got %d", readBufferLen, cap(rd.buf))
+	}
+
+	rd = NewReader(strings.NewReader("*1\r\n$100000\r\n" + big + "\r\n"))
+	rd.SetMaxBufferLen(50000)
+	if _, err := rd.ReadCommand(); err != errQueryBufferLimit {
+		t.Fatalf("expected %v, got %v", errQueryBufferLimit, err)
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		s  string
+		n  int
+		ok bool
+	}{
+		{"0", 0, true},
+		{"7", 7, true},
+		{"123", 123, true},
+		{"-1", -1, true},
+		{"-123", -123, true},
+		{strconv.Itoa(math.MaxInt), math.MaxInt, true},
+		{strconv.Itoa(-math.MaxInt), -math.MaxInt, true},
+		{"", 0, false},
+		{"-", 0, false},
+		{"+1", 0, false},
+		{"1a", 0, false},
+		{"99999999999999999999", 0, false},
+		{"-99999999999999999999", 0, false},
+		{"18446744073709551617", 0, false},
+	}
+	for _, tt := range tests {
+		n, ok := parseInt([]byte(tt.s))
+		if n != tt.n || ok != tt.ok {
+			t.Fatalf("%q: expected %d %t, got %d %t", tt.s, tt.n, tt.ok, n, ok)
+		}
+	}
+	// a length that would wrap into a small number
+	_, err := Parse([]byte("*1\r\n$18446744073709551617\r\nA\r\n"))
+	if err != errInvalidBulkLength {
+		t.Fatalf("expected '%v', got '%v'", errInvalidBulkLength, err)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.SetProtocol(3)
+		conn.WriteMapStringString(map[string]string{"a": "1", "b": "2"})
+		conn.WriteBulkString(strings.Repeat("x", 10000))
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	wr := NewWriter(c)
+	rd := NewReader(c)
+	wr.WriteArray(1)
+	wr.WriteBulkString("GET")
+	if err := wr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := rd.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Type != resp.Map || len(v.Elems) != 4 ||
+		string(v.Elems[0].Data) != "a" || string(v.Elems[3].Data) != "2" {
+		t.Fatalf("unexpected reply %+v", v)
+	}
+	v, err = rd.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Type != resp.Bulk || string(v.Data) != strings.Repeat("x", 10000) {
+		t.Fatalf("unexpected reply %q", v.Data)
+	}
+	if cap(rd.buf) == readBufferLen {
+		t.Fatal("expected a grown buffer")
+	}
+	c.Close()
+	if _, err := rd.ReadReply(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if cap(rd.buf) != readBufferLen {
+		t.Fatalf("expected %d, got %d", readBufferLen, cap(rd.buf))
+	}
+}
+
+func TestMaxBatchSize(t *testing.T) {
+	rd := NewReader(strings.NewReader(strings.Repeat("*1\r\n$4\r\nPING\r\n", 4) +
+		"PING\r\n"))
+	rd.SetMaxBatchSize(2)
+	for _, exp := range []int{2, 2, 1} {
+		cmds, err := rd.ReadCommands()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cmds) != exp {
+			t.Fatalf("expected %d, got %d", exp, len(cmds))
+		}
+	}
+	if _, err := rd.ReadCommands(); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+
+	var batches []int
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("PONG")
+	}, nil, nil)
+	s.SetMaxBatchSize(128)
+	s.SetPipelineWatermark(Watermark{High: 129, Low: 1,
+		OnHigh: func(conn Conn, depth int) {
+			batches = append(batches, depth)
+		}})
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, strings.Repeat("PING\r\n", 300)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	exp := strings.Repeat("+PONG\r\n", 300)
+	buf := make([]byte, len(exp))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != exp {
+		t.Fatalf("unexpected replies %q", buf)
+	}
+	if len(batches) != 0 {
+		t.Fatalf("expected batches of at most 128 commands, got %v", batches)
+	}
+}
+
+type testUpperHandler struct {
+	next Handler
+}
+
+func (h testUpperHandler) ServeRESP(conn Conn, cmd Command) {
+	cmd.Args[0] = bytes.ToUpper(cmd.Args[0])
+	h.next.ServeRESP(conn, cmd)
+}
+
+func TestServerHandler(t *testing.T) {
+	h := testUpperHandler{HandlerFunc(func(conn Conn, cmd Command) {
+		conn.WriteString(string(cmd.Args[0]))
+	})}
+	s := NewServerHandler("", h, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "ping\r\n"); resp != "+PING\r\n" {
+		t.Fatalf("expected %q, got %q", "+PING\r\n", resp)
+	}
+}
+
 func TestParse(t *testing.T) {
 	_, err := Parse(nil)
 	if err != errIncompleteCommand {
@@ -521,11 +901,20 @@ func TestParse(t *testing.T) {
 	if err != errIncompleteCommand {
 		t.Fatalf("expected '%v', got '%v'", errIncompleteCommand, err)
 	}
+	// empty and null multibulks are skipped
 	_, err = Parse([]byte("*-1\r\n"))
-	if err != errInvalidMultiBulkLength {
-		t.Fatalf("expected '%v', got '%v'", errInvalidMultiBulkLength, err)
+	if err != errIncompleteCommand {
+		t.Fatalf("expected '%v', got '%v'", errIncompleteCommand, err)
 	}
 	_, err = Parse([]byte("*0\r\n"))
+	if err != errIncompleteCommand {
+		t.Fatalf("expected '%v', got '%v'", errIncompleteCommand, err)
+	}
+	_, err = Parse([]byte("*-2\r\n*0\r\n*1\r\n$1\r\nA\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Parse([]byte("*x\r\n"))
 	if err != errInvalidMultiBulkLength {
 		t.Fatalf("expected '%v', got '%v'", errInvalidMultiBulkLength, err)
 	}
@@ -738,3 +1127,1216 @@ func TestPubSub(t *testing.T) {
 	// stop the timeout
 	final <- true
 }
+
+// testServe serves s on a random local port and returns the address.
+func testServe(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	for !s.listening() {
+		time.Sleep(time.Millisecond)
+	}
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+// testDo sends a raw command and returns the raw reply.
+func testDo(t *testing.T, c net.Conn, cmd string) string {
+	t.Helper()
+	if _, err := io.WriteString(c, cmd); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 4096)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestLargeReply(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteBulkString(strings.Repeat("x", len(cmd.Args[0])*10))
+	}, nil, nil)
+	s.SetLargeReplyThreshold(100)
+	var mu sync.Mutex
+	var reported []string
+	s.LargeReply = func(conn Conn, cmd Command, size int) {
+		mu.Lock()
+		reported = append(reported, fmt.Sprintf("%s:%d", cmd.Args[0], size))
+		mu.Unlock()
+	}
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testDo(t, c, "small\r\n")
+	testDo(t, c, "abcdefghijklmn\r\n")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 1 || reported[0] != "abcdefghijklmn:148" {
+		t.Fatalf("unexpected reports: %v", reported)
+	}
+}
+
+func TestClientEviction(t *testing.T) {
+	evicted := make(chan string, 2)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		if string(cmd.Args[0]) == "noevict" {
+			conn.SetNoEvict(true)
+		}
+		conn.WriteString(string(cmd.Args[0]))
+	}, nil, func(conn Conn, err error) {
+		if err == ErrClientEvicted {
+			evicted <- conn.RemoteAddr()
+		}
+	})
+	s.SetMaxMemoryClients(20000)
+	addr := testServe(t, s)
+	c1, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	testDo(t, c1, "noevict\r\n")
+	testDo(t, c2, "ping\r\n")
+	big := "*1\r\n$20000\r\n" + strings.Repeat("x", 20000) + "\r\n"
+	if _, err := io.WriteString(c1, big); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-evicted:
+		if addr != c2.LocalAddr().String() {
+			t.Fatalf("expected %v to be evicted, got %v", c2.LocalAddr(), addr)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	addr := testServe(t, s)
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		testDo(t, c, "ping\r\n")
+		conns = append(conns, c)
+	}
+	n := s.Broadcast(func(w *Writer) {
+		w.WriteArray(2)
+		w.WriteBulkString("message")
+		w.WriteBulkString("hello")
+	})
+	if n != 3 {
+		t.Fatalf("expected %v, got %v", 3, n)
+	}
+	for _, c := range conns {
+		buf := make([]byte, 64)
+		n, err := io.ReadAtLeast(c, buf, 27)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := "*2\r\n$7\r\nmessage\r\n$5\r\nhello\r\n"; string(buf[:n]) != exp {
+			t.Fatalf("expected %q, got %q", exp, buf[:n])
+		}
+	}
+}
+
+func TestBroadcastSlowClient(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	addr := testServe(t, s)
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		testDo(t, c, "ping\r\n")
+		conns = append(conns, c)
+	}
+	// the first client never reads, and the message is larger than the
+	// socket buffers, so its write can't complete.
+	msg := strings.Repeat("x", 64*1024*1024)
+	exp := int64(len(AppendBulkString(nil, msg)))
+	errs := make(chan error, 2)
+	for _, c := range conns[1:] {
+		go func(c net.Conn) {
+			c.SetReadDeadline(time.Now().Add(time.Second * 10))
+			n, err := io.CopyN(io.Discard, c, exp)
+			if err == nil && n != exp {
+				err = fmt.Errorf("expected %v bytes, got %v", exp, n)
+			}
+			errs <- err
+		}(c)
+	}
+	done := make(chan int)
+	go func() {
+		done <- s.Broadcast(func(w *Writer) { w.WriteBulkString(msg) })
+	}()
+	select {
+	case n := <-done:
+		if n != 2 {
+			t.Fatalf("expected %v, got %v", 2, n)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the slow client was disconnected
+	conns[0].SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.Copy(io.Discard, conns[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientListPartial(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testDo(t, c, "ping\r\n")
+	partial := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$10\r\nab"
+	io.WriteString(c, partial)
+	start := time.Now()
+	for {
+		infos := s.ClientList()
+		if len(infos) != 1 {
+			t.Fatalf("expected %v, got %v", 1, len(infos))
+		}
+		info := infos[0]
+		if info.Buffered == len(partial) {
+			if info.PartialArgs != 2 || info.ExpectedArgs != 3 {
+				t.Fatalf("unexpected progress: %v/%v",
+					info.PartialArgs, info.ExpectedArgs)
+			}
+			if info.LastCommand.IsZero() {
+				t.Fatal("expected last command time")
+			}
+			break
+		}
+		if time.Since(start) > time.Second*5 {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestPause(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString(string(cmd.Args[0]))
+	}, nil, nil)
+	s.WriteCommand = func(cmd Command) bool {
+		return string(cmd.Args[0]) == "set"
+	}
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s.Pause(time.Hour, PauseWrite)
+	if res := testDo(t, c, "get\r\n"); res != "+get\r\n" {
+		t.Fatalf("expected %q, got %q", "+get\r\n", res)
+	}
+	io.WriteString(c, "set\r\n")
+	c.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	if _, err := c.Read(make([]byte, 64)); err == nil {
+		t.Fatal("expected timeout")
+	}
+	s.Unpause()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "+set\r\n" {
+		t.Fatalf("expected %q, got %q", "+set\r\n", buf[:n])
+	}
+	s.Pause(time.Millisecond*50, PauseAll)
+	start := time.Now()
+	testDo(t, c, "get\r\n")
+	if time.Since(start) < time.Millisecond*40 {
+		t.Fatal("expected command to be paused")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, nil, nil)
+	addr := testServe(t, s)
+	c1, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	testDo(t, c1, "ping\r\n")
+	s.SetDrainError("LOADING server is draining")
+	s.Drain()
+	if !s.Draining() {
+		t.Fatal("expected draining")
+	}
+	c2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	res, err := ioutil.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "-LOADING server is draining\r\n" {
+		t.Fatalf("unexpected reply %q", res)
+	}
+	if res := testDo(t, c1, "ping\r\n"); res != "+OK\r\n" {
+		t.Fatalf("unexpected reply %q", res)
+	}
+	s.Undrain()
+	c3, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+	if res := testDo(t, c3, "ping\r\n"); res != "+OK\r\n" {
+		t.Fatalf("unexpected reply %q", res)
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	event := func(name string) func(conn Conn, depth int) {
+		return func(conn Conn, depth int) {
+			mu.Lock()
+			events = append(events, fmt.Sprintf("%s:%d", name, depth))
+			mu.Unlock()
+		}
+	}
+	s := NewServer("", func(conn Conn, cmd Command) {
+		if string(cmd.Args[0]) == "big" {
+			conn.WriteBulkString(strings.Repeat("x", 200))
+		}
+	}, nil, nil)
+	s.SetPipelineWatermark(Watermark{High: 3, Low: 1,
+		OnHigh: event("pipeline-high"), OnLow: event("pipeline-low")})
+	s.SetOutputWatermark(Watermark{High: 100,
+		OnHigh: event("output-high"), OnLow: event("output-low")})
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	res := testDo(t, c, "a\r\nb\r\nc\r\nd\r\nbig\r\n")
+	if len(res) != 208 {
+		t.Fatalf("expected %v, got %v", 208, len(res))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	exp := "pipeline-high:5 pipeline-low:1 output-high:208 output-low:0"
+	if strings.Join(events, " ") != exp {
+		t.Fatalf("expected %q, got %q", exp, strings.Join(events, " "))
+	}
+}
+
+func TestConnID(t *testing.T) {
+	ids := make(chan uint64, 2)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteInt64(int64(conn.ID()))
+	}, func(conn Conn) bool {
+		ids <- conn.ID()
+		return true
+	}, nil)
+	addr := testServe(t, s)
+	for i := 1; i <= 2; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if res := testDo(t, c, "id\r\n"); res != fmt.Sprintf(":%d\r\n", i) {
+			t.Fatalf("expected id %d, got %q", i, res)
+		}
+		if id := <-ids; id != uint64(i) {
+			t.Fatalf("expected id %d, got %d", i, id)
+		}
+	}
+	infos := s.ClientList()
+	if len(infos) != 2 || infos[0].ID != 1 || infos[1].ID != 2 {
+		t.Fatalf("unexpected client list: %v", infos)
+	}
+}
+
+func TestDetachConcurrent(t *testing.T) {
+	accepted := make(chan Conn, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteString("OK")
+	}, func(conn Conn) bool {
+		accepted <- conn
+		return true
+	}, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sconn := <-accepted
+	testDo(t, c, "ping\r\n")
+	// send a partial command that must be handed over to the detached conn,
+	// whether or not the server loop has read it yet
+	io.WriteString(c, "*2\r\n$4\r\necho\r\n")
+	dconn := sconn.Detach()
+	defer dconn.Close()
+	if sconn.Detach() != dconn {
+		t.Fatal("expected the same detached conn")
+	}
+	io.WriteString(c, "$5\r\nhello\r\n")
+	cmd, err := dconn.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd.Args) != 2 || string(cmd.Args[1]) != "hello" {
+		t.Fatalf("unexpected command %q", cmd.Args)
+	}
+	dconn.WriteBulk(cmd.Args[1])
+	if err := dconn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "$5\r\nhello\r\n" {
+		t.Fatalf("unexpected reply %q", buf[:n])
+	}
+	if len(s.ClientList()) != 0 {
+		t.Fatal("expected no managed clients")
+	}
+}
+
+func TestDetachPipeline(t *testing.T) {
+	// detach from another goroutine while a handler of a pipeline is
+	// running, which must be run with -race
+	accepted := make(chan Conn, 1)
+	started := make(chan bool)
+	unblock := make(chan bool)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		if string(cmd.Args[0]) == "block" {
+			started <- true
+			<-unblock
+		}
+		conn.WriteString(string(cmd.Args[0]))
+	}, func(conn Conn) bool {
+		accepted <- conn
+		return true
+	}, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sconn := <-accepted
+	io.WriteString(c, "block\r\nfoo\r\nbar\r\n")
+	<-started
+	detached := make(chan DetachedConn)
+	go func() { detached <- sconn.Detach() }()
+	dconn := <-detached
+	defer dconn.Close()
+	close(unblock)
+	// the rest of the pipeline is handed over once the handler returns
+	for _, exp := range []string{"foo", "bar"} {
+		cmd, err := dconn.ReadCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(cmd.Args[0]) != exp {
+			t.Fatalf("expected %q, got %q", exp, cmd.Args[0])
+		}
+		dconn.WriteString("DETACHED " + exp)
+	}
+	if err := dconn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	exp := "+block\r\n+DETACHED foo\r\n+DETACHED bar\r\n"
+	var resp string
+	for len(resp) < len(exp) {
+		resp += testDo(t, c, "")
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
+
+func TestNetConn(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		tcp, ok := conn.NetConn().(*net.TCPConn)
+		if !ok {
+			conn.WriteError("ERR not a tcp connection")
+			return
+		}
+		if err := tcp.SetNoDelay(true); err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteString(tcp.RemoteAddr().String())
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if res := testDo(t, c, "addr\r\n"); res != "+"+c.LocalAddr().String()+"\r\n" {
+		t.Fatalf("unexpected reply %q", res)
+	}
+}
+
+func TestDetachRestore(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "detour":
+			dconn := conn.Detach()
+			go func() {
+				cmd, err := dconn.ReadCommand()
+				if err != nil {
+					dconn.Close()
+					return
+				}
+				dconn.WriteString("DETOUR " + string(cmd.Args[0]))
+				dconn.Flush()
+				if err := dconn.Restore(); err != nil {
+					dconn.Close()
+				}
+			}()
+		default:
+			conn.WriteString(string(cmd.Args[0]))
+		}
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	rd := bufio.NewReader(c)
+	io.WriteString(c, "detour\r\nfoo\r\nbar\r\n")
+	for _, exp := range []string{"+DETOUR foo\r\n", "+bar\r\n"} {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != exp {
+			t.Fatalf("expected %q, got %q", exp, line)
+		}
+	}
+	if len(s.ClientList()) != 1 {
+		t.Fatal("expected a managed client")
+	}
+	io.WriteString(c, "baz\r\n")
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "+baz\r\n" {
+		t.Fatalf("expected %q, got %q", "+baz\r\n", line)
+	}
+}
+
+func TestDetachRestoreAdmission(t *testing.T) {
+	closed := make(chan error, 4)
+	restored := make(chan error, 4)
+	var s *Server
+	s = NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "detour":
+			dconn := conn.Detach()
+			go func() {
+				// wait for the release
+				if _, err := dconn.ReadCommand(); err != nil {
+					dconn.Close()
+					return
+				}
+				s.Drain()
+				restored <- dconn.Restore()
+				s.Undrain()
+				s.SetMaxClients(1)
+				restored <- dconn.Restore()
+				s.SetMaxClients(0)
+				restored <- dconn.Restore()
+			}()
+		default:
+			conn.WriteString(string(cmd.Args[0]))
+		}
+	}, nil, func(conn Conn, err error) {
+		closed <- err
+	})
+	addr := testServe(t, s)
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	testDo(t, other, "ping\r\n")
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "detour\r\nfoo\r\n")
+	if err := <-closed; err != errDetached {
+		t.Fatalf("expected %v, got %v", errDetached, err)
+	}
+	for _, exp := range []string{"server draining",
+		"max number of clients reached", ""} {
+		err := <-restored
+		if (err == nil && exp != "") || (err != nil && err.Error() != exp) {
+			t.Fatalf("expected %q, got %v", exp, err)
+		}
+	}
+	if resp := testDo(t, c, "bar\r\n"); resp != "+bar\r\n" {
+		t.Fatalf("expected %q, got %q", "+bar\r\n", resp)
+	}
+	// the restored connection is reported to closed again
+	c.Close()
+	if err := <-closed; err != nil {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	const N, M = 8, 100
+	s := NewServer("", func(conn Conn, cmd Command) {
+		var wg sync.WaitGroup
+		for i := 0; i < N; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < M; j++ {
+					if j%2 == 0 {
+						conn.WriteBulkString(fmt.Sprintf("bulk %d", i))
+					} else {
+						b := AppendArray(nil, 2)
+						b = AppendBulkString(b, "array")
+						b = AppendInt(b, int64(i))
+						conn.WriteRaw(b)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		conn.WriteString("DONE")
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	io.WriteString(c, "go\r\n")
+	var data []byte
+	var count int
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+		for {
+			n, resp := ReadNextRESP(data)
+			if n == 0 {
+				break
+			}
+			data = data[n:]
+			switch resp.Type {
+			case String:
+				if count != N*M {
+					t.Fatalf("expected %d frames, got %d", N*M, count)
+				}
+				return
+			case Bulk:
+				if !strings.HasPrefix(string(resp.Data), "bulk ") {
+					t.Fatalf("unexpected bulk %q", resp.Data)
+				}
+			case Array:
+				if resp.Count != 2 {
+					t.Fatalf("expected 2, got %d", resp.Count)
+				}
+			default:
+				t.Fatalf("unexpected frame %q", resp.Raw)
+			}
+			count++
+		}
+	}
+}
+
+func TestConnFlush(t *testing.T) {
+	next := make(chan bool)
+	sizes := make(chan int, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteArray(2)
+		if err := conn.Flush(); err != nil {
+			return
+		}
+		<-next
+		conn.WriteBulkString("hello")
+		conn.Flush()
+		conn.WriteBulkString("world")
+	}, nil, nil)
+	s.SetLargeReplyThreshold(1)
+	s.LargeReply = func(conn Conn, cmd Command, size int) {
+		sizes <- size
+	}
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	rd := bufio.NewReader(c)
+	io.WriteString(c, "stream\r\n")
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "*2\r\n" {
+		t.Fatalf("expected %q, got %q", "*2\r\n", line)
+	}
+	next <- true
+	exp := "$5\r\nhello\r\n$5\r\nworld\r\n"
+	data := make([]byte, len(exp))
+	if _, err := io.ReadFull(rd, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != exp {
+		t.Fatalf("expected %q, got %q", exp, data)
+	}
+	if size := <-sizes; size != 26 {
+		t.Fatalf("expected %d, got %d", 26, size)
+	}
+}
+
+func TestCloseAfterFlush(t *testing.T) {
+	var mu sync.Mutex
+	var cmds []string
+	s := NewServer("", func(conn Conn, cmd Command) {
+		mu.Lock()
+		cmds = append(cmds, string(cmd.Args[0]))
+		mu.Unlock()
+		if string(cmd.Args[0]) == "quit" {
+			conn.CloseAfterFlush()
+		}
+		conn.WriteString("OK")
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	io.WriteString(c, "ping\r\nquit\r\nping\r\n")
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "+OK\r\n+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "+OK\r\n+OK\r\n", data)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(cmds, ",") != "ping,quit" {
+		t.Fatalf("unexpected commands: %v", cmds)
+	}
+}
+
+func TestClosedChan(t *testing.T) {
+	stopped := make(chan bool, 2)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "subscribe":
+			go func() {
+				<-conn.ClosedChan()
+				stopped <- true
+			}()
+			conn.WriteString("OK")
+		case "detach":
+			dconn := conn.Detach()
+			go func() {
+				defer dconn.Close()
+				for {
+					if _, err := dconn.ReadCommand(); err != nil {
+						break
+					}
+				}
+				select {
+				case <-dconn.ClosedChan():
+					stopped <- true
+				default:
+					stopped <- false
+				}
+			}()
+		}
+	}, nil, nil)
+	addr := testServe(t, s)
+	for _, cmd := range []string{"subscribe\r\n", "detach\r\n"} {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(c, cmd)
+		if cmd == "subscribe\r\n" {
+			testDo(t, c, "")
+		}
+		c.Close()
+		select {
+		case ok := <-stopped:
+			if !ok {
+				t.Fatalf("%q: expected closed chan", cmd)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%q: timeout", cmd)
+		}
+	}
+}
+
+func TestDetachedConnDeadlines(t *testing.T) {
+	errs := make(chan error, 1)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		dconn := conn.Detach()
+		go func() {
+			defer dconn.Close()
+			errs <- func() error {
+				// a timed out read leaves the connection usable
+				dconn.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+				_, err := dconn.ReadCommand()
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					return fmt.Errorf("expected timeout, got %v", err)
+				}
+				dconn.WriteString("TIMEOUT")
+				dconn.Flush()
+				dconn.SetDeadline(time.Time{})
+				cmd, err := dconn.ReadCommand()
+				if err != nil {
+					return err
+				}
+				if string(cmd.Args[0]) != "raw" {
+					return fmt.Errorf("expected %q, got %q", "raw", cmd.Args[0])
+				}
+				// switch to reading raw bytes from the net.Conn
+				data := dconn.ReadBuffered()
+				exp := "*1\r\n$4\r\nping\r\nabc"
+				for len(data) < len(exp) {
+					buf := make([]byte, len(exp)-len(data))
+					n, err := dconn.NetConn().Read(buf)
+					if err != nil {
+						return err
+					}
+					data = append(data, buf[:n]...)
+				}
+				if string(data) != exp {
+					return fmt.Errorf("expected %q, got %q", exp, data)
+				}
+				return nil
+			}()
+		}()
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "detach\r\n")
+	if resp := testDo(t, c, ""); resp != "+TIMEOUT\r\n" {
+		t.Fatalf("expected %q, got %q", "+TIMEOUT\r\n", resp)
+	}
+	io.WriteString(c, "raw\r\nping\r\nabc")
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "i/o timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+// testStallWriter accepts at most n bytes before failing with err.
+type testStallWriter struct {
+	bytes.Buffer
+	n   int
+	err error
+}
+
+func (w *testStallWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n, _ := w.Buffer.Write(p[:w.n])
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return w.Buffer.Write(p)
+}
+
+func TestWriterErr(t *testing.T) {
+	out := &testStallWriter{n: 4, err: testTimeoutError{}}
+	wr := NewWriter(out)
+	wr.WriteBulkString("hello")
+	if err := wr.Flush(); err == nil {
+		t.Fatal("expected error")
+	}
+	if wr.Err() == nil {
+		t.Fatal("expected error")
+	}
+	out.n = 100
+	if err := wr.Flush(); err != wr.Err() {
+		t.Fatalf("expected %v, got %v", wr.Err(), err)
+	}
+	if out.String() != "$5\r\n" {
+		t.Fatalf("expected %q, got %q", "$5\r\n", out.String())
+	}
+	if wr.ClearErr() {
+		t.Fatal("expected error to not be cleared")
+	}
+	wr.SetRecoverTimeouts(true)
+	if !wr.ClearErr() {
+		t.Fatal("expected error to be cleared")
+	}
+	wr.WriteString("OK")
+	if err := wr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "$5\r\nhello\r\n+OK\r\n" {
+		t.Fatalf("expected %q, got %q", "$5\r\nhello\r\n+OK\r\n", out.String())
+	}
+
+	// only timeouts can be recovered
+	out = &testStallWriter{err: io.ErrClosedPipe}
+	wr = NewWriter(out)
+	wr.SetRecoverTimeouts(true)
+	wr.WriteString("OK")
+	if err := wr.Flush(); err != io.ErrClosedPipe {
+		t.Fatalf("expected %v, got %v", io.ErrClosedPipe, err)
+	}
+	if wr.ClearErr() {
+		t.Fatal("expected error to not be cleared")
+	}
+}
+
+func TestConnName(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch strings.ToLower(string(cmd.Args[0])) {
+		case "setname":
+			conn.SetName(string(cmd.Args[1]))
+			conn.WriteString("OK")
+		case "getname":
+			if name := conn.Name(); name == "" {
+				conn.WriteNull()
+			} else {
+				conn.WriteBulkString(name)
+			}
+		}
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDo(t, c, "getname\r\n"); resp != "$-1\r\n" {
+		t.Fatalf("expected %q, got %q", "$-1\r\n", resp)
+	}
+	testDo(t, c, "setname worker-1\r\n")
+	if resp := testDo(t, c, "getname\r\n"); resp != "$8\r\nworker-1\r\n" {
+		t.Fatalf("expected %q, got %q", "$8\r\nworker-1\r\n", resp)
+	}
+	clients := s.ClientList()
+	if len(clients) != 1 || clients[0].Name != "worker-1" {
+		t.Fatalf("unexpected client list: %v", clients)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		info := conn.Info()
+		conn.WriteArray(4)
+		conn.WriteInt64(int64(info.Commands))
+		conn.WriteInt64(info.BytesRead)
+		conn.WriteInt64(info.BytesWritten)
+		conn.WriteInt(info.OutputBuffer)
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	testDo(t, c, "info\r\n")
+	exp := "*4\r\n:2\r\n:12\r\n:20\r\n:0\r\n"
+	if resp := testDo(t, c, "info\r\n"); resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+	for i := 0; ; i++ {
+		clients := s.ClientList()
+		if len(clients) != 1 {
+			t.Fatalf("unexpected client list: %v", clients)
+		}
+		info := clients[0]
+		if info.BytesWritten == 42 {
+			if info.Commands != 2 || info.BytesRead != 12 ||
+				info.OutputBuffer != 0 || info.LastActivity.Before(start) {
+				t.Fatalf("unexpected client info: %+v", info)
+			}
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("unexpected client info: %+v", info)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteBulkArray(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteBulkOrNull([]byte("a"), true)
+	wr.WriteBulkOrNull(nil, false)
+	wr.WriteBulkArray([][]byte{[]byte("x"), nil})
+	wr.Flush()
+	exp := "$1\r\na\r\n$-1\r\n*2\r\n$1\r\nx\r\n$-1\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteIntegers(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteInt(-1)
+	wr.WriteInt64(math.MinInt64)
+	wr.WriteUint64(math.MaxInt64)
+	wr.WriteUint64(math.MaxUint64)
+	wr.Flush()
+	exp := ":-1\r\n:-9223372036854775808\r\n:9223372036854775807\r\n" +
+		"$20\r\n18446744073709551615\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteMap(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	m := map[string]string{"b": "2", "a": "1"}
+	wr.WriteMapStringString(m)
+	wr.SetProtocol(3)
+	wr.WriteMapStringString(m)
+	wr.WriteMap(0)
+	wr.Flush()
+	exp := "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+		"%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n%0\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteSet(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteSet(1)
+	wr.WriteBulkString("a")
+	wr.SetProtocol(3)
+	wr.WriteSet(1)
+	wr.WriteBulkString("a")
+	wr.Flush()
+	exp := "*1\r\n$1\r\na\r\n~1\r\n$1\r\na\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteAttribute(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	attr := func(w *Writer) {
+		w.WriteString("ttl")
+		w.WriteInt(3600)
+	}
+	wr.WriteAttribute(1, attr)
+	wr.WriteBulkString("a")
+	wr.SetProtocol(3)
+	wr.WriteAttribute(1, attr)
+	wr.WriteBulkString("a")
+	wr.Flush()
+	exp := "$1\r\na\r\n|1\r\n+ttl\r\n:3600\r\n$1\r\na\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteBool(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteBool(true)
+	wr.WriteBool(false)
+	wr.SetProtocol(3)
+	wr.WriteBool(true)
+	wr.WriteBool(false)
+	wr.Flush()
+	exp := ":1\r\n:0\r\n#t\r\n#f\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteBigNumber(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	num, _ := new(big.Int).SetString("-12345678901234567890", 10)
+	wr.WriteBigNumber(num)
+	wr.SetProtocol(3)
+	wr.WriteBigNumber(num)
+	wr.WriteBigNumberString("98765432109876543210")
+	wr.WriteBigNumber(nil)
+	wr.Flush()
+	exp := "$21\r\n-12345678901234567890\r\n(-12345678901234567890\r\n" +
+		"(98765432109876543210\r\n_\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteNull(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteNullArray()
+	wr.WriteNull()
+	wr.WriteBulkOrNull(nil, false)
+	wr.SetProtocol(3)
+	wr.WriteNullArray()
+	wr.WriteNull()
+	wr.WriteNullBulk()
+	wr.WriteBulkArray([][]byte{nil})
+	wr.Flush()
+	exp := "*-1\r\n$-1\r\n$-1\r\n_\r\n_\r\n$-1\r\n*1\r\n_\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteStringNewlines(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteString("OK")
+	wr.WriteString("line 1\r\nline 2")
+	wr.WriteString("\n")
+	wr.Flush()
+	exp := "+OK\r\n$14\r\nline 1\r\nline 2\r\n$1\r\n\n\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteFloat(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteFloat(1.5)
+	wr.WriteFloat(math.Inf(-1))
+	if wr.Protocol() != 2 {
+		t.Fatalf("expected 2, got %d", wr.Protocol())
+	}
+	wr.proto = 3
+	wr.WriteFloat(0.1)
+	wr.WriteFloat(math.Inf(1))
+	wr.Flush()
+	exp := "$3\r\n1.5\r\n$4\r\n-inf\r\n,0.1\r\n,inf\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
+
+func TestWriteBulkFrom(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	wr.WriteArray(2)
+	if err := wr.WriteBulkFrom(strings.NewReader("hello world"), 5); err != nil {
+		t.Fatal(err)
+	}
+	wr.WriteBulkString("x")
+	wr.Flush()
+	exp := "*2\r\n$5\r\nhello\r\n$1\r\nx\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+
+	// a short reader leaves the stream incomplete
+	out.Reset()
+	err := wr.WriteBulkFrom(strings.NewReader("abc"), 5)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if wr.Err() != err || wr.Flush() != err {
+		t.Fatal("expected error to be recorded")
+	}
+
+	// large values are streamed through a server connection
+	const size = 10 << 20
+	s := NewServer("", func(conn Conn, cmd Command) {
+		conn.WriteBulkFrom(io.LimitReader(zeroReader{}, size), size)
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second * 10))
+	io.WriteString(c, "get\r\n")
+	total := int64(size + len("$10485760\r\n\r\n"))
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(c, total))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != total {
+		t.Fatalf("expected %d, got %d", total, n)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
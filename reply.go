@@ -0,0 +1,133 @@
+package redcon
+
+import (
+	"time"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+// WriteErr writes an error reply for err. The message is prefixed with
+// "ERR " when it does not start with an error code, which is an uppercase
+// word such as WRONGTYPE. Like all error replies, CR and LF characters in the
+// message are replaced with spaces.
+//
+//   redcon.WriteErr(conn, errors.New("invalid key"))  // -ERR invalid key
+//   redcon.WriteErr(conn, errors.New("NOPERM denied")) // -NOPERM denied
+func WriteErr(conn Conn, err error) {
+	conn.WriteError(resp.PrefixError(err.Error()))
+}
+
+// WriteUnknownCommand writes the error reply of Redis for a command that is
+// not known, which includes the first arguments of the command:
+//
+//   -ERR unknown command 'foo', with args beginning with: 'a' 'b'
+//
+// It's the default reply of ServeMux for unregistered commands.
+func WriteUnknownCommand(conn Conn, cmd Command) {
+	var name []byte
+	if len(cmd.Args) > 0 {
+		name = cmd.Args[0]
+	}
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	var args []byte
+	for i := 1; i < len(cmd.Args) && len(args) < 128; i++ {
+		arg := cmd.Args[i]
+		if len(arg) > 128-len(args) {
+			arg = arg[:128-len(args)]
+		}
+		args = append(args, '\'')
+		args = append(args, arg...)
+		args = append(args, '\'', ' ')
+	}
+	conn.WriteError("ERR unknown command '" + string(name) +
+		"', with args beginning with: " + string(args))
+}
+
+// WriteTime writes a TIME style reply to the client, which is an array of
+// the unix time in seconds and the microseconds elapsed in the current
+// second.
+func WriteTime(conn Conn, t time.Time) {
+	conn.WriteRaw(resp.AppendTime(nil, t))
+}
+
+// WriteUnix writes the unix time in seconds to the client as an integer,
+// such as the reply of EXPIRETIME.
+func WriteUnix(conn Conn, t time.Time) {
+	conn.WriteRaw(resp.AppendUnix(nil, t))
+}
+
+// WriteUnixMilli writes the unix time in milliseconds to the client as an
+// integer, such as the reply of PEXPIRETIME.
+func WriteUnixMilli(conn Conn, t time.Time) {
+	conn.WriteRaw(resp.AppendUnixMilli(nil, t))
+}
+
+// TTLStatus is the state of the time to live of a key.
+type TTLStatus = resp.TTLStatus
+
+const (
+	// TTLExpires is for a key that has a time to live.
+	TTLExpires = resp.TTLExpires
+	// TTLPersistent is for a key that exists but has no time to live,
+	// which is replied as -1.
+	TTLPersistent = resp.TTLPersistent
+	// TTLMissing is for a key that does not exist, which is replied as -2.
+	TTLMissing = resp.TTLMissing
+)
+
+// WriteTTL writes a TTL style reply in seconds to the client. The time to
+// live is only used with TTLExpires, and is rounded to the nearest second.
+//
+//   switch item, ok := db[key]; {
+//   case !ok:
+//       redcon.WriteTTL(conn, redcon.TTLMissing, 0)
+//   case item.expires.IsZero():
+//       redcon.WriteTTL(conn, redcon.TTLPersistent, 0)
+//   default:
+//       redcon.WriteTTL(conn, redcon.TTLExpires, time.Until(item.expires))
+//   }
+func WriteTTL(conn Conn, status TTLStatus, ttl time.Duration) {
+	conn.WriteRaw(resp.AppendTTL(nil, status, ttl))
+}
+
+// WritePTTL writes a PTTL style reply in milliseconds to the client. The
+// time to live is only used with TTLExpires.
+func WritePTTL(conn Conn, status TTLStatus, ttl time.Duration) {
+	conn.WriteRaw(resp.AppendPTTL(nil, status, ttl))
+}
+
+// ScoredMember is a member of a sorted set and its score.
+type ScoredMember = resp.ScoredMember
+
+// WriteScoredMembers writes a sorted set reply that includes the scores,
+// such as ZRANGE with WITHSCORES. For RESP2 it's a flat array where each
+// member is followed by its score, and for RESP3 it's an array of member and
+// score pairs.
+func WriteScoredMembers(conn Conn, members []ScoredMember) {
+	conn.WriteRaw(resp.AppendScoredMembers(nil,
+		resp.Protocol(conn.Protocol()), members))
+}
+
+// GeoLocation is a member of a geospatial index, as returned by GEOSEARCH.
+type GeoLocation = resp.GeoLocation
+
+// GeoFlags select the optional fields of GEOSEARCH style replies.
+type GeoFlags = resp.GeoFlags
+
+const (
+	// GeoWithDist includes the distance, for the WITHDIST option.
+	GeoWithDist = resp.GeoWithDist
+	// GeoWithHash includes the geohash, for the WITHHASH option.
+	GeoWithHash = resp.GeoWithHash
+	// GeoWithCoord includes the coordinates, for the WITHCOORD option.
+	GeoWithCoord = resp.GeoWithCoord
+)
+
+// WriteGeoLocations writes a GEOSEARCH or GEORADIUS style reply, with the
+// optional fields that are selected by flags.
+func WriteGeoLocations(conn Conn, locs []GeoLocation, flags GeoFlags) {
+	conn.WriteRaw(resp.AppendGeoLocations(nil,
+		resp.Protocol(conn.Protocol()), locs, flags))
+}
@@ -0,0 +1,118 @@
+package redcon
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeReplies(t *testing.T) {
+	tm := time.Unix(1700000000, 123456789)
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "time":
+			WriteTime(conn, tm)
+		case "expiretime":
+			WriteUnix(conn, tm)
+		case "pexpiretime":
+			WriteUnixMilli(conn, tm)
+		case "ttl":
+			WriteTTL(conn, TTLExpires, time.Millisecond*1500)
+		case "pttl":
+			WritePTTL(conn, TTLMissing, 0)
+		}
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"time", "*2\r\n$10\r\n1700000000\r\n$6\r\n123456\r\n"},
+		{"expiretime", ":1700000000\r\n"},
+		{"pexpiretime", ":1700000000123\r\n"},
+		{"ttl", ":2\r\n"},
+		{"pttl", ":-2\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%s: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
+
+func TestScoredReplies(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "zrange":
+			WriteScoredMembers(conn, []ScoredMember{
+				{Member: []byte("a"), Score: 1.5},
+			})
+		case "geosearch":
+			WriteGeoLocations(conn, []GeoLocation{{
+				Member: []byte("a"), Dist: 1, Lon: 2, Lat: 3,
+			}}, GeoWithDist|GeoWithCoord)
+		}
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ cmd, exp string }{
+		{"zrange", "*2\r\n$1\r\na\r\n$3\r\n1.5\r\n"},
+		{"geosearch", "*1\r\n*3\r\n$1\r\na\r\n$6\r\n1.0000\r\n" +
+			"*2\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("%s: expected %q, got %q", tt.cmd, tt.exp, resp)
+		}
+	}
+}
+
+func TestWriteErr(t *testing.T) {
+	s := NewServer("", func(conn Conn, cmd Command) {
+		WriteErr(conn, errors.New(string(cmd.Args[1])))
+	}, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, tt := range []struct{ msg, exp string }{
+		{"invalid key", "-ERR invalid key\r\n"},
+		{"NOPERM no\npermission", "-NOPERM no permission\r\n"},
+	} {
+		b := AppendArray(nil, 2)
+		b = AppendBulkString(b, "err")
+		b = AppendBulkString(b, tt.msg)
+		if resp := testDo(t, c, string(b)); resp != tt.exp {
+			t.Fatalf("expected %q, got %q", tt.exp, resp)
+		}
+	}
+}
+
+func TestWriteUnknownCommand(t *testing.T) {
+	s := NewServer("", WriteUnknownCommand, nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	long := strings.Repeat("x", 200)
+	for _, tt := range []struct{ cmd, exp string }{
+		{"foo", "-ERR unknown command 'foo', with args beginning with: \r\n"},
+		{"Foo a b", "-ERR unknown command 'Foo', with args beginning with: " +
+			"'a' 'b' \r\n"},
+		{long + " " + long + " c", "-ERR unknown command '" + long[:128] +
+			"', with args beginning with: '" + long[:128] + "' \r\n"},
+		{"foo " + long[:100] + " " + long, "-ERR unknown command 'foo', " +
+			"with args beginning with: '" + long[:100] + "' '" +
+			long[:25] + "' \r\n"},
+	} {
+		if resp := testDo(t, c, tt.cmd+"\r\n"); resp != tt.exp {
+			t.Fatalf("expected %q, got %q", tt.exp, resp)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package resp
+
+import "strconv"
+
+// Protocol is a version of the RESP protocol.
+type Protocol int
+
+const (
+	// RESP2 is the protocol that is used by default.
+	RESP2 Protocol = 2
+	// RESP3 is the protocol that is selected using HELLO 3.
+	RESP3 Protocol = 3
+)
+
+// ScoredMember is a member of a sorted set and its score.
+type ScoredMember struct {
+	Member []byte
+	Score  float64
+}
+
+// AppendScoredMembers appends a sorted set reply that includes the scores,
+// such as ZRANGE with WITHSCORES, in the shape that clients expect for the
+// protocol. RESP2 uses a flat array where each member is followed by its
+// score as a bulk string. RESP3 uses an array of member and score pairs,
+// where the score is a double.
+func AppendScoredMembers(b []byte, proto Protocol, members []ScoredMember,
+) []byte {
+	if proto >= RESP3 {
+		b = AppendArray(b, len(members))
+		for _, m := range members {
+			b = AppendArray(b, 2)
+			b = AppendBulk(b, m.Member)
+			b = AppendDouble(b, m.Score)
+		}
+		return b
+	}
+	b = AppendArray(b, len(members)*2)
+	for _, m := range members {
+		b = AppendBulk(b, m.Member)
+		b = AppendFloat(b, proto, m.Score)
+	}
+	return b
+}
+
+// GeoLocation is a member of a geospatial index, as returned by GEOSEARCH.
+type GeoLocation struct {
+	Member []byte
+	// Dist is the distance from the center of the search.
+	Dist float64
+	// Hash is the geohash score of the member.
+	Hash int64
+	// Lon and Lat are the coordinates of the member.
+	Lon, Lat float64
+}
+
+// GeoFlags select the optional fields of GEOSEARCH style replies.
+type GeoFlags int
+
+const (
+	// GeoWithDist includes the distance, for the WITHDIST option.
+	GeoWithDist GeoFlags = 1 << iota
+	// GeoWithHash includes the geohash, for the WITHHASH option.
+	GeoWithHash
+	// GeoWithCoord includes the coordinates, for the WITHCOORD option.
+	GeoWithCoord
+)
+
+// AppendGeoLocations appends a GEOSEARCH or GEORADIUS style reply. Without
+// flags the reply is an array of members. Otherwise each location is an
+// array of the member followed by the distance, the hash, and the
+// coordinates, in that order, for the selected flags.
+//
+// Like Redis, distances are bulk strings with four decimals, and coordinates
+// are bulk strings for RESP2 and doubles for RESP3.
+func AppendGeoLocations(b []byte, proto Protocol, locs []GeoLocation,
+	flags GeoFlags,
+) []byte {
+	b = AppendArray(b, len(locs))
+	if flags == 0 {
+		for _, loc := range locs {
+			b = AppendBulk(b, loc.Member)
+		}
+		return b
+	}
+	n := 1
+	for _, flag := range []GeoFlags{GeoWithDist, GeoWithHash, GeoWithCoord} {
+		if flags&flag != 0 {
+			n++
+		}
+	}
+	var buf [64]byte
+	for _, loc := range locs {
+		b = AppendArray(b, n)
+		b = AppendBulk(b, loc.Member)
+		if flags&GeoWithDist != 0 {
+			b = AppendBulk(b, strconv.AppendFloat(buf[:0], loc.Dist, 'f', 4,
+				64))
+		}
+		if flags&GeoWithHash != 0 {
+			b = AppendInt(b, loc.Hash)
+		}
+		if flags&GeoWithCoord != 0 {
+			b = AppendArray(b, 2)
+			b = AppendFloat(b, proto, loc.Lon)
+			b = AppendFloat(b, proto, loc.Lat)
+		}
+	}
+	return b
+}
@@ -0,0 +1,66 @@
+package resp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAppendDouble(t *testing.T) {
+	tests := []struct {
+		f   float64
+		exp string
+	}{
+		{1.5, ",1.5\r\n"},
+		{3, ",3\r\n"},
+		{-0.25, ",-0.25\r\n"},
+		{123456789012, ",123456789012\r\n"},
+		{1e21, ",1e+21\r\n"},
+		{1e-7, ",1e-07\r\n"},
+		{math.Inf(1), ",inf\r\n"},
+		{math.Inf(-1), ",-inf\r\n"},
+		{math.NaN(), ",nan\r\n"},
+	}
+	for _, tt := range tests {
+		if b := AppendDouble(nil, tt.f); string(b) != tt.exp {
+			t.Fatalf("expected %q, got %q", tt.exp, b)
+		}
+	}
+}
+
+func TestAppendScoredMembers(t *testing.T) {
+	members := []ScoredMember{{[]byte("a"), 1}, {[]byte("b"), 2.5}}
+	b := AppendScoredMembers(nil, RESP2, members)
+	exp := "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$3\r\n2.5\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	b = AppendScoredMembers(nil, RESP3, members)
+	exp = "*2\r\n*2\r\n$1\r\na\r\n,1\r\n*2\r\n$1\r\nb\r\n,2.5\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+}
+
+func TestAppendGeoLocations(t *testing.T) {
+	locs := []GeoLocation{{
+		Member: []byte("Palermo"), Dist: 190.44242984775784,
+		Hash: 3479099956230698, Lon: 13.361389, Lat: 38.115556,
+	}}
+	b := AppendGeoLocations(nil, RESP2, locs, 0)
+	exp := "*1\r\n$7\r\nPalermo\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	b = AppendGeoLocations(nil, RESP2, locs,
+		GeoWithDist|GeoWithHash|GeoWithCoord)
+	exp = "*1\r\n*4\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n" +
+		":3479099956230698\r\n*2\r\n$9\r\n13.361389\r\n$9\r\n38.115556\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	b = AppendGeoLocations(nil, RESP3, locs, GeoWithCoord)
+	exp = "*1\r\n*2\r\n$7\r\nPalermo\r\n*2\r\n,13.361389\r\n,38.115556\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+}
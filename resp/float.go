@@ -0,0 +1,43 @@
+package resp
+
+import (
+	"math"
+	"strconv"
+)
+
+// appendFloat appends a float using the formatting of Redis, which is the
+// shortest representation that round-trips, and "inf", "-inf", or "nan" for
+// the special values. Like Redis, numbers are written using decimal notation
+// unless they're very large or very small.
+func appendFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(b, "inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-inf"...)
+	case math.IsNaN(f):
+		return append(b, "nan"...)
+	}
+	if abs := math.Abs(f); abs == 0 || (abs >= 1e-6 && abs < 1e21) {
+		return strconv.AppendFloat(b, f, 'f', -1, 64)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, 64)
+}
+
+// AppendDouble appends a RESP3 double to the input bytes.
+func AppendDouble(b []byte, f float64) []byte {
+	b = append(b, ',')
+	b = appendFloat(b, f)
+	return append(b, '\r', '\n')
+}
+
+// AppendFloat appends a float as a double for RESP3, or as a bulk string for
+// RESP2. The float is formatted like Redis does, using the shortest
+// representation that round-trips, and "inf" or "-inf" for infinities.
+func AppendFloat(b []byte, proto Protocol, f float64) []byte {
+	if proto >= RESP3 {
+		return AppendDouble(b, f)
+	}
+	var buf [64]byte
+	return AppendBulk(b, appendFloat(buf[:0], f))
+}
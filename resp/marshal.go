@@ -0,0 +1,442 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Unmarshaler is the interface implemented by types that can unmarshal
+// themselves from a RESP value, see Unmarshal.
+type Unmarshaler interface {
+	UnmarshalRESP(v Value) error
+}
+
+// Marshal returns the RESP2 encoding of v. It's like AppendAny, except that
+// structs are encoded as maps of their exported fields, and values that
+// can't be encoded, such as channels and functions, are an error.
+//
+// The key of a field is its name, or the name in the "resp" tag of the
+// field. A tag of "-" omits the field, and the "omitempty" option omits the
+// field when it has an empty value.
+//
+//   type User struct {
+//       Name  string `resp:"name"`
+//       Email string `resp:"email,omitempty"`
+//       Admin bool   `resp:"-"`
+//   }
+//
+// The fields of embedded structs are encoded as if they were in the outer
+// struct.
+func Marshal(v interface{}) ([]byte, error) {
+	return AppendMarshal(nil, RESP2, v)
+}
+
+// AppendMarshal appends the encoding of v, see Marshal. For RESP3 it uses the
+// RESP3 types, like AppendAnyProto.
+func AppendMarshal(b []byte, proto Protocol, v interface{}) ([]byte, error) {
+	return appendMarshal(b, proto, reflect.ValueOf(v))
+}
+
+func appendMarshal(b []byte, proto Protocol, rv reflect.Value,
+) ([]byte, error) {
+	if !rv.IsValid() {
+		return AppendNullProto(b, proto), nil
+	}
+	switch v := rv.Interface().(type) {
+	case SimpleString, SimpleInt, error, []byte, *big.Int, Value, *Value,
+		Marshaler:
+		return AppendAnyProto(b, proto, v), nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return AppendNullProto(b, proto), nil
+		}
+		return appendMarshal(b, proto, rv.Elem())
+	case reflect.Struct:
+		fields := structFields(rv.Type())
+		var n int
+		for _, f := range fields {
+			if !f.omitEmpty || !rv.FieldByIndex(f.index).IsZero() {
+				n++
+			}
+		}
+		b = AppendMap(b, proto, n)
+		for _, f := range fields {
+			fv := rv.FieldByIndex(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			b = AppendBulkString(b, f.name)
+			var err error
+			if b, err = appendMarshal(b, proto, fv); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Slice, reflect.Array:
+		n := rv.Len()
+		b = AppendArray(b, n)
+		for i := 0; i < n; i++ {
+			var err error
+			if b, err = appendMarshal(b, proto, rv.Index(i)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		keys := rv.MapKeys()
+		if rv.Type().Key().Kind() == reflect.String {
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i].String() < keys[j].String()
+			})
+		}
+		b = AppendMap(b, proto, len(keys))
+		for _, key := range keys {
+			var err error
+			if b, err = appendMarshal(b, proto, key); err != nil {
+				return b, err
+			}
+			if b, err = appendMarshal(b, proto, rv.MapIndex(key)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8,
+		reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return AppendAnyProto(b, proto, rv.Interface()), nil
+	}
+	return b, fmt.Errorf("resp: unsupported type %s", rv.Type())
+}
+
+// field is an exported field of a struct.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields returns the exported fields of a struct type, including the
+// fields of embedded structs.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("resp")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, f := range structFields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	return fields
+}
+
+// Unmarshal decodes the RESP value in data, such as a reply, into the value
+// that v points to. Data must hold exactly one value.
+//
+// Strings, numbers, and booleans are converted to the type of the target,
+// such as a bulk string "10" into an int. Arrays, sets, and push messages
+// are decoded into slices and arrays. Maps, and arrays of keys and values,
+// are decoded into maps and structs, where the keys match the names of the
+// fields like Marshal, or case-insensitively. Keys without a field are
+// ignored. Nulls set the target to its zero value.
+//
+// Into an empty interface the value is decoded as a string, int64, float64,
+// bool, *big.Int, nil, []interface{}, or map[string]interface{}. Error
+// replies are returned as errors, unless the target is a Value, which
+// receives the value as-is.
+func Unmarshal(data []byte, v interface{}) error {
+	n, val, err := ReadValue(data)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if n != len(data) {
+		return errors.New("resp: invalid data after value")
+	}
+	return UnmarshalValue(val, v)
+}
+
+// UnmarshalArgs decodes the arguments of a command into the value that v
+// points to, as if they were an array of bulk strings, see Unmarshal. For
+// example, the field and value pairs of an HSET command can be decoded into
+// a struct.
+//
+//   var user User
+//   err := resp.UnmarshalArgs(cmd.Args[2:], &user)
+func UnmarshalArgs(args [][]byte, v interface{}) error {
+	val := Value{Type: Array, Elems: make([]Value, len(args))}
+	for i, arg := range args {
+		val.Elems[i] = Value{Type: Bulk, Data: arg}
+	}
+	return UnmarshalValue(val, v)
+}
+
+// UnmarshalValue decodes val into the value that v points to, see
+// Unmarshal.
+func UnmarshalValue(val Value, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("resp: Unmarshal requires a non-nil pointer")
+	}
+	return unmarshal(val, rv.Elem())
+}
+
+var (
+	valueType       = reflect.TypeOf(Value{})
+	bigIntType      = reflect.TypeOf((*big.Int)(nil))
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+func unmarshal(val Value, rv reflect.Value) error {
+	if rv.Type() == valueType {
+		rv.Set(reflect.ValueOf(val))
+		return nil
+	}
+	if rv.CanAddr() && rv.Addr().Type().Implements(unmarshalerType) {
+		return rv.Addr().Interface().(Unmarshaler).UnmarshalRESP(val)
+	}
+	if val.Type == Error || val.Type == BlobError {
+		return errors.New(val.String())
+	}
+	if val.Null {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	if rv.Type() == bigIntType {
+		n, ok := new(big.Int).SetString(val.String(), 10)
+		if !ok {
+			return unmarshalError(val, rv)
+		}
+		rv.Set(reflect.ValueOf(n))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return unmarshal(val, rv.Elem())
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return unmarshalError(val, rv)
+		}
+		x, err := valueInterface(val)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+		} else {
+			rv.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.String:
+		if val.isAggregate() {
+			return unmarshalError(val, rv)
+		}
+		rv.SetString(val.String())
+		return nil
+	case reflect.Bool:
+		if val.Type == Boolean {
+			rv.SetBool(val.Bool())
+			return nil
+		}
+		t, err := strconv.ParseBool(string(val.Data))
+		if err != nil {
+			return unmarshalError(val, rv)
+		}
+		rv.SetBool(t)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		if val.Type == Boolean {
+			rv.SetInt(val.Int())
+			return nil
+		}
+		n, err := strconv.ParseInt(string(val.Data), 10, rv.Type().Bits())
+		if err != nil {
+			return unmarshalError(val, rv)
+		}
+		rv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		if val.Type == Boolean {
+			rv.SetUint(uint64(val.Int()))
+			return nil
+		}
+		n, err := strconv.ParseUint(string(val.Data), 10, rv.Type().Bits())
+		if err != nil {
+			return unmarshalError(val, rv)
+		}
+		rv.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if val.Type == Boolean {
+			rv.SetFloat(val.Float())
+			return nil
+		}
+		f, err := strconv.ParseFloat(string(val.Data), rv.Type().Bits())
+		if err != nil {
+			return unmarshalError(val, rv)
+		}
+		rv.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 && !val.isAggregate() {
+			// []byte
+			rv.SetBytes(append([]byte(nil), val.String()...))
+			return nil
+		}
+		if !val.isAggregate() {
+			return unmarshalError(val, rv)
+		}
+		s := reflect.MakeSlice(rv.Type(), len(val.Elems), len(val.Elems))
+		for i, elem := range val.Elems {
+			if err := unmarshal(elem, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return nil
+	case reflect.Array:
+		if !val.isAggregate() {
+			return unmarshalError(val, rv)
+		}
+		rv.Set(reflect.Zero(rv.Type()))
+		for i := 0; i < len(val.Elems) && i < rv.Len(); i++ {
+			if err := unmarshal(val.Elems[i], rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if !val.isAggregate() || len(val.Elems)%2 != 0 {
+			return unmarshalError(val, rv)
+		}
+		m := reflect.MakeMapWithSize(rv.Type(), len(val.Elems)/2)
+		for i := 0; i < len(val.Elems); i += 2 {
+			key := reflect.New(rv.Type().Key()).Elem()
+			if err := unmarshal(val.Elems[i], key); err != nil {
+				return err
+			}
+			value := reflect.New(rv.Type().Elem()).Elem()
+			if err := unmarshal(val.Elems[i+1], value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		rv.Set(m)
+		return nil
+	case reflect.Struct:
+		if !val.isAggregate() || len(val.Elems)%2 != 0 {
+			return unmarshalError(val, rv)
+		}
+		fields := structFields(rv.Type())
+		for i := 0; i < len(val.Elems); i += 2 {
+			f := findField(fields, val.Elems[i].String())
+			if f == nil {
+				continue
+			}
+			fv := rv.FieldByIndex(f.index)
+			if err := unmarshal(val.Elems[i+1], fv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return unmarshalError(val, rv)
+}
+
+// findField returns the field with the name, preferring an exact match over
+// a case-insensitive match, or nil when there is none.
+func findField(fields []field, name string) *field {
+	var fold *field
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+		if fold == nil && strings.EqualFold(fields[i].name, name) {
+			fold = &fields[i]
+		}
+	}
+	return fold
+}
+
+// valueInterface returns val as a value for an empty interface.
+func valueInterface(val Value) (interface{}, error) {
+	if val.Null {
+		return nil, nil
+	}
+	switch val.Type {
+	case Error, BlobError:
+		return nil, errors.New(val.String())
+	case Integer:
+		return val.Int(), nil
+	case Double:
+		return val.Float(), nil
+	case Boolean:
+		return val.Bool(), nil
+	case BigNumber:
+		n, ok := new(big.Int).SetString(val.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("resp: invalid big number %q", val.Data)
+		}
+		return n, nil
+	case Map:
+		m := make(map[string]interface{}, len(val.Elems)/2)
+		for i := 0; i+1 < len(val.Elems); i += 2 {
+			x, err := valueInterface(val.Elems[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[val.Elems[i].String()] = x
+		}
+		return m, nil
+	case Array, Set, Push:
+		a := make([]interface{}, len(val.Elems))
+		for i, elem := range val.Elems {
+			x, err := valueInterface(elem)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = x
+		}
+		return a, nil
+	}
+	return val.String(), nil
+}
+
+func unmarshalError(val Value, rv reflect.Value) error {
+	return fmt.Errorf("resp: cannot unmarshal %q into %s", val.String(),
+		rv.Type())
+}
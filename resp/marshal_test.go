@@ -0,0 +1,166 @@
+package resp
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testBase struct {
+	ID int `resp:"id"`
+}
+
+type testUser struct {
+	testBase
+	Name    string            `resp:"name"`
+	Email   string            `resp:"email,omitempty"`
+	Admin   bool              `resp:"-"`
+	Tags    []string          `resp:"tags"`
+	Score   float64           `resp:"score"`
+	Meta    map[string]string `resp:"meta,omitempty"`
+	Next    *testUser         `resp:"next,omitempty"`
+	private int
+}
+
+func TestMarshal(t *testing.T) {
+	user := testUser{testBase: testBase{ID: 1}, Name: "jan", Admin: true,
+		Tags: []string{"a", "b"}, Score: 1.5}
+	b, err := Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "*8\r\n$2\r\nid\r\n$1\r\n1\r\n$4\r\nname\r\n$3\r\njan\r\n" +
+		"$4\r\ntags\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n" +
+		"$5\r\nscore\r\n$3\r\n1.5\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	b, err = AppendMarshal(nil, RESP3, map[string]interface{}{
+		"b": true, "a": nil, "c": []int{1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = "%3\r\n$1\r\na\r\n_\r\n$1\r\nb\r\n#t\r\n$1\r\nc\r\n*1\r\n$1\r\n1\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	if _, err := Marshal(map[string]interface{}{"ch": make(chan int)}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := Marshal(func() {}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	user := testUser{testBase: testBase{ID: 7}, Name: "jan",
+		Email: "jan@example.com", Tags: []string{"a"}, Score: -2.5,
+		Meta: map[string]string{"k": "v"},
+		Next: &testUser{Name: "pat", Tags: []string{"b", "c"}}}
+	for _, proto := range []Protocol{RESP2, RESP3} {
+		b, err := AppendMarshal(nil, proto, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var user2 testUser
+		if err := Unmarshal(b, &user2); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(user, user2) {
+			t.Fatalf("expected %+v, got %+v", user, user2)
+		}
+	}
+
+	// case-insensitive keys, and unknown keys are ignored
+	var user2 testUser
+	err := UnmarshalArgs([][]byte{[]byte("NAME"), []byte("jan"),
+		[]byte("unknown"), []byte("x"), []byte("ID"), []byte("3")}, &user2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user2.Name != "jan" || user2.ID != 3 {
+		t.Fatalf("unexpected user %+v", user2)
+	}
+
+	var x interface{}
+	err = Unmarshal([]byte("%2\r\n+a\r\n*2\r\n:1\r\n,1.5\r\n+b\r\n"+
+		"~2\r\n#t\r\n(12345678901234567890\r\n"), &x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := new(big.Int).SetString("12345678901234567890", 10)
+	expx := map[string]interface{}{
+		"a": []interface{}{int64(1), 1.5},
+		"b": []interface{}{true, n},
+	}
+	if !reflect.DeepEqual(x, expx) {
+		t.Fatalf("expected %v, got %v", expx, x)
+	}
+
+	var i8 int8
+	if err := Unmarshal([]byte("$3\r\n127\r\n"), &i8); err != nil || i8 != 127 {
+		t.Fatalf("unexpected %d, %v", i8, err)
+	}
+	if err := Unmarshal([]byte(":128\r\n"), &i8); err == nil {
+		t.Fatal("expected an error")
+	}
+	var ok bool
+	if err := Unmarshal([]byte(":1\r\n"), &ok); err != nil || !ok {
+		t.Fatalf("unexpected %t, %v", ok, err)
+	}
+	var p *int
+	if err := Unmarshal([]byte("$-1\r\n"), &p); err != nil || p != nil {
+		t.Fatalf("unexpected %v, %v", p, err)
+	}
+	var arr [2]string
+	if err := Unmarshal([]byte("*3\r\n+a\r\n+b\r\n+c\r\n"), &arr); err != nil ||
+		arr != [2]string{"a", "b"} {
+		t.Fatalf("unexpected %q, %v", arr, err)
+	}
+	var s string
+	err = Unmarshal([]byte("-WRONGTYPE bad\r\n"), &s)
+	if err == nil || err.Error() != "WRONGTYPE bad" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var v Value
+	if err := Unmarshal([]byte("-ERR bad\r\n"), &v); err != nil ||
+		v.Type != Error {
+		t.Fatalf("unexpected %+v, %v", v, err)
+	}
+	var tv testValue
+	if err := Unmarshal([]byte("+hello\r\n"), &tv); err != nil ||
+		tv.s != "HELLO" {
+		t.Fatalf("unexpected %q, %v", tv.s, err)
+	}
+
+	for _, data := range []string{"", "+OK", "+OK\r\n+OK\r\n"} {
+		if err := Unmarshal([]byte(data), &s); err == nil {
+			t.Fatalf("%q: expected an error", data)
+		}
+	}
+	if err := Unmarshal([]byte("+OK\r\n"), s); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := Unmarshal([]byte("*1\r\n+OK\r\n"), &s); err == nil {
+		t.Fatal("expected an error")
+	}
+	var m map[string]int
+	if err := Unmarshal([]byte("*1\r\n+OK\r\n"), &m); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+type testValue struct {
+	s string
+}
+
+func (tv *testValue) UnmarshalRESP(v Value) error {
+	if v.Type != String {
+		return errors.New("expected a string")
+	}
+	tv.s = strings.ToUpper(string(v.Data))
+	return nil
+}
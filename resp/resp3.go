@@ -0,0 +1,106 @@
+package resp
+
+// The RESP3 types are written using the functions below. Most types have a
+// RESP2 equivalent, and the functions that take a Protocol write the
+// equivalent when proto is RESP2, so a single code path serves both kinds
+// of clients.
+
+// AppendMap appends a map header with n key/value pairs, which must be
+// followed by the keys and values. For RESP2 it appends an array header
+// with n*2 elements.
+func AppendMap(b []byte, proto Protocol, n int) []byte {
+	if proto >= RESP3 {
+		return appendPrefix(b, '%', int64(n))
+	}
+	return appendPrefix(b, '*', int64(n)*2)
+}
+
+// AppendSet appends a set header with n elements, which must be followed by
+// the elements. For RESP2 it appends an array header.
+func AppendSet(b []byte, proto Protocol, n int) []byte {
+	if proto >= RESP3 {
+		return appendPrefix(b, '~', int64(n))
+	}
+	return appendPrefix(b, '*', int64(n))
+}
+
+// AppendPush appends the header of an out-of-band push message with n
+// elements, which must be followed by the elements. For RESP2 it appends an
+// array header, like the messages of pub/sub.
+func AppendPush(b []byte, proto Protocol, n int) []byte {
+	if proto >= RESP3 {
+		return appendPrefix(b, '>', int64(n))
+	}
+	return appendPrefix(b, '*', int64(n))
+}
+
+// AppendAttribute appends an attribute header with n key/value pairs, which
+// must be followed by the keys and values, and then by the reply that the
+// attributes describe. Attributes only exist in RESP3, and must not be sent
+// to RESP2 clients.
+func AppendAttribute(b []byte, n int) []byte {
+	return appendPrefix(b, '|', int64(n))
+}
+
+// AppendBool appends a boolean. For RESP2 it appends the integer 1 or 0.
+func AppendBool(b []byte, proto Protocol, v bool) []byte {
+	if proto >= RESP3 {
+		if v {
+			return append(b, '#', 't', '\r', '\n')
+		}
+		return append(b, '#', 'f', '\r', '\n')
+	}
+	if v {
+		return append(b, ':', '1', '\r', '\n')
+	}
+	return append(b, ':', '0', '\r', '\n')
+}
+
+// AppendNullProto appends a null. For RESP3 it appends the null type, and
+// for RESP2 it appends a null bulk string.
+func AppendNullProto(b []byte, proto Protocol) []byte {
+	if proto >= RESP3 {
+		return append(b, '_', '\r', '\n')
+	}
+	return AppendNull(b)
+}
+
+// AppendBigNumber appends an integer of arbitrary size, where num is its
+// decimal representation, such as "3492890328409238509324850943850943825".
+// For RESP2 it appends a bulk string.
+func AppendBigNumber(b []byte, proto Protocol, num string) []byte {
+	if proto >= RESP3 {
+		b = append(b, '(')
+		b = append(b, stripNewlines(num)...)
+		return append(b, '\r', '\n')
+	}
+	return AppendBulkString(b, num)
+}
+
+// AppendVerbatim appends a verbatim string, which is text that is meant to
+// be shown to the user as-is, such as the reply of INFO. The format is a
+// three letter type, "txt" for plain text or "mkd" for markdown. For RESP2
+// it appends the text as a bulk string.
+func AppendVerbatim(b []byte, proto Protocol, format string, text []byte,
+) []byte {
+	if proto < RESP3 {
+		return AppendBulk(b, text)
+	}
+	b = appendPrefix(b, '=', int64(len(format)+1+len(text)))
+	b = append(b, format...)
+	b = append(b, ':')
+	b = append(b, text...)
+	return append(b, '\r', '\n')
+}
+
+// AppendBlobError appends an error that may contain any bytes, including
+// CR and LF. For RESP2 it appends a simple error, where CR and LF are
+// replaced with spaces.
+func AppendBlobError(b []byte, proto Protocol, msg string) []byte {
+	if proto < RESP3 {
+		return AppendError(b, msg)
+	}
+	b = appendPrefix(b, '!', int64(len(msg)))
+	b = append(b, msg...)
+	return append(b, '\r', '\n')
+}
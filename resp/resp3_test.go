@@ -0,0 +1,66 @@
+package resp
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestAppendRESP3(t *testing.T) {
+	tests := []struct {
+		b2, b3 []byte
+		exp2   string
+		exp3   string
+	}{
+		{AppendMap(nil, RESP2, 2), AppendMap(nil, RESP3, 2), "*4\r\n", "%2\r\n"},
+		{AppendSet(nil, RESP2, 3), AppendSet(nil, RESP3, 3), "*3\r\n", "~3\r\n"},
+		{AppendPush(nil, RESP2, 12), AppendPush(nil, RESP3, 12),
+			"*12\r\n", ">12\r\n"},
+		{AppendBool(nil, RESP2, true), AppendBool(nil, RESP3, true),
+			":1\r\n", "#t\r\n"},
+		{AppendBool(nil, RESP2, false), AppendBool(nil, RESP3, false),
+			":0\r\n", "#f\r\n"},
+		{AppendNullProto(nil, RESP2), AppendNullProto(nil, RESP3),
+			"$-1\r\n", "_\r\n"},
+		{AppendBigNumber(nil, RESP2, "-123456789012345678901234567890"),
+			AppendBigNumber(nil, RESP3, "-123456789012345678901234567890"),
+			"$31\r\n-123456789012345678901234567890\r\n",
+			"(-123456789012345678901234567890\r\n"},
+		{AppendVerbatim(nil, RESP2, "txt", []byte("a\r\nb")),
+			AppendVerbatim(nil, RESP3, "txt", []byte("a\r\nb")),
+			"$4\r\na\r\nb\r\n", "=8\r\ntxt:a\r\nb\r\n"},
+		{AppendBlobError(nil, RESP2, "SYNTAX bad\r\nsyntax"),
+			AppendBlobError(nil, RESP3, "SYNTAX bad\r\nsyntax"),
+			"-SYNTAX bad  syntax\r\n", "!18\r\nSYNTAX bad\r\nsyntax\r\n"},
+		{AppendAttribute(nil, 1), AppendAttribute(nil, 1), "|1\r\n", "|1\r\n"},
+	}
+	for _, tt := range tests {
+		if string(tt.b2) != tt.exp2 {
+			t.Fatalf("expected %q, got %q", tt.exp2, tt.b2)
+		}
+		if string(tt.b3) != tt.exp3 {
+			t.Fatalf("expected %q, got %q", tt.exp3, tt.b3)
+		}
+	}
+}
+
+func TestAppendAnyProto(t *testing.T) {
+	v := map[string]interface{}{
+		"a": nil,
+		"b": true,
+		"c": 1.5,
+		"d": []interface{}{big.NewInt(7), (*int)(nil)},
+	}
+	exp := "*8\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n$1\r\n1\r\n" +
+		"$1\r\nc\r\n$3\r\n1.5\r\n$1\r\nd\r\n*2\r\n$1\r\n7\r\n$-1\r\n"
+	if b := AppendAnyProto(nil, RESP2, v); string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	if b := AppendAny(nil, v); string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+	exp = "%4\r\n$1\r\na\r\n_\r\n$1\r\nb\r\n#t\r\n" +
+		"$1\r\nc\r\n,1.5\r\n$1\r\nd\r\n*2\r\n(7\r\n_\r\n"
+	if b := AppendAnyProto(nil, RESP3, v); string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+}
@@ -1,4 +1,4 @@
-package redcon
+package resp
 
 import (
 	"bytes"
@@ -250,3 +250,77 @@ func TestAppendBulkUint(t *testing.T) {
 		t.Fatalf("expected '%s', got '%s'", exp, b)
 	}
 }
+
+func TestAppendBulkArray(t *testing.T) {
+	var b []byte
+	b = AppendBulkOrNull(b, []byte("a"), true)
+	b = AppendBulkOrNull(b, nil, false)
+	b = AppendBulkArray(b, [][]byte{[]byte("x"), nil, {}})
+	exp := "$1\r\na\r\n$-1\r\n*3\r\n$1\r\nx\r\n$-1\r\n$0\r\n\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected '%s', got '%s'", exp, b)
+	}
+}
+
+func TestAppendAnyReflect(t *testing.T) {
+	type myString string
+	type myInt int16
+	type myBool bool
+	n := 5
+	var nilPtr *int
+	b := AppendAny(nil, []interface{}{
+		[2]int{1, 2}, &n, nilPtr, myString("s"), myInt(-3), myBool(true),
+		uint8(7),
+	})
+	exp := "*7\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n5\r\n$-1\r\n$1\r\ns\r\n" +
+		"$2\r\n-3\r\n$1\r\n1\r\n$1\r\n7\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+}
+
+func TestPrefixError(t *testing.T) {
+	for _, tt := range []struct{ msg, exp string }{
+		{"invalid key", "ERR invalid key"},
+		{"  WRONGTYPE wrong kind ", "WRONGTYPE wrong kind"},
+		{"ERR: odd", "ERR ERR: odd"},
+		{"", "ERR"},
+	} {
+		if msg := PrefixError(tt.msg); msg != tt.exp {
+			t.Fatalf("expected %q, got %q", tt.exp, msg)
+		}
+	}
+	b := AppendError(nil, PrefixError("bad\r\nvalue"))
+	if string(b) != "-ERR bad  value\r\n" {
+		t.Fatalf("expected %q, got %q", "-ERR bad  value\r\n", b)
+	}
+}
+
+func TestAppendAllocs(t *testing.T) {
+	buf := make([]byte, 0, 4096)
+	allocs := testing.AllocsPerRun(100, func() {
+		b := buf[:0]
+		b = AppendOK(b)
+		b = AppendString(b, "PONG")
+		b = AppendError(b, "ERR bad")
+		b = AppendInt(b, -1234)
+		b = AppendUint(b, 1234)
+		b = AppendArray(b, 10)
+		b = AppendBulk(b, []byte("value"))
+		b = AppendBulkString(b, "value")
+		b = AppendNull(b)
+		b = AppendNullArray(b)
+		b = AppendBulkInt(b, -1234)
+		b = AppendBulkUint(b, 1234)
+		b = AppendBulkFloat(b, 1.5)
+		b = AppendFloat(b, RESP2, 1.5)
+		b = AppendFloat(b, RESP3, 1.5)
+		b = AppendMap(b, RESP3, 2)
+		b = AppendBool(b, RESP3, true)
+		b = AppendNullProto(b, RESP3)
+		_ = b
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
@@ -0,0 +1,63 @@
+package resp
+
+import "time"
+
+// AppendTime appends a TIME style reply, which is an array of two bulk
+// strings: the unix time in seconds and the microseconds elapsed in the
+// current second.
+func AppendTime(b []byte, t time.Time) []byte {
+	b = AppendArray(b, 2)
+	b = AppendBulkInt(b, t.Unix())
+	return AppendBulkInt(b, int64(t.Nanosecond()/1000))
+}
+
+// AppendUnix appends the unix time in seconds as an integer, such as the
+// reply of EXPIRETIME.
+func AppendUnix(b []byte, t time.Time) []byte {
+	return AppendInt(b, t.Unix())
+}
+
+// AppendUnixMilli appends the unix time in milliseconds as an integer, such
+// as the reply of PEXPIRETIME.
+func AppendUnixMilli(b []byte, t time.Time) []byte {
+	return AppendInt(b, t.UnixNano()/int64(time.Millisecond))
+}
+
+// TTLStatus is the state of the time to live of a key.
+type TTLStatus int
+
+const (
+	// TTLExpires is for a key that has a time to live.
+	TTLExpires TTLStatus = iota
+	// TTLPersistent is for a key that exists but has no time to live,
+	// which is replied as -1.
+	TTLPersistent
+	// TTLMissing is for a key that does not exist, which is replied as -2.
+	TTLMissing
+)
+
+// AppendTTL appends a TTL style reply in seconds. The time to live is only
+// used with TTLExpires, and is rounded to the nearest second like Redis.
+func AppendTTL(b []byte, status TTLStatus, ttl time.Duration) []byte {
+	return appendTTL(b, status, ttl, time.Second)
+}
+
+// AppendPTTL appends a PTTL style reply in milliseconds. The time to live is
+// only used with TTLExpires, and is rounded to the nearest millisecond.
+func AppendPTTL(b []byte, status TTLStatus, ttl time.Duration) []byte {
+	return appendTTL(b, status, ttl, time.Millisecond)
+}
+
+func appendTTL(b []byte, status TTLStatus, ttl, unit time.Duration,
+) []byte {
+	switch status {
+	case TTLPersistent:
+		return AppendInt(b, -1)
+	case TTLMissing:
+		return AppendInt(b, -2)
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return AppendInt(b, int64((ttl+unit/2)/unit))
+}
@@ -0,0 +1,42 @@
+package resp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendTime(t *testing.T) {
+	tm := time.Unix(1700000000, 123456789)
+	var b []byte
+	b = AppendTime(b, tm)
+	b = AppendUnix(b, tm)
+	b = AppendUnixMilli(b, tm)
+	exp := "*2\r\n$10\r\n1700000000\r\n$6\r\n123456\r\n" +
+		":1700000000\r\n:1700000000123\r\n"
+	if string(b) != exp {
+		t.Fatalf("expected %q, got %q", exp, b)
+	}
+}
+
+func TestAppendTTL(t *testing.T) {
+	tests := []struct {
+		status TTLStatus
+		ttl    time.Duration
+		sec    string
+		ms     string
+	}{
+		{TTLExpires, time.Millisecond * 2600, ":3\r\n", ":2600\r\n"},
+		{TTLExpires, time.Millisecond * 2400, ":2\r\n", ":2400\r\n"},
+		{TTLExpires, -time.Second, ":0\r\n", ":0\r\n"},
+		{TTLPersistent, time.Second, ":-1\r\n", ":-1\r\n"},
+		{TTLMissing, 0, ":-2\r\n", ":-2\r\n"},
+	}
+	for _, tt := range tests {
+		if b := AppendTTL(nil, tt.status, tt.ttl); string(b) != tt.sec {
+			t.Fatalf("expected %q, got %q", tt.sec, b)
+		}
+		if b := AppendPTTL(nil, tt.status, tt.ttl); string(b) != tt.ms {
+			t.Fatalf("expected %q, got %q", tt.ms, b)
+		}
+	}
+}
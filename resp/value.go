@@ -0,0 +1,382 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a RESP value of any type, such as a reply that was read using
+// ReadValue.
+type Value struct {
+	// Type is the type of the value. Attributes are never the type of a
+	// value, because they are stored in the Attrs of the value that follows
+	// them.
+	Type Type
+	// Data is the content of simple strings, errors, and bulk strings, and
+	// the text of integers, doubles, big numbers, and booleans ("t" or "f").
+	// For verbatim strings it includes the format, such as "txt:hello".
+	Data []byte
+	// Elems are the elements of arrays, sets, and push messages. For maps
+	// the keys and values alternate.
+	Elems []Value
+	// Null is true for the null type of RESP3, and for the null bulk strings
+	// and null arrays of RESP2.
+	Null bool
+	// Attrs are the attributes that were sent before the value, where the
+	// keys and values alternate, or nil when there are none.
+	Attrs []Value
+}
+
+// String returns the value as a string. For verbatim strings the format is
+// omitted, null values are empty, and aggregates are formatted like
+// "[a b c]".
+func (v Value) String() string {
+	switch {
+	case v.Null:
+		return ""
+	case v.Elems != nil || v.isAggregate():
+		parts := make([]string, len(v.Elems))
+		for i, elem := range v.Elems {
+			parts[i] = elem.String()
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case v.Type == Verbatim && len(v.Data) >= 4:
+		return string(v.Data[4:])
+	}
+	return string(v.Data)
+}
+
+// Int returns the value as an integer, or zero when it's not a number or
+// does not fit. Booleans are 1 or 0, and doubles are truncated.
+func (v Value) Int() int64 {
+	if v.Type == Boolean {
+		return int64(boolInt(v.Bool()))
+	}
+	n, err := strconv.ParseInt(string(v.Data), 10, 64)
+	if err != nil {
+		f, err := strconv.ParseFloat(string(v.Data), 64)
+		if err != nil || math.IsNaN(f) || f >= math.MaxInt64 ||
+			f < math.MinInt64 {
+			return 0
+		}
+		return int64(f)
+	}
+	return n
+}
+
+// Float returns the value as a float, or zero when it's not a number.
+// Booleans are 1 or 0.
+func (v Value) Float() float64 {
+	if v.Type == Boolean {
+		return float64(boolInt(v.Bool()))
+	}
+	f, err := strconv.ParseFloat(string(v.Data), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// Bool returns the value as a boolean. Booleans are true for "t", and other
+// values are true when they are a non-zero number.
+func (v Value) Bool() bool {
+	if v.Type == Boolean {
+		return len(v.Data) == 1 && v.Data[0] == 't'
+	}
+	return v.Float() != 0
+}
+
+// Array returns the elements of an array, set, push message, or map, or nil
+// when the value is not an aggregate. For maps the keys and values
+// alternate.
+func (v Value) Array() []Value {
+	return v.Elems
+}
+
+// Map returns the key/value pairs of a map, keyed by the String of the keys.
+// Arrays are also accepted when their elements are keys and values, like the
+// RESP2 replies of commands such as HGETALL. Returns nil when the value is
+// not a map or such an array.
+func (v Value) Map() map[string]Value {
+	if !v.isAggregate() || len(v.Elems)%2 != 0 {
+		return nil
+	}
+	m := make(map[string]Value, len(v.Elems)/2)
+	for i := 0; i < len(v.Elems); i += 2 {
+		m[v.Elems[i].String()] = v.Elems[i+1]
+	}
+	return m
+}
+
+// MarshalRESP returns the value as RESP3, including its attributes. Use
+// AppendValue for RESP2.
+func (v Value) MarshalRESP() []byte {
+	return AppendValue(nil, RESP3, v)
+}
+
+func (v Value) isAggregate() bool {
+	switch v.Type {
+	case Array, Map, Set, Push:
+		return true
+	}
+	return false
+}
+
+func boolInt(t bool) int {
+	if t {
+		return 1
+	}
+	return 0
+}
+
+// AppendValue appends a value. For RESP2 the RESP3 types are appended as
+// their RESP2 equivalents, and attributes are omitted. The zero Value is
+// appended as a null.
+func AppendValue(b []byte, proto Protocol, v Value) []byte {
+	if proto >= RESP3 && len(v.Attrs) > 0 {
+		b = AppendAttribute(b, len(v.Attrs)/2)
+		for _, attr := range v.Attrs {
+			b = AppendValue(b, proto, attr)
+		}
+	}
+	if v.Null {
+		if v.Type == Array && proto < RESP3 {
+			return AppendNullArray(b)
+		}
+		return AppendNullProto(b, proto)
+	}
+	switch v.Type {
+	case String, Error, Integer:
+		b = append(b, byte(v.Type))
+		b = append(b, stripNewlines(string(v.Data))...)
+		return append(b, '\r', '\n')
+	case Bulk:
+		return AppendBulk(b, v.Data)
+	case Double:
+		if proto < RESP3 {
+			return AppendBulk(b, v.Data)
+		}
+		b = append(b, ',')
+		b = append(b, stripNewlines(string(v.Data))...)
+		return append(b, '\r', '\n')
+	case Boolean:
+		return AppendBool(b, proto, v.Bool())
+	case BigNumber:
+		return AppendBigNumber(b, proto, string(v.Data))
+	case BlobError:
+		return AppendBlobError(b, proto, string(v.Data))
+	case Verbatim:
+		if len(v.Data) < 4 || v.Data[3] != ':' {
+			return AppendBulk(b, v.Data)
+		}
+		return AppendVerbatim(b, proto, string(v.Data[:3]), v.Data[4:])
+	case Array:
+		b = AppendArray(b, len(v.Elems))
+	case Map:
+		b = AppendMap(b, proto, len(v.Elems)/2)
+	case Set:
+		b = AppendSet(b, proto, len(v.Elems))
+	case Push:
+		b = AppendPush(b, proto, len(v.Elems))
+	default:
+		return AppendNullProto(b, proto)
+	}
+	for _, elem := range v.Elems {
+		b = AppendValue(b, proto, elem)
+	}
+	return b
+}
+
+// maxValueDepth is the maximum nesting of aggregate values.
+const maxValueDepth = 512
+
+var errValueTooDeep = &errProtocol{"value is nested too deeply"}
+
+// ReadValue reads the next value in b, which may be any RESP2 or RESP3 type,
+// including streamed strings and aggregates. Returns the number of bytes of
+// the value, or zero when b does not hold a complete value. An error is
+// returned when b is not valid RESP.
+//
+// The value references the bytes of b, except for streamed strings.
+func ReadValue(b []byte) (n int, v Value, err error) {
+	return readValue(b, 0)
+}
+
+func readValue(b []byte, depth int) (int, Value, error) {
+	var v Value
+	if depth > maxValueDepth {
+		return 0, v, errValueTooDeep
+	}
+	line, n, err := readLine(b)
+	if n == 0 || err != nil {
+		return 0, v, err
+	}
+	v.Type = Type(b[0])
+	switch v.Type {
+	case String, Error, Double, BigNumber:
+		v.Data = line
+		return n, v, nil
+	case Integer:
+		if _, ok := parseInt(line); !ok {
+			return 0, v, &errProtocol{"invalid integer"}
+		}
+		v.Data = line
+		return n, v, nil
+	case Boolean:
+		if len(line) != 1 || (line[0] != 't' && line[0] != 'f') {
+			return 0, v, &errProtocol{"invalid boolean"}
+		}
+		v.Data = line
+		return n, v, nil
+	case Null:
+		if len(line) != 0 {
+			return 0, v, &errProtocol{"invalid null"}
+		}
+		v.Null = true
+		return n, v, nil
+	case Bulk, BlobError, Verbatim:
+		if v.Type == Bulk && string(line) == "?" {
+			return readStreamedBulk(b, n, v)
+		}
+		size, ok := parseInt(line)
+		if ok && size == -1 && v.Type == Bulk {
+			v.Null = true
+			return n, v, nil
+		}
+		if !ok || size < 0 {
+			return 0, v, errInvalidBulkLength
+		}
+		if len(b)-n-2 < size {
+			return 0, v, nil
+		}
+		if b[n+size] != '\r' || b[n+size+1] != '\n' {
+			return 0, v, errInvalidBulkLength
+		}
+		v.Data = b[n : n+size]
+		if v.Type == Verbatim && (size < 4 || v.Data[3] != ':') {
+			return 0, v, &errProtocol{"invalid verbatim string"}
+		}
+		return n + size + 2, v, nil
+	case Array, Map, Set, Push, Attribute:
+		if string(line) == "?" && v.Type != Attribute {
+			return readStreamedAggregate(b, n, v, depth)
+		}
+		count, ok := parseInt(line)
+		if ok && count == -1 && v.Type == Array {
+			v.Null = true
+			return n, v, nil
+		}
+		if !ok || count < 0 || count > math.MaxInt/2 {
+			return 0, v, errInvalidMultiBulkLength
+		}
+		if v.Type == Map || v.Type == Attribute {
+			count *= 2
+		}
+		if count > 0 {
+			// each element is at least three bytes, which bounds the
+			// allocation for a count that is not backed by data.
+			size := count
+			if size > (len(b)-n)/3 {
+				size = (len(b) - n) / 3
+			}
+			v.Elems = make([]Value, 0, size)
+		}
+		for i := 0; i < count; i++ {
+			m, elem, err := readValue(b[n:], depth+1)
+			if m == 0 || err != nil {
+				return 0, v, err
+			}
+			v.Elems = append(v.Elems, elem)
+			n += m
+		}
+		if v.Type != Attribute {
+			return n, v, nil
+		}
+		// the attributes are followed by the value that they describe
+		m, next, err := readValue(b[n:], depth+1)
+		if m == 0 || err != nil {
+			return 0, v, err
+		}
+		next.Attrs = append(v.Elems, next.Attrs...)
+		return n + m, next, nil
+	}
+	return 0, v, &errProtocol{"invalid type '" + string(b[0]) + "'"}
+}
+
+// readLine returns the first line in b without its type byte and CRLF, and
+// the number of bytes of the line including the CRLF, or zero when b does not
+// hold a complete line.
+func readLine(b []byte) (line []byte, n int, err error) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil, 0, nil
+	}
+	if i < 2 || b[i-1] != '\r' {
+		return nil, 0, errInvalidMessage
+	}
+	return b[1 : i-1], i + 1, nil
+}
+
+// readStreamedBulk reads the chunks of a streamed string, which start at
+// b[n:].
+func readStreamedBulk(b []byte, n int, v Value) (int, Value, error) {
+	v.Data = []byte{}
+	for {
+		line, m, err := readLine(b[n:])
+		if m == 0 || err != nil {
+			return 0, v, err
+		}
+		if b[n] != ';' {
+			return 0, v, &errProtocol{"expected ';', got '" +
+				string(b[n]) + "'"}
+		}
+		size, ok := parseInt(line)
+		if !ok || size < 0 {
+			return 0, v, errInvalidBulkLength
+		}
+		n += m
+		if size == 0 {
+			return n, v, nil
+		}
+		if len(b)-n-2 < size {
+			return 0, v, nil
+		}
+		if b[n+size] != '\r' || b[n+size+1] != '\n' {
+			return 0, v, errInvalidBulkLength
+		}
+		v.Data = append(v.Data, b[n:n+size]...)
+		n += size + 2
+	}
+}
+
+// readStreamedAggregate reads the elements of a streamed aggregate, which
+// start at b[n:], up to the terminator.
+func readStreamedAggregate(b []byte, n int, v Value, depth int,
+) (int, Value, error) {
+	for {
+		if n == len(b) {
+			return 0, v, nil
+		}
+		if b[n] == '.' {
+			line, m, err := readLine(b[n:])
+			if m == 0 || err != nil {
+				return 0, v, err
+			}
+			if len(line) != 0 {
+				return 0, v, errInvalidMessage
+			}
+			if v.Type == Map && len(v.Elems)%2 != 0 {
+				return 0, v, &errProtocol{"map without a value"}
+			}
+			return n + m, v, nil
+		}
+		m, elem, err := readValue(b[n:], depth+1)
+		if m == 0 || err != nil {
+			return 0, v, err
+		}
+		v.Elems = append(v.Elems, elem)
+		n += m
+	}
+}
@@ -0,0 +1,238 @@
+package resp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		raw string
+		exp Value
+	}{
+		{"+OK\r\n", Value{Type: String, Data: []byte("OK")}},
+		{"-ERR bad\r\n", Value{Type: Error, Data: []byte("ERR bad")}},
+		{":-12\r\n", Value{Type: Integer, Data: []byte("-12")}},
+		{"$5\r\nhe\r\no\r\n", Value{Type: Bulk, Data: []byte("he\r\no")}},
+		{"$0\r\n\r\n", Value{Type: Bulk, Data: []byte{}}},
+		{"$-1\r\n", Value{Type: Bulk, Null: true}},
+		{"*-1\r\n", Value{Type: Array, Null: true}},
+		{"_\r\n", Value{Type: Null, Null: true}},
+		{",1.5\r\n", Value{Type: Double, Data: []byte("1.5")}},
+		{"#t\r\n", Value{Type: Boolean, Data: []byte("t")}},
+		{"(12345678901234567890\r\n",
+			Value{Type: BigNumber, Data: []byte("12345678901234567890")}},
+		{"!9\r\nERR\r\nbad!\r\n",
+			Value{Type: BlobError, Data: []byte("ERR\r\nbad!")}},
+		{"=9\r\ntxt:hello\r\n",
+			Value{Type: Verbatim, Data: []byte("txt:hello")}},
+		{"*2\r\n:1\r\n$1\r\na\r\n", Value{Type: Array, Elems: []Value{
+			{Type: Integer, Data: []byte("1")},
+			{Type: Bulk, Data: []byte("a")},
+		}}},
+		{"%1\r\n+key\r\n#f\r\n", Value{Type: Map, Elems: []Value{
+			{Type: String, Data: []byte("key")},
+			{Type: Boolean, Data: []byte("f")},
+		}}},
+		{"~1\r\n_\r\n", Value{Type: Set, Elems: []Value{
+			{Type: Null, Null: true},
+		}}},
+		{">2\r\n+message\r\n+hi\r\n", Value{Type: Push, Elems: []Value{
+			{Type: String, Data: []byte("message")},
+			{Type: String, Data: []byte("hi")},
+		}}},
+		{"|1\r\n+ttl\r\n:10\r\n+value\r\n", Value{Type: String,
+			Data: []byte("value"), Attrs: []Value{
+				{Type: String, Data: []byte("ttl")},
+				{Type: Integer, Data: []byte("10")},
+			}}},
+		{"$?\r\n;2\r\nab\r\n;1\r\nc\r\n;0\r\n",
+			Value{Type: Bulk, Data: []byte("abc")}},
+		{"*?\r\n:1\r\n*?\r\n.\r\n.\r\n", Value{Type: Array, Elems: []Value{
+			{Type: Integer, Data: []byte("1")},
+			{Type: Array},
+		}}},
+		{"%?\r\n+a\r\n:1\r\n.\r\n", Value{Type: Map, Elems: []Value{
+			{Type: String, Data: []byte("a")},
+			{Type: Integer, Data: []byte("1")},
+		}}},
+	}
+	for _, tt := range tests {
+		// incomplete values are not read
+		for i := 0; i < len(tt.raw); i++ {
+			n, _, err := ReadValue([]byte(tt.raw[:i]))
+			if n != 0 || err != nil {
+				t.Fatalf("%q: expected an incomplete value, got %d, %v",
+					tt.raw[:i], n, err)
+			}
+		}
+		n, v, err := ReadValue([]byte(tt.raw + "+next\r\n"))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.raw, err)
+		}
+		if n != len(tt.raw) {
+			t.Fatalf("%q: expected %d, got %d", tt.raw, len(tt.raw), n)
+		}
+		if !valueEqual(v, tt.exp) {
+			t.Fatalf("%q: expected %+v, got %+v", tt.raw, tt.exp, v)
+		}
+	}
+}
+
+func TestReadValueErrors(t *testing.T) {
+	for _, raw := range []string{
+		"?\r\n",
+		"+OK\n",
+		":1a\r\n",
+		"#x\r\n",
+		"_x\r\n",
+		"$x\r\n",
+		"$-2\r\n",
+		"$2\r\nabc\r\n",
+		"!-1\r\n",
+		"=3\r\ntxt\r\n",
+		"*-2\r\n",
+		"%-1\r\n",
+		"*1\r\n?\r\n",
+		"$?\r\n:1\r\n",
+		"%?\r\n+a\r\n.\r\n",
+		"*?\r\n.x\r\n",
+		"*99999999999999999999\r\n",
+	} {
+		if _, _, err := ReadValue([]byte(raw)); err == nil {
+			t.Fatalf("%q: expected an error", raw)
+		}
+	}
+	var deep []byte
+	for i := 0; i <= maxValueDepth+1; i++ {
+		deep = append(deep, "*1\r\n"...)
+	}
+	if _, _, err := ReadValue(deep); err != errValueTooDeep {
+		t.Fatalf("expected %v, got %v", errValueTooDeep, err)
+	}
+}
+
+func valueEqual(a, b Value) bool {
+	if a.Type != b.Type || a.Null != b.Null || string(a.Data) != string(b.Data) ||
+		(a.Data == nil) != (b.Data == nil) || len(a.Elems) != len(b.Elems) ||
+		len(a.Attrs) != len(b.Attrs) {
+		return false
+	}
+	for i := range a.Elems {
+		if !valueEqual(a.Elems[i], b.Elems[i]) {
+			return false
+		}
+	}
+	for i := range a.Attrs {
+		if !valueEqual(a.Attrs[i], b.Attrs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValueAccessors(t *testing.T) {
+	read := func(raw string) Value {
+		t.Helper()
+		n, v, err := ReadValue([]byte(raw))
+		if n != len(raw) || err != nil {
+			t.Fatalf("%q: %d, %v", raw, n, err)
+		}
+		return v
+	}
+	tests := []struct {
+		raw string
+		s   string
+		i   int64
+		f   float64
+		b   bool
+	}{
+		{"+OK\r\n", "OK", 0, 0, false},
+		{":-12\r\n", "-12", -12, -12, true},
+		{"$3\r\n1.5\r\n", "1.5", 1, 1.5, true},
+		{",-inf\r\n", "-inf", 0, math.Inf(-1), true},
+		{"#t\r\n", "t", 1, 1, true},
+		{"#f\r\n", "f", 0, 0, false},
+		{"_\r\n", "", 0, 0, false},
+		{"$-1\r\n", "", 0, 0, false},
+		{"=9\r\ntxt:hello\r\n", "hello", 0, 0, false},
+		{"(99999999999999999999\r\n", "99999999999999999999", 0, 1e20, true},
+		{"*3\r\n:1\r\n*1\r\n+a\r\n*0\r\n", "[1 [a] []]", 0, 0, false},
+	}
+	for _, tt := range tests {
+		v := read(tt.raw)
+		if v.String() != tt.s || v.Int() != tt.i || v.Float() != tt.f ||
+			v.Bool() != tt.b {
+			t.Fatalf("%q: expected %q %d %v %t, got %q %d %v %t", tt.raw,
+				tt.s, tt.i, tt.f, tt.b, v.String(), v.Int(), v.Float(),
+				v.Bool())
+		}
+	}
+	if elems := read("~2\r\n:1\r\n:2\r\n").Array(); len(elems) != 2 ||
+		elems[1].Int() != 2 {
+		t.Fatalf("unexpected elements %v", elems)
+	}
+	if elems := read("+OK\r\n").Array(); elems != nil {
+		t.Fatalf("unexpected elements %v", elems)
+	}
+	for _, raw := range []string{"%2\r\n+a\r\n:1\r\n+b\r\n:2\r\n",
+		"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"} {
+		m := read(raw).Map()
+		if len(m) != 2 || m["a"].Int() != 1 || m["b"].Int() != 2 {
+			t.Fatalf("%q: unexpected map %v", raw, m)
+		}
+	}
+	if m := read("*1\r\n:1\r\n").Map(); m != nil {
+		t.Fatalf("unexpected map %v", m)
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		exp2 string
+		exp3 string
+	}{
+		{"+OK\r\n", "+OK\r\n", ""},
+		{"-ERR bad\r\n", "-ERR bad\r\n", ""},
+		{":1\r\n", ":1\r\n", ""},
+		{"$1\r\na\r\n", "$1\r\na\r\n", ""},
+		{"$-1\r\n", "$-1\r\n", "_\r\n"},
+		{"*-1\r\n", "*-1\r\n", "_\r\n"},
+		{"_\r\n", "$-1\r\n", ""},
+		{",1.5\r\n", "$3\r\n1.5\r\n", ""},
+		{"#t\r\n", ":1\r\n", ""},
+		{"(123\r\n", "$3\r\n123\r\n", ""},
+		{"!7\r\nERR\r\nno\r\n", "-ERR  no\r\n", ""},
+		{"=9\r\ntxt:hello\r\n", "$5\r\nhello\r\n", ""},
+		{"*2\r\n:1\r\n#f\r\n", "*2\r\n:1\r\n:0\r\n", ""},
+		{"%1\r\n+a\r\n:1\r\n", "*2\r\n+a\r\n:1\r\n", ""},
+		{"~1\r\n:1\r\n", "*1\r\n:1\r\n", ""},
+		{">1\r\n:1\r\n", "*1\r\n:1\r\n", ""},
+		{"|1\r\n+a\r\n:1\r\n+OK\r\n", "+OK\r\n", ""},
+	}
+	for _, tt := range tests {
+		_, v, err := ReadValue([]byte(tt.raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.exp3 == "" {
+			tt.exp3 = tt.raw
+		}
+		if b := v.MarshalRESP(); string(b) != tt.exp3 {
+			t.Fatalf("expected %q, got %q", tt.exp3, b)
+		}
+		if b := AppendValue(nil, RESP2, v); string(b) != tt.exp2 {
+			t.Fatalf("expected %q, got %q", tt.exp2, b)
+		}
+		if b := AppendAny(nil, v); string(b) != tt.exp2 {
+			t.Fatalf("expected %q, got %q", tt.exp2, b)
+		}
+		if b := AppendAnyProto(nil, RESP3, &v); string(b) != tt.exp3 {
+			t.Fatalf("expected %q, got %q", tt.exp3, b)
+		}
+	}
+	if b := AppendValue(nil, RESP3, Value{}); string(b) != "_\r\n" {
+		t.Fatalf("expected %q, got %q", "_\r\n", b)
+	}
+}
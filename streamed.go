@@ -0,0 +1,73 @@
+package redcon
+
+import (
+	"strconv"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+// StreamedBulk writes a bulk string whose length is not known up front, as a
+// RESP3 streamed string. Each Write is sent as a chunk, and Close writes the
+// terminator. Use BeginStreamedBulk to create one.
+//
+//   sb := conn.BeginStreamedBulk()
+//   io.Copy(sb, file)
+//   sb.Close()
+//
+// Streamed strings only exist in RESP3. For RESP2 clients the chunks are
+// buffered, and Close writes them as a single bulk string.
+type StreamedBulk struct {
+	w      *Writer
+	resp3  bool
+	buf    []byte // the buffered chunks for RESP2
+	closed bool
+}
+
+// BeginStreamedBulk starts a bulk string whose length is not known up front.
+// Call Close on the returned StreamedBulk for completing the string.
+func (w *Writer) BeginStreamedBulk() *StreamedBulk {
+	sb := &StreamedBulk{w: w, resp3: w.Protocol() >= resp.RESP3}
+	if sb.resp3 {
+		w.WriteRaw([]byte("$?\r\n"))
+	}
+	return sb
+}
+
+// Write writes p as a chunk of the string. Empty chunks are skipped, because
+// an empty chunk terminates the string. The chunks are sent to the client
+// when the writer is flushed. Returns the error of the writer, if any.
+func (sb *StreamedBulk) Write(p []byte) (int, error) {
+	if sb.closed {
+		return 0, errStreamClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !sb.resp3 {
+		sb.buf = append(sb.buf, p...)
+		return len(p), nil
+	}
+	b := make([]byte, 0, len(p)+24)
+	b = append(b, ';')
+	b = strconv.AppendInt(b, int64(len(p)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, p...)
+	b = append(b, '\r', '\n')
+	sb.w.WriteRaw(b)
+	return len(p), sb.w.Err()
+}
+
+// Close completes the string. Calling Close more than once has no effect.
+func (sb *StreamedBulk) Close() error {
+	if sb.closed {
+		return nil
+	}
+	sb.closed = true
+	if sb.resp3 {
+		sb.w.WriteRaw([]byte(";0\r\n"))
+	} else {
+		sb.w.WriteBulk(sb.buf)
+		sb.buf = nil
+	}
+	return sb.w.Err()
+}
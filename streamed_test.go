@@ -0,0 +1,35 @@
+package redcon
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamedBulk(t *testing.T) {
+	var out bytes.Buffer
+	wr := NewWriter(&out)
+	write := func() {
+		sb := wr.BeginStreamedBulk()
+		sb.Write([]byte("Hello "))
+		sb.Write(nil)
+		io.Copy(sb, strings.NewReader("world"))
+		if err := sb.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sb.Write([]byte("!")); err != errStreamClosed {
+			t.Fatalf("expected %v, got %v", errStreamClosed, err)
+		}
+		sb.Close()
+	}
+	write()
+	wr.SetProtocol(3)
+	write()
+	wr.Flush()
+	exp := "$11\r\nHello world\r\n" +
+		"$?\r\n;6\r\nHello \r\n;5\r\nworld\r\n;0\r\n"
+	if out.String() != exp {
+		t.Fatalf("expected %q, got %q", exp, out.String())
+	}
+}
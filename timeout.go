@@ -0,0 +1,101 @@
+package redcon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TimeoutHandler returns a Handler that runs handler with a time limit for
+// each command, like the http.TimeoutHandler of the standard library. When
+// the handler does not return within dur, the client is sent the error msg,
+// or "ERR command timed out" when msg is empty, such as "BUSY ..." for a
+// script. The reply of the handler is buffered until it returns, and is
+// discarded when the time limit is exceeded.
+//
+//   s := redcon.NewServerHandler(addr,
+//       redcon.TimeoutHandler(mux, time.Second, ""), nil, nil)
+//
+// The handler keeps running after the time limit, because a goroutine can't
+// be stopped, but the context that ConnContext returns to the handler is
+// canceled, so the handler should pass it to the work that may take long.
+// Handlers must not call Detach, ReadPipeline, or PeekPipeline, and Flush
+// has no effect. A handler may return a ReplyPromise, in which case the
+// time limit applies until the promise is fulfilled, and the context stays
+// valid until then.
+func TimeoutHandler(handler Handler, dur time.Duration, msg string) Handler {
+	if msg == "" {
+		msg = "ERR command timed out"
+	}
+	return HandlerFunc(func(cn Conn, cmd Command) {
+		var c *conn
+		switch v := cn.(type) {
+		case *conn:
+			c = v
+		case *slotConn:
+			c = v.conn
+		default:
+			handler.ServeRESP(cn, cmd)
+			return
+		}
+		ctx, cancel := context.WithTimeout(ConnContext(cn), dur)
+		// the reply is written to a slot that is not pending, and copied
+		// to the connection when the handler returns in time.
+		slot := &replySlot{}
+		slot.wr = NewWriter(&slot.buf)
+		slot.wr.SetProtocol(c.wr.Protocol())
+		// a promise of the handler is a promise of the outer connection,
+		// so it takes the place of the command in the pending replies.
+		var mu sync.Mutex
+		var promise *ReplyPromise
+		var expired bool
+		sc := &slotConn{conn: c, slot: slot, ctx: ctx}
+		sc.promise = func() *ReplyPromise {
+			mu.Lock()
+			defer mu.Unlock()
+			if expired {
+				// the reply is discarded
+				return &ReplyPromise{c: c, slot: slot}
+			}
+			if promise == nil {
+				promise = NewReplyPromise(cn)
+			}
+			return promise
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.ServeRESP(sc, cmd)
+			slot.wr.Flush()
+		}()
+		var timedOut bool
+		select {
+		case <-done:
+		case <-ctx.Done():
+			timedOut = true
+		}
+		mu.Lock()
+		expired = true
+		p := promise
+		mu.Unlock()
+		switch {
+		case p != nil && !timedOut:
+			// the time limit applies until the promise is fulfilled
+			go func() {
+				<-ctx.Done()
+				cancel()
+				if ctx.Err() == context.DeadlineExceeded {
+					p.Fulfill(func(w *Writer) { w.WriteError(msg) })
+				}
+			}()
+			return
+		case p != nil:
+			p.Fulfill(func(w *Writer) { w.WriteError(msg) })
+		case timedOut:
+			cn.WriteError(msg)
+		default:
+			cn.WriteRaw(slot.buf.Bytes())
+		}
+		cancel()
+	})
+}
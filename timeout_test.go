@@ -0,0 +1,89 @@
+package redcon
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	errs := make(chan error, 1)
+	handler := HandlerFunc(func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "sleep":
+			ctx := ConnContext(conn)
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			case <-time.After(time.Second * 5):
+				errs <- nil
+			}
+			conn.WriteString("LATE")
+		default:
+			conn.WriteArray(2)
+			conn.WriteString("OK")
+			conn.WriteInt(conn.Protocol())
+		}
+	})
+	for _, msg := range []string{"", "BUSY busy"} {
+		s := NewServerHandler("", TimeoutHandler(handler,
+			time.Millisecond*50, msg), nil, nil)
+		c, err := net.Dial("tcp", testServe(t, s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		exp := "-ERR command timed out\r\n*2\r\n+OK\r\n:2\r\n"
+		if msg != "" {
+			exp = "-" + msg + "\r\n*2\r\n+OK\r\n:2\r\n"
+		}
+		resp := testDo(t, c, "sleep\r\nfast\r\n")
+		for len(resp) < len(exp) {
+			resp += testDo(t, c, "")
+		}
+		if resp != exp {
+			t.Fatalf("expected %q, got %q", exp, resp)
+		}
+		if err := <-errs; err != context.DeadlineExceeded {
+			t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	}
+}
+
+func TestTimeoutHandlerPromise(t *testing.T) {
+	handler := HandlerFunc(func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "later":
+			p := NewReplyPromise(conn)
+			ctx := ConnContext(conn)
+			go func() {
+				time.Sleep(time.Millisecond * 20)
+				p.Fulfill(func(w *Writer) {
+					w.WriteAny(ctx.Err())
+				})
+			}()
+		case "never":
+			NewReplyPromise(conn)
+		default:
+			conn.WriteString(string(cmd.Args[0]))
+		}
+	})
+	s := NewServerHandler("", TimeoutHandler(handler, time.Millisecond*200,
+		""), nil, nil)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// the replies are in the order of the commands, and an unfulfilled
+	// promise times out
+	exp := "$-1\r\n+a\r\n-ERR command timed out\r\n+b\r\n"
+	resp := testDo(t, c, "later\r\na\r\nnever\r\nb\r\n")
+	for len(resp) < len(exp) {
+		resp += testDo(t, c, "")
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
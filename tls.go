@@ -0,0 +1,81 @@
+package redcon
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ticketKeyRand is the source of the session ticket keys. Replaced by tests.
+var ticketKeyRand = rand.Reader
+
+// RotateSessionTicketKeys starts rotating the TLS session ticket keys in the
+// background. A new random key is generated every interval, and the previous
+// keep-1 keys are retained so that tickets issued before a rotation can still
+// be used to resume a session. Session resumption greatly reduces the cost of
+// reconnecting clients, because resumed sessions skip the full handshake.
+//
+// Resumption is enabled by default, unless SessionTicketsDisabled is set in
+// the server tls.Config. Without rotation, crypto/tls uses keys that are
+// randomly generated when the server starts, which means that tickets can't
+// be shared between server instances and are never rotated.
+//
+// TLS 1.3 0-RTT (early data) is not supported by crypto/tls, so it cannot be
+// enabled for Redcon servers. Clients that request early data fall back to a
+// regular, or resumed, handshake.
+//
+// Call the returned stop function to stop rotating keys. It's safe to call
+// more than once, and from any goroutine. A failed rotation keeps the current
+// keys, and the error is passed to RotateError, if set, which must be set
+// before the call.
+func (s *TLSServer) RotateSessionTicketKeys(interval time.Duration, keep int,
+) (stop func(), err error) {
+	if s.config == nil {
+		return nil, errors.New("no tls config")
+	}
+	if interval <= 0 {
+		return nil, errors.New("invalid interval")
+	}
+	if keep < 1 {
+		keep = 1
+	}
+	rnd := ticketKeyRand
+	var keys [][32]byte
+	rotate := func() error {
+		var key [32]byte
+		if _, err := io.ReadFull(rnd, key[:]); err != nil {
+			return err
+		}
+		keys = append([][32]byte{key}, keys...)
+		if len(keys) > keep {
+			keys = keys[:keep]
+		}
+		s.config.SetSessionTicketKeys(keys)
+		return nil
+	}
+	if err := rotate(); err != nil {
+		return nil, err
+	}
+	onError := s.RotateError
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := rotate(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}, nil
+}
@@ -0,0 +1,128 @@
+package redcon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey,
+		key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der},
+			PrivateKey: key}},
+	}
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	s := NewServerNetworkTLS("tcp", "127.0.0.1:0",
+		func(conn Conn, cmd Command) { conn.WriteString("PONG") },
+		nil, nil, testTLSConfig(t))
+	stop, err := s.RotateSessionTicketKeys(time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	signal := make(chan error)
+	go s.ListenServeAndSignal(signal)
+	if err := <-signal; err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	config := &tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	for i := 0; i < 2; i++ {
+		c, err := tls.Dial("tcp", s.Addr().String(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(c, "PING\r\n")
+		buf := make([]byte, 64)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "+PONG\r\n" {
+			t.Fatalf("unexpected reply %q", buf[:n])
+		}
+		resumed := c.ConnectionState().DidResume
+		c.Close()
+		if resumed != (i == 1) {
+			t.Fatalf("connection %d: expected resumed=%v", i, i == 1)
+		}
+	}
+}
+
+func TestRotateSessionTicketKeysError(t *testing.T) {
+	s := NewServerNetworkTLS("tcp", "127.0.0.1:0",
+		func(conn Conn, cmd Command) {}, nil, nil, testTLSConfig(t))
+	errs := make(chan error, 1)
+	s.RotateError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	var mu sync.Mutex
+	var fail bool
+	ticketKeyRand = readerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return 0, errors.New("no entropy")
+		}
+		return rand.Read(p)
+	})
+	defer func() { ticketKeyRand = rand.Reader }()
+	stop, err := s.RotateSessionTicketKeys(time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	select {
+	case err := <-errs:
+		if err.Error() != "no entropy" {
+			t.Fatalf("expected %q, got %v", "no entropy", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	// stop can be called more than once, and concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop()
+		}()
+	}
+	wg.Wait()
+	stop()
+}
@@ -0,0 +1,194 @@
+package redcon
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// TrackingOptions are the options of the CLIENT TRACKING command.
+type TrackingOptions struct {
+	// BCast enables broadcasting mode, where the client is sent the
+	// invalidations of all keys that start with one of the Prefixes, or of
+	// all keys when there are no prefixes, whether or not it read them.
+	BCast    bool
+	Prefixes []string
+	// NoLoop skips the invalidations of keys that were modified by the
+	// client itself, see InvalidateFrom.
+	NoLoop bool
+}
+
+// Tracking implements the server side of client-side caching, like the
+// CLIENT TRACKING command of Redis. It records the keys that each tracking
+// client has read, and sends invalidate push messages to the clients when
+// the keys are modified.
+//
+//   var tracking redcon.Tracking
+//   ...
+//   case "client": // CLIENT TRACKING ON
+//       err := tracking.Enable(conn, redcon.TrackingOptions{})
+//   case "get":
+//       tracking.Track(conn, key)
+//   case "set":
+//       tracking.Invalidate(key)
+//
+// Tracking requires RESP3, because the invalidations are sent as push
+// messages. Connections are removed when they are closed.
+type Tracking struct {
+	mu      sync.Mutex
+	clients map[Conn]*trackedClient
+	keys    map[string]map[*trackedClient]struct{}
+}
+
+type trackedClient struct {
+	conn Conn
+	opts TrackingOptions
+	keys map[string]struct{}
+}
+
+var errTrackingRESP2 = errors.New("ERR client tracking requires RESP3, " +
+	"use HELLO 3")
+
+// Enable enables tracking for the connection, or changes the options when
+// it's already enabled. Changing the options forgets the keys that have been
+// read. Returns an error when the connection does not use RESP3. The message
+// of the error is the reply that Redis sends to the client.
+func (t *Tracking) Enable(conn Conn, opts TrackingOptions) error {
+	if conn.Protocol() < 3 {
+		return errTrackingRESP2
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients == nil {
+		t.clients = make(map[Conn]*trackedClient)
+		t.keys = make(map[string]map[*trackedClient]struct{})
+	}
+	if tc := t.clients[conn]; tc != nil {
+		t.forget(tc)
+		tc.opts = opts
+		return nil
+	}
+	tc := &trackedClient{conn: conn, opts: opts}
+	t.clients[conn] = tc
+	go func() {
+		<-conn.ClosedChan()
+		t.Disable(conn)
+	}()
+	return nil
+}
+
+// Disable disables tracking for the connection.
+func (t *Tracking) Disable(conn Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tc := t.clients[conn]; tc != nil {
+		t.forget(tc)
+		delete(t.clients, conn)
+	}
+}
+
+// Enabled returns true when tracking is enabled for the connection.
+func (t *Tracking) Enabled(conn Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clients[conn] != nil
+}
+
+// forget removes the keys that have been read by the client.
+func (t *Tracking) forget(tc *trackedClient) {
+	for key := range tc.keys {
+		delete(t.keys[key], tc)
+		if len(t.keys[key]) == 0 {
+			delete(t.keys, key)
+		}
+	}
+	tc.keys = nil
+}
+
+// Track records that the connection has read the keys. It has no effect
+// when tracking is not enabled for the connection, or when it uses
+// broadcasting mode.
+func (t *Tracking) Track(conn Conn, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc := t.clients[conn]
+	if tc == nil || tc.opts.BCast {
+		return
+	}
+	if tc.keys == nil {
+		tc.keys = make(map[string]struct{})
+	}
+	for _, key := range keys {
+		tc.keys[key] = struct{}{}
+		clients := t.keys[key]
+		if clients == nil {
+			clients = make(map[*trackedClient]struct{})
+			t.keys[key] = clients
+		}
+		clients[tc] = struct{}{}
+	}
+}
+
+// Invalidate sends an invalidate message for the keys to the clients that
+// have read them, and to the broadcasting clients with a matching prefix.
+// A client is sent the invalidation of a key that it read only once, until
+// it reads the key again.
+func (t *Tracking) Invalidate(keys ...string) {
+	t.InvalidateFrom(nil, keys...)
+}
+
+// InvalidateFrom is like Invalidate for keys that were modified by the
+// connection from, which is not sent the invalidations when it uses the
+// NoLoop option.
+func (t *Tracking) InvalidateFrom(from Conn, keys ...string) {
+	t.mu.Lock()
+	pending := make(map[*trackedClient][]string)
+	for _, key := range keys {
+		for tc := range t.keys[key] {
+			delete(tc.keys, key)
+			pending[tc] = append(pending[tc], key)
+		}
+		delete(t.keys, key)
+		for _, tc := range t.clients {
+			if tc.opts.BCast && hasPrefix(key, tc.opts.Prefixes) {
+				pending[tc] = append(pending[tc], key)
+			}
+		}
+	}
+	t.mu.Unlock()
+	for tc, keys := range pending {
+		if tc.opts.NoLoop && tc.conn == from {
+			continue
+		}
+		tc.conn.WritePush("invalidate", keys)
+	}
+}
+
+// InvalidateAll sends an invalidate message with a null key to all tracking
+// clients, which tells them to flush their caches, such as after FLUSHALL.
+func (t *Tracking) InvalidateAll() {
+	t.mu.Lock()
+	conns := make([]Conn, 0, len(t.clients))
+	for conn, tc := range t.clients {
+		t.forget(tc)
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+	for _, conn := range conns {
+		conn.WritePush("invalidate", nil)
+	}
+}
+
+// hasPrefix returns true when key starts with one of the prefixes, or when
+// there are no prefixes.
+func hasPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package redcon
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTracking(t *testing.T) {
+	var tracking Tracking
+	s := NewServer("", func(conn Conn, cmd Command) {
+		args := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = string(arg)
+		}
+		switch args[0] {
+		case "hello":
+			conn.SetProtocol(3)
+			conn.WriteString("OK")
+		case "tracking":
+			var opts TrackingOptions
+			for _, arg := range args[1:] {
+				switch arg {
+				case "bcast":
+					opts.BCast = true
+				case "noloop":
+					opts.NoLoop = true
+				default:
+					opts.Prefixes = append(opts.Prefixes, arg)
+				}
+			}
+			if err := tracking.Enable(conn, opts); err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			conn.WriteString("OK")
+		case "get":
+			tracking.Track(conn, args[1:]...)
+			conn.WriteNull()
+		case "set":
+			tracking.InvalidateFrom(conn, args[1:]...)
+			conn.WriteString("OK")
+		case "flushall":
+			tracking.InvalidateAll()
+			conn.WriteString("OK")
+		}
+	}, nil, nil)
+	addr := testServe(t, s)
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	expect := func(c net.Conn, cmd, exp string) {
+		t.Helper()
+		resp := testDo(t, c, cmd)
+		for len(resp) < len(exp) {
+			resp += testDo(t, c, "")
+		}
+		if resp != exp {
+			t.Fatalf("%q: expected %q, got %q", cmd, exp, resp)
+		}
+	}
+	a, b, c := dial(), dial(), dial()
+	expect(a, "tracking\r\n",
+		"-ERR client tracking requires RESP3, use HELLO 3\r\n")
+	expect(a, "hello\r\n", "+OK\r\n")
+	expect(a, "tracking\r\n", "+OK\r\n")
+	expect(b, "hello\r\n", "+OK\r\n")
+	expect(b, "tracking bcast noloop user:\r\n", "+OK\r\n")
+	expect(a, "get k1 user:1\r\n", "_\r\n")
+
+	// a is sent the keys it read once, and b is sent user:1 because of its
+	// prefix
+	expect(c, "set k1 k2 user:1\r\n", "+OK\r\n")
+	expect(a, "", ">2\r\n$10\r\ninvalidate\r\n*2\r\n$2\r\nk1\r\n"+
+		"$6\r\nuser:1\r\n")
+	expect(b, "", ">2\r\n$10\r\ninvalidate\r\n*1\r\n$6\r\nuser:1\r\n")
+	expect(c, "set k1\r\n", "+OK\r\n")
+
+	// b does not receive its own invalidations
+	expect(b, "set user:2\r\n", "+OK\r\n")
+	expect(a, "set user:1\r\n", "+OK\r\n")
+	expect(b, "", ">2\r\n$10\r\ninvalidate\r\n*1\r\n$6\r\nuser:1\r\n")
+
+	expect(c, "flushall\r\n", "+OK\r\n")
+	expect(a, "", ">2\r\n$10\r\ninvalidate\r\n_\r\n")
+	expect(b, "", ">2\r\n$10\r\ninvalidate\r\n_\r\n")
+
+	// closed connections are removed
+	a.Close()
+	for i := 0; ; i++ {
+		tracking.mu.Lock()
+		n := len(tracking.clients)
+		tracking.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("expected 1 client, got %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,227 @@
+package redcon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/tidwall/redcon/resp"
+)
+
+// SetWorkers makes the server handle commands using a pool of n worker
+// goroutines that is shared by all connections, instead of handling them on
+// the goroutine of each connection. The commands of a pipeline are handled
+// concurrently, so a slow command does not hold up the commands that come
+// after it, and the replies are still written in the order of the commands.
+// Use zero to disable the pool, which is the default. The setting applies to
+// connections that are accepted after the call.
+//
+// Because the commands of a pipeline may run at the same time and in any
+// order, handlers must not depend on the effects of the commands before them
+// in the same pipeline, and must not call Detach, ReadPipeline, or
+// PeekPipeline. Flush has no effect, because the reply can't be sent before
+// the replies to the commands before it, and Close closes the connection
+// once the reply has been sent. The connection state, such as the value of
+// SetContext, the name, and the protocol, is safe to access from handlers
+// that run at the same time, but a handler that reads and then updates the
+// state, such as a value stored with SetContext, must guard the update
+// itself.
+func (s *Server) SetWorkers(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n <= 0 {
+		s.workers = nil
+	} else {
+		s.workers = make(chan struct{}, n)
+	}
+}
+
+// replySlot holds the reply to a command until it can be written after the
+// replies to the commands before it, such as when the command is handled by a
+// worker, or when an earlier reply is a ReplyPromise.
+type replySlot struct {
+	buf      bytes.Buffer
+	wr       *Writer
+	done     bool // the reply is complete, guarded by conn.pmu
+	promised bool // the reply is written by a ReplyPromise
+}
+
+// newSlot adds a slot for the reply to the next command.
+func (c *conn) newSlot() *replySlot {
+	slot := &replySlot{}
+	slot.wr = NewWriter(&slot.buf)
+	slot.wr.SetProtocol(c.wr.Protocol())
+	c.pmu.Lock()
+	c.pending = append(c.pending, slot)
+	c.pmu.Unlock()
+	return slot
+}
+
+// hasPending returns true when there are replies that have not been
+// written yet.
+func (c *conn) hasPending() bool {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	return len(c.pending) > 0
+}
+
+// complete marks the reply of the slot as complete, and writes the replies
+// that no longer wait for an earlier reply.
+func (c *conn) complete(slot *replySlot) {
+	slot.wr.Flush()
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	slot.done = true
+	var n int
+	for ; n < len(c.pending) && c.pending[n].done; n++ {
+		c.wr.WriteRaw(c.pending[n].buf.Bytes())
+		c.pending[n] = nil
+	}
+	c.pending = c.pending[n:]
+	if len(c.pending) == 0 {
+		c.pending = nil
+		if c.drained != nil {
+			close(c.drained)
+			c.drained = nil
+		}
+	}
+}
+
+// waitPending waits until the pending replies have been written, or until
+// the connection is closed.
+func (c *conn) waitPending() {
+	c.pmu.Lock()
+	if len(c.pending) == 0 {
+		c.pmu.Unlock()
+		return
+	}
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+	}
+	drained := c.drained
+	c.pmu.Unlock()
+	select {
+	case <-drained:
+	case <-c.ClosedChan():
+	}
+}
+
+// handleSlot passes the command to the handler, whose reply is written to
+// the slot.
+func (c *conn) handleSlot(s *Server, cmd Command, slot *replySlot) {
+	c.handler(&slotConn{conn: c, slot: slot}, cmd)
+	if slot.promised {
+		return
+	}
+	if c.largeReply > 0 && s.LargeReply != nil {
+		if size := int(slot.wr.written()); size >= c.largeReply {
+			s.LargeReply(c, cmd, size)
+		}
+	}
+	c.complete(slot)
+}
+
+// dispatchWorkers passes the commands to the worker pool, and waits for them
+// to be handled. The replies are written in the order of the commands.
+func (c *conn) dispatchWorkers(s *Server, cmds []Command) {
+	var wg sync.WaitGroup
+	for _, cmd := range cmds {
+		if c.isDetached() || c.isClosing() || c.isClosed() {
+			break
+		}
+		slot := c.newSlot()
+		if !c.prepare(s, cmd, slot.wr) {
+			c.complete(slot)
+			continue
+		}
+		c.workers <- struct{}{}
+		wg.Add(1)
+		go func(cmd Command) {
+			defer func() {
+				<-c.workers
+				wg.Done()
+			}()
+			c.handleSlot(s, cmd, slot)
+		}(cmd)
+	}
+	wg.Wait()
+}
+
+// slotConn is the connection that is passed to a handler that runs on a
+// worker, or with a time limit. The replies are written to the slot of the
+// command.
+type slotConn struct {
+	*conn
+	slot    *replySlot
+	ctx     context.Context      // the context of the command, if any
+	promise func() *ReplyPromise // creates the promises, if set
+}
+
+func (sc *slotConn) WriteString(str string) { sc.slot.wr.WriteString(str) }
+func (sc *slotConn) WriteBulk(bulk []byte)  { sc.slot.wr.WriteBulk(bulk) }
+func (sc *slotConn) WriteBulkString(bulk string) {
+	sc.slot.wr.WriteBulkString(bulk)
+}
+func (sc *slotConn) WriteInt(num int)       { sc.slot.wr.WriteInt(num) }
+func (sc *slotConn) WriteInt64(num int64)   { sc.slot.wr.WriteInt64(num) }
+func (sc *slotConn) WriteUint64(num uint64) { sc.slot.wr.WriteUint64(num) }
+func (sc *slotConn) WriteError(msg string)  { sc.slot.wr.WriteError(msg) }
+func (sc *slotConn) WriteFloat(f float64)   { sc.slot.wr.WriteFloat(f) }
+func (sc *slotConn) WriteBool(v bool)       { sc.slot.wr.WriteBool(v) }
+func (sc *slotConn) WriteBigNumber(num *big.Int) {
+	sc.slot.wr.WriteBigNumber(num)
+}
+func (sc *slotConn) WriteBigNumberString(num string) {
+	sc.slot.wr.WriteBigNumberString(num)
+}
+func (sc *slotConn) WriteArray(count int)     { sc.slot.wr.WriteArray(count) }
+func (sc *slotConn) BeginArray() *ArrayWriter { return sc.slot.wr.BeginArray() }
+func (sc *slotConn) BeginStreamedArray() *ArrayWriter {
+	return sc.slot.wr.BeginStreamedArray()
+}
+func (sc *slotConn) BeginStreamedMap() *ArrayWriter {
+	return sc.slot.wr.BeginStreamedMap()
+}
+func (sc *slotConn) BeginStreamedBulk() *StreamedBulk {
+	return sc.slot.wr.BeginStreamedBulk()
+}
+func (sc *slotConn) WriteMap(count int) { sc.slot.wr.WriteMap(count) }
+func (sc *slotConn) WriteSet(count int) { sc.slot.wr.WriteSet(count) }
+func (sc *slotConn) WriteAttribute(pairs int, fn func(w *Writer)) {
+	sc.slot.wr.WriteAttribute(pairs, fn)
+}
+func (sc *slotConn) WriteMapStringString(m map[string]string) {
+	sc.slot.wr.WriteMapStringString(m)
+}
+func (sc *slotConn) WriteNull()      { sc.slot.wr.WriteNull() }
+func (sc *slotConn) WriteNullBulk()  { sc.slot.wr.WriteNullBulk() }
+func (sc *slotConn) WriteNullArray() { sc.slot.wr.WriteNullArray() }
+func (sc *slotConn) WriteBulkFrom(r io.Reader, n int64) error {
+	return sc.slot.wr.WriteBulkFrom(r, n)
+}
+func (sc *slotConn) WriteBulkOrNull(bulk []byte, ok bool) {
+	sc.slot.wr.WriteBulkOrNull(bulk, ok)
+}
+func (sc *slotConn) WriteBulkArray(bulks [][]byte) {
+	sc.slot.wr.WriteBulkArray(bulks)
+}
+func (sc *slotConn) WriteRaw(data []byte)   { sc.slot.wr.WriteRaw(data) }
+func (sc *slotConn) WriteAny(v interface{}) { sc.slot.wr.WriteAny(v) }
+func (sc *slotConn) Flush() error           { return nil }
+
+// Close closes the connection once the reply has been sent, like
+// CloseAfterFlush, because the reply is written to the connection after the
+// handler returns.
+func (sc *slotConn) Close() error {
+	sc.CloseAfterFlush()
+	return nil
+}
+func (sc *slotConn) Protocol() int {
+	return int(sc.slot.wr.Protocol())
+}
+func (sc *slotConn) SetProtocol(proto int) {
+	sc.slot.wr.SetProtocol(resp.Protocol(proto))
+	sc.conn.SetProtocol(proto)
+}
@@ -0,0 +1,85 @@
+package redcon
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWorkers(t *testing.T) {
+	release := make(chan struct{})
+	s := NewServer("", func(conn Conn, cmd Command) {
+		switch string(cmd.Args[0]) {
+		case "wait":
+			select {
+			case <-release:
+				conn.WriteString("waited")
+			case <-time.After(time.Second * 5):
+				conn.WriteError("ERR timeout")
+			}
+		case "release":
+			close(release)
+			conn.WriteString("released")
+		case "proto":
+			conn.WriteInt(conn.Protocol())
+		default:
+			conn.WriteBulk(cmd.Args[0])
+		}
+	}, nil, nil)
+	s.SetWorkers(4)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the first command completes once the second command has run
+	exp := "+waited\r\n+released\r\n$1\r\na\r\n:2\r\n"
+	var resp string
+	for resp == "" || len(resp) < len(exp) && resp == exp[:len(resp)] {
+		if resp == "" {
+			resp = testDo(t, c, "wait\r\nrelease\r\na\r\nproto\r\n")
+		} else {
+			resp += testDo(t, c, "")
+		}
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}
+
+func TestWorkersConnState(t *testing.T) {
+	// the commands of a pipeline access the connection state at the same
+	// time, which is checked by the race detector
+	s := NewServer("", func(conn Conn, cmd Command) {
+		arg := string(cmd.Args[0])
+		conn.SetContext(arg)
+		conn.SetName(arg)
+		conn.SetProtocol(2)
+		if conn.Context() == nil || conn.Name() == "" ||
+			conn.Protocol() != 2 {
+			conn.WriteError("ERR unexpected state")
+			return
+		}
+		conn.WriteString("OK")
+	}, nil, nil)
+	s.SetWorkers(4)
+	c, err := net.Dial("tcp", testServe(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var req, exp string
+	for i := 0; i < 16; i++ {
+		req += "cmd" + strconv.Itoa(i) + "\r\n"
+		exp += "+OK\r\n"
+	}
+	resp := testDo(t, c, req)
+	for len(resp) < len(exp) {
+		resp += testDo(t, c, "")
+	}
+	if resp != exp {
+		t.Fatalf("expected %q, got %q", exp, resp)
+	}
+}